@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"time"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/api"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/parser"
+	t "github.com/Arthi-chaud/Meelo/scanner/internal/tasks"
+	"github.com/goccy/go-json"
+	"github.com/rs/zerolog"
+)
+
+const cliUsage = `Usage: scanner [command] [flags] [arguments]
+
+Commands:
+  (none)                 Start the HTTP server
+  scan [library]         Register new files of a library, or of all libraries
+  clean [library]        Delete missing files of a library, or of all libraries
+  refresh <selector>     Refresh the metadata of the selected files
+                         Exactly one of --library, --album, --release, --song or --track must be given
+  parse <file>           Parse a file and print its metadata. Does not need the API
+
+Flags must be given before arguments. Use 'scanner <command> -h' for the flags of a command.
+`
+
+// Runs a one-off command and returns the exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "scan":
+		return runLibraryCommand(args[1:], "scan", t.NewLibraryScanTask)
+	case "clean":
+		return runLibraryCommand(args[1:], "clean", t.NewLibraryCleanTask)
+	case "refresh":
+		return runRefreshCommand(args[1:])
+	case "parse":
+		return runParseCommand(args[1:])
+	case "help", "-h", "--help":
+		fmt.Fprint(os.Stdout, cliUsage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command '%s'\n\n%s", args[0], cliUsage)
+		return 2
+	}
+}
+
+func runLibraryCommand(args []string, command string, newTask func(api.Library, config.Config) t.Task) int {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only report what would be done")
+	verbose := flags.Bool("verbose", false, "show the logs of the task")
+	flags.Parse(args)
+	if flags.NArg() > 1 {
+		fmt.Fprintf(os.Stderr, "Expected at most one library\n")
+		return 2
+	}
+	setCliLogLevel(*verbose)
+	c := config.GetConfig()
+	if err := api.HealthCheck(c); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not connect to the API: %s\n", err.Error())
+		return 1
+	}
+	var libraries []api.Library
+	if flags.NArg() == 1 {
+		library, err := api.GetLibrary(context.Background(), c, flags.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not get library '%s': %s\n", flags.Arg(0), err.Error())
+			return 1
+		}
+		libraries = []api.Library{library}
+	} else {
+		allLibraries, err := api.GetAllLibraries(context.Background(), c)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not get libraries: %s\n", err.Error())
+			return 1
+		}
+		libraries = allLibraries
+	}
+	tasks := internal.Fmap(libraries, func(library api.Library, _ int) t.Task {
+		return newTask(library, c)
+	})
+	return runTasks(c, tasks, *dryRun)
+}
+
+func runRefreshCommand(args []string) int {
+	flags := flag.NewFlagSet("refresh", flag.ExitOnError)
+	selector := api.FileSelectorDto{}
+	flags.StringVar(&selector.Library, "library", "", "refresh files from library")
+	flags.StringVar(&selector.Album, "album", "", "refresh files from album")
+	flags.StringVar(&selector.Release, "release", "", "refresh files from release")
+	flags.StringVar(&selector.Song, "song", "", "refresh files from song")
+	flags.StringVar(&selector.Track, "track", "", "refresh file from track")
+	force := flags.Bool("force", false, "refresh files even if they have not changed")
+	dryRun := flags.Bool("dry-run", false, "only report what would be done")
+	verbose := flags.Bool("verbose", false, "show the logs of the task")
+	flags.Parse(args)
+	params := internal.Filter([]string{selector.Library, selector.Album, selector.Release, selector.Song, selector.Track}, func(p string) bool {
+		return len(p) > 0
+	})
+	if len(params) != 1 {
+		fmt.Fprintf(os.Stderr, "Expected exactly one of --library, --album, --release, --song or --track\n")
+		return 2
+	}
+	setCliLogLevel(*verbose)
+	c := config.GetConfig()
+	if err := api.HealthCheck(c); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not connect to the API: %s\n", err.Error())
+		return 1
+	}
+	return runTasks(c, []t.Task{t.NewMetadataRefreshTask(selector, *force, c)}, *dryRun)
+}
+
+func runParseCommand(args []string) int {
+	flags := flag.NewFlagSet("parse", flag.ExitOnError)
+	settingsPath := flags.String("settings", path.Join(os.Getenv("INTERNAL_CONFIG_DIR"), config.UserSettingsFileName), "path to the settings.json file")
+	verbose := flags.Bool("verbose", false, "show logs")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Expected exactly one file\n")
+		return 2
+	}
+	setCliLogLevel(*verbose)
+	userSettings, errs := config.GetUserSettings(*settingsPath)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		}
+		return 1
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	m, errs := parser.ParseMetadata(ctx, userSettings, flags.Arg(0))
+	output, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return 1
+	}
+	fmt.Fprintf(os.Stdout, "%s\n", output)
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// Runs the tasks one after the other, and prints their progress
+// Returns 1 if any task or file failed
+func runTasks(c config.Config, tasks []t.Task, dryRun bool) int {
+	w := t.NewWorker()
+	w.StartWorker(c)
+	defer w.StopWorker()
+	if notifier := setupWebhooks(c, w); notifier != nil {
+		// Deferred first, so that it runs after the worker is done
+		defer notifier.Stop()
+	}
+	events, unsubscribe := w.Subscribe()
+	defer unsubscribe()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	queuedTaskIds := map[string]bool{}
+	for _, task := range tasks {
+		if dryRun {
+			task = task.AsDryRun()
+		}
+		queuedTaskIds[w.AddTask(task).Id] = true
+	}
+	// Events can be dropped if we are too slow to print them
+	// So we also poll the tasks, to make sure we do not miss their end
+	poll := time.NewTicker(time.Second)
+	defer poll.Stop()
+	exitCode := 0
+	onTaskFinished := func(taskId string, status t.TaskStatus, report t.TaskReport) {
+		delete(queuedTaskIds, taskId)
+		if status != t.Done || report.Failed > 0 {
+			exitCode = 1
+		}
+		if dryRun && status == t.Done {
+			fmt.Fprintf(os.Stdout, "Dry-run report: %s\n", t.GetDryRunReportPath(c.ConfigDirectory, taskId))
+		}
+	}
+	for len(queuedTaskIds) > 0 {
+		select {
+		case <-poll.C:
+			for taskId := range queuedTaskIds {
+				record, found := w.GetTask(taskId)
+				if found && record.EndedAt != nil {
+					printTaskEvent(t.Event{Type: t.TaskFinished, TaskName: record.Name, Status: record.Status, Report: record.Report})
+					onTaskFinished(taskId, record.Status, record.Report)
+				}
+			}
+		case <-interrupt:
+			fmt.Fprintf(os.Stderr, "Interrupted. Cancelling tasks...\n")
+			for taskId := range queuedTaskIds {
+				w.CancelTask(taskId)
+			}
+		case event := <-events:
+			if !queuedTaskIds[event.TaskId] {
+				continue
+			}
+			printTaskEvent(event)
+			if event.Type == t.TaskFinished {
+				onTaskFinished(event.TaskId, event.Status, event.Report)
+			}
+		}
+	}
+	// Wait for the thumbnails
+	w.Wait()
+	if outboxSize := w.GetOutboxSize(); outboxSize > 0 {
+		fmt.Fprintf(os.Stderr, "%d operation(s) could not be sent to the API. They will be sent on the next start\n", outboxSize)
+	}
+	return exitCode
+}
+
+func printTaskEvent(event t.Event) {
+	switch event.Type {
+	case t.TaskStarted:
+		fmt.Fprintf(os.Stdout, "%s\n", event.TaskName)
+	case t.TaskProgress:
+		fmt.Fprintf(os.Stdout, "[%3d%%] %s\n", event.Progress, event.TaskName)
+	case t.FileFailed:
+		fmt.Fprintf(os.Stderr, "Failed: %s\n", event.File)
+		for _, err := range event.Errors {
+			fmt.Fprintf(os.Stderr, "  %s\n", err)
+		}
+	case t.TaskFinished:
+		fmt.Fprintf(os.Stdout, "%s: %s (successful: %d, failed: %d, skipped: %d)\n",
+			event.TaskName, event.Status, event.Report.Successful, event.Report.Failed, event.Report.Skipped)
+	}
+}
+
+// Only warnings and errors are logged, unless verbose is true or LOG_LEVEL is set
+func setCliLogLevel(verbose bool) {
+	if verbose {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	} else if _, isSet := os.LookupEnv("LOG_LEVEL"); !isSet {
+		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	}
+}
@@ -2,10 +2,12 @@ package main
 
 import (
 	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/scheduler"
 	t "github.com/Arthi-chaud/Meelo/scanner/internal/tasks"
 )
 
 type ScannerContext struct {
-	config *config.Config
-	worker *t.Worker
+	config    *config.Config
+	worker    *t.Worker
+	scheduler *scheduler.Scheduler
 }
@@ -2,12 +2,16 @@ package main
 
 import (
 	"os"
+	"strings"
 	"time"
 
 	_ "github.com/Arthi-chaud/Meelo/scanner/app/docs"
 	"github.com/Arthi-chaud/Meelo/scanner/internal/api"
 	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/scheduler"
 	"github.com/Arthi-chaud/Meelo/scanner/internal/tasks"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/watcher"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/webhook"
 	"github.com/labstack/echo/v4"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -16,6 +20,11 @@ import (
 
 const ApiHealthckechAttemptCount = 5
 
+const (
+	ConsoleLogFormat = "console"
+	JsonLogFormat    = "json"
+)
+
 // @title Meelo's Scanner API
 // @description The scanner is responsible for file parsing and registration.
 // @securityDefinitions.apikey JWT
@@ -24,43 +33,115 @@ const ApiHealthckechAttemptCount = 5
 // @description Prefix the value with `Bearer `
 func main() {
 	setupLogger()
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
 	c := config.GetConfig()
-	e := setupEcho(c)
+	w := tasks.NewWorker()
+	w.StartWorker(c)
+	setupWebhooks(c, w)
+	sc := setupScheduler(c, w)
+	e := setupEcho(c, w, sc)
 
 	waitForApi(c)
+	setupWatcher(c, w)
+	sc.Start()
 	e.Logger.Fatal(e.Start(":8133"))
 }
 
+// Sets up the global logger using the LOG_FORMAT and LOG_LEVEL environment variables
 func setupLogger() {
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	format := os.Getenv("LOG_FORMAT")
+	// The default logger already writes JSON
+	if format != JsonLogFormat {
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	}
+	// Used by tasks and the parser when the context does not have a logger
+	zerolog.DefaultContextLogger = &log.Logger
+	if format != "" && format != JsonLogFormat && format != ConsoleLogFormat {
+		log.Warn().Str("format", format).Msg("Unknown log format. Using console format.")
+	}
+	if level, isSet := os.LookupEnv("LOG_LEVEL"); isSet {
+		parsedLevel, err := zerolog.ParseLevel(strings.ToLower(level))
+		if err != nil || parsedLevel == zerolog.NoLevel {
+			log.Warn().Str("level", level).Msg("Unknown log level. Using default level.")
+			return
+		}
+		zerolog.SetGlobalLevel(parsedLevel)
+	}
 }
 
 // Sets up echo endpoints
-func setupEcho(c config.Config) *echo.Echo {
+func setupEcho(c config.Config, w *tasks.Worker, sc *scheduler.Scheduler) *echo.Echo {
 	e := echo.New()
 	e.HideBanner = true
 	e.HidePort = true
 
 	s := ScannerContext{
-		config: &c,
-		worker: tasks.NewWorker(),
+		config:    &c,
+		worker:    w,
+		scheduler: sc,
 	}
-	s.worker.StartWorker(c)
 
 	e.GET("/", s.Status)
 	e.GET("/tasks", s.Tasks)
+	e.GET("/tasks/history", s.TaskHistory)
+	e.GET("/tasks/events", s.TaskEvents)
+	e.GET("/tasks/:taskId", s.Task)
+	e.GET("/tasks/:taskId/dry-run", s.DryRunReport)
+	e.DELETE("/tasks/:taskId", s.CancelTask)
+	e.POST("/tasks/pause", s.PauseWorker)
+	e.POST("/tasks/resume", s.ResumeWorker)
+	e.GET("/schedules", s.Schedules)
+	e.GET("/metrics", s.Metrics)
 	e.GET("/", s.Status)
 	e.POST("/scan", s.ScanAll)
 	e.POST("/scan/:libraryId", s.Scan)
 	e.POST("/clean", s.Clean)
 	e.POST("/clean/:libraryId", s.CleanLibrary)
 	e.POST("/refresh", s.Refresh)
+	e.POST("/debug/parse", s.DebugParse)
 	e.GET("/swagger/*", echoSwagger.WrapHandler)
 	return e
 }
 
+// Sets up the scheduled tasks. Exits if the schedules are invalid
+func setupScheduler(c config.Config, w *tasks.Worker) *scheduler.Scheduler {
+	sc, err := scheduler.NewScheduler(c, w)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not set up the scheduled tasks")
+	}
+	return sc
+}
+
+// Starts watching the libraries, if enabled in the settings
+func setupWatcher(c config.Config, w *tasks.Worker) {
+	if !c.UserSettings.Watcher.Enabled {
+		return
+	}
+	fsWatcher, err := watcher.NewWatcher(c, w)
+	if err == nil {
+		err = fsWatcher.Start()
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Could not start watching the libraries")
+	}
+}
+
+// Starts sending events to the webhooks, if any
+// Returns nil if there is no webhook
+func setupWebhooks(c config.Config, w *tasks.Worker) *webhook.Notifier {
+	if len(c.UserSettings.Webhooks) == 0 {
+		return nil
+	}
+	notifier := webhook.NewNotifier(c.UserSettings.Webhooks)
+	notifier.Start(w)
+	return notifier
+}
+
 // hangs while API is not reachable.
-// after ApiHealthckechAttemptCount attempts, exits
+// after ApiHealthckechAttemptCount attempts, starts anyway.
+// Writes to the API are then queued until it is back
 func waitForApi(c config.Config) {
 	for i := 0; i < ApiHealthckechAttemptCount; i++ {
 		if err := api.HealthCheck(c); err != nil {
@@ -71,6 +152,5 @@ func waitForApi(c config.Config) {
 			return
 		}
 	}
-	log.Fatal().Msg("Could not connect to the API. Exiting...")
-	os.Exit(1)
+	log.Warn().Msg("Could not connect to the API. Starting anyway, API writes will be sent once it is back")
 }
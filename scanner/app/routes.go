@@ -1,14 +1,23 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"os"
+	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Arthi-chaud/Meelo/scanner/internal"
 	"github.com/Arthi-chaud/Meelo/scanner/internal/api"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/parser"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/scheduler"
 	t "github.com/Arthi-chaud/Meelo/scanner/internal/tasks"
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
 )
 
@@ -16,12 +25,101 @@ type ScannerStatus struct {
 	Message string `json:"message"`
 }
 
+type ScannerRunningTask struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	// 'interactive' or 'bulk'. Each lane runs one task at a time
+	Lane string `json:"lane"`
+	// Between 0 and 100
+	Progress  int       `json:"progress"`
+	StartedAt time.Time `json:"started_at"`
+}
+
 type ScannerTaskStatus struct {
-	// Name of the currently running task
+	// Name of the running task that started first
+	// Kept for compatibility, see 'running_tasks'
 	CurrentTask *string `json:"current_task"`
-	// Progress (0-100) of the running task. Can be null
-	Progress     *int     `json:"progress"`
-	PendingTasks []string `json:"pending_tasks"`
+	// Progress (0-100) of the running task that started first. Can be null
+	Progress *int `json:"progress"`
+	// Sorted by start date
+	RunningTasks []ScannerRunningTask `json:"running_tasks"`
+	PendingTasks []string             `json:"pending_tasks"`
+	// If true, no new task will be started, and the current one is on hold
+	Paused bool `json:"paused"`
+}
+
+type ScannerFileFailure struct {
+	// Full path of the file
+	Path   string   `json:"path"`
+	Errors []string `json:"errors"`
+}
+
+type ScannerTaskReport struct {
+	// Number of files successfully registered, updated or cleaned
+	Successful  int                  `json:"successful"`
+	Failed      int                  `json:"failed"`
+	Skipped     int                  `json:"skipped"`
+	FailedFiles []ScannerFileFailure `json:"failed_files"`
+}
+
+type ScannerTask struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	// 'interactive' or 'bulk'
+	Lane string `json:"lane"`
+	// One of 'pending', 'running', 'done', 'failed' or 'cancelled'
+	Status string `json:"status"`
+	// Between 0 and 100. For failed and cancelled tasks, the progress when they stopped
+	Progress int `json:"progress"`
+	// Null if the task is pending
+	StartedAt *time.Time `json:"started_at"`
+	// Null if the task is not finished
+	EndedAt *time.Time `json:"ended_at"`
+	// Error returned by the task, if it failed
+	Error  *string           `json:"error"`
+	Report ScannerTaskReport `json:"report"`
+}
+
+type ScannerTaskEvent struct {
+	// One of 'task-queued', 'task-started', 'progress', 'file-parsed', 'file-failed' or 'task-finished'
+	Type     string    `json:"type"`
+	TaskId   string    `json:"task_id"`
+	TaskName string    `json:"task_name"`
+	Time     time.Time `json:"time"`
+	// For 'progress' events. A number between 0 and 100
+	Progress *int `json:"progress,omitempty"`
+	// For 'file-parsed' and 'file-failed' events. Full path of the file
+	File *string `json:"file,omitempty"`
+	// For 'file-failed' events
+	Errors []string `json:"errors,omitempty"`
+	// For 'task-finished' events
+	Status *string `json:"status,omitempty"`
+	// For 'task-finished' events. The list of failed files is not included
+	Report *ScannerTaskReport `json:"report,omitempty"`
+}
+
+type ScheduledTaskStatus struct {
+	// Type of task (scan, clean or refresh)
+	Task string `json:"task"`
+	// Slug of the library the task runs on. If null, the task runs on every library
+	Library *string   `json:"library"`
+	Cron    string    `json:"cron"`
+	NextRun time.Time `json:"next_run"`
+	// Null if the task did not run yet
+	PreviousRun *time.Time `json:"previous_run"`
+}
+
+type DebugParseRequest struct {
+	// Slug or ID of the library
+	Library string `json:"library"`
+	// Path of the file, relative to the library
+	Path string `json:"path"`
+}
+
+type ScannerTasksQueued struct {
+	Message string `json:"message"`
+	// If an identical task was already pending, its ID is given instead of a new one
+	TaskIds []string `json:"task_ids"`
 }
 
 const TaskAddedtoQueueMessage = "Task added to queue"
@@ -30,6 +128,72 @@ func logTaskAdded(task t.Task) {
 	log.Info().Str("name", task.Name).Msg(TaskAddedtoQueueMessage)
 }
 
+func tasksQueuedResponse(c echo.Context, tasks ...t.Task) error {
+	return c.JSON(http.StatusAccepted, ScannerTasksQueued{
+		Message: TaskAddedtoQueueMessage,
+		TaskIds: internal.Fmap(tasks, func(task t.Task, _ int) string {
+			return task.Id
+		}),
+	})
+}
+
+// Turns the task into a dry run if the 'dryRun' query parameter is true
+func withDryRun(c echo.Context, task t.Task) t.Task {
+	if dryRun, err := strconv.ParseBool(c.QueryParam("dryRun")); err == nil && dryRun {
+		return task.AsDryRun()
+	}
+	return task
+}
+
+func formatTaskEvent(event t.Event) ScannerTaskEvent {
+	formatted := ScannerTaskEvent{
+		Type:     string(event.Type),
+		TaskId:   event.TaskId,
+		TaskName: event.TaskName,
+		Time:     event.Time,
+	}
+	switch event.Type {
+	case t.TaskProgress:
+		formatted.Progress = &event.Progress
+	case t.FileParsed:
+		formatted.File = &event.File
+	case t.FileFailed:
+		formatted.File = &event.File
+		formatted.Errors = event.Errors
+	case t.TaskFinished:
+		status := string(event.Status)
+		formatted.Status = &status
+		formatted.Report = &ScannerTaskReport{
+			Successful:  event.Report.Successful,
+			Failed:      event.Report.Failed,
+			Skipped:     event.Report.Skipped,
+			FailedFiles: []ScannerFileFailure{},
+		}
+	}
+	return formatted
+}
+
+func formatTaskRecord(record t.TaskRecord) ScannerTask {
+	return ScannerTask{
+		Id:        record.Id,
+		Name:      record.Name,
+		Lane:      string(record.Lane),
+		Status:    string(record.Status),
+		Progress:  record.Progress,
+		StartedAt: record.StartedAt,
+		EndedAt:   record.EndedAt,
+		Error:     record.Error,
+		Report: ScannerTaskReport{
+			Successful: record.Report.Successful,
+			Failed:     record.Report.Failed,
+			Skipped:    record.Report.Skipped,
+			FailedFiles: internal.Fmap(record.Report.FailedFiles, func(f t.FileFailure, _ int) ScannerFileFailure {
+				return ScannerFileFailure{Path: f.Path, Errors: f.Errors}
+			}),
+		},
+	}
+}
+
 // @Tags        Tasks
 // @Summary		Get Status of Scanner
 // @Produce		json
@@ -40,117 +204,325 @@ func (s *ScannerContext) Status(c echo.Context) error {
 }
 
 // @Tags        Tasks
-// @Summary		Get Current + Pending Tasks
+// @Summary		Get Prometheus metrics
+// @Produce		plain
+// @Success		200	{string}	string
+// @Router	    /metrics [get]
+func (s *ScannerContext) Metrics(c echo.Context) error {
+	promhttp.Handler().ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+// @Tags        Tasks
+// @Summary		Get Running + Pending Tasks
 // @Produce		json
 // @Success		200 {object} ScannerTaskStatus
 // @Router	    /tasks [get]
 func (s *ScannerContext) Tasks(c echo.Context) error {
-	currentTask, progressValue, pendingTasks := s.worker.GetCurrentTasks()
-	formattedCurentTask := &currentTask.Name
-	progressPtr := &progressValue
-	if currentTask.Name == "" {
-		formattedCurentTask = nil
-		progressPtr = nil
+	runningTasks, pendingTasks := s.worker.GetCurrentTasks()
+	var formattedCurentTask *string
+	var progressPtr *int
+	if len(runningTasks) > 0 {
+		formattedCurentTask = &runningTasks[0].Name
+		progressPtr = &runningTasks[0].Progress
 	}
+	formattedRunningTasks := internal.Fmap(runningTasks, func(t t.RunningTaskInfo, _ int) ScannerRunningTask {
+		return ScannerRunningTask{
+			Id:        t.Id,
+			Name:      t.Name,
+			Lane:      string(t.Lane),
+			Progress:  t.Progress,
+			StartedAt: t.StartedAt,
+		}
+	})
 	formattedPendingTasks := internal.Fmap(pendingTasks, func(t t.TaskInfo, _ int) string {
 		return t.Name
 	})
 	return c.JSON(http.StatusOK, ScannerTaskStatus{
 		CurrentTask:  formattedCurentTask,
 		Progress:     progressPtr,
+		RunningTasks: formattedRunningTasks,
 		PendingTasks: formattedPendingTasks,
+		Paused:       s.worker.IsPaused(),
+	})
+}
+
+// @Tags        Tasks
+// @Summary		Stream Task Events
+// @Description	Server-Sent Events stream. The 'event' field is the type of the event, the 'data' field is the JSON-serialised event.
+// @Produce		text/event-stream
+// @Success		200 {object} ScannerTaskEvent
+// @Router	    /tasks/events [get]
+// @Security JWT
+func (s *ScannerContext) TaskEvents(c echo.Context) error {
+	if !s.userIsAdmin(c) {
+		return userIsNotAdminResponse(c)
+	}
+	const keepAliveInterval = 30 * time.Second
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	events, unsubscribe := s.worker.Subscribe()
+	defer unsubscribe()
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(res, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			data, err := json.Marshal(formatTaskEvent(event))
+			if err != nil {
+				log.Error().Msg("Could not serialise task event")
+				continue
+			}
+			if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return nil
+			}
+			res.Flush()
+		}
+	}
+}
+
+// @Tags        Tasks
+// @Summary		Get Finished Tasks, most recent first
+// @Produce		json
+// @Success		200 {array} ScannerTask
+// @Router	    /tasks/history [get]
+// @Security JWT
+func (s *ScannerContext) TaskHistory(c echo.Context) error {
+	if !s.userIsAdmin(c) {
+		return userIsNotAdminResponse(c)
+	}
+	return c.JSON(http.StatusOK, internal.Fmap(s.worker.GetHistory(), func(record t.TaskRecord, _ int) ScannerTask {
+		return formatTaskRecord(record)
+	}))
+}
+
+// @Tags        Tasks
+// @Summary		Get a Task, be it pending, running or finished
+// @Description	Includes the state, the progress and the counts of processed files. The IDs are given when tasks are queued
+// @Produce		json
+// @Success		200 {object} ScannerTask
+// @Failure		404 {object} ScannerStatus
+// @Router	    /tasks/{taskId} [get]
+// @Param		taskId path string true "Task ID"
+// @Security JWT
+func (s *ScannerContext) Task(c echo.Context) error {
+	if !s.userIsAdmin(c) {
+		return userIsNotAdminResponse(c)
+	}
+	task, found := s.worker.GetTask(c.Param("taskId"))
+	if !found {
+		return c.JSON(http.StatusNotFound, ScannerStatus{Message: "Task not found"})
+	}
+	return c.JSON(http.StatusOK, formatTaskRecord(task))
+}
+
+// @Tags        Tasks
+// @Summary		Get the Report of a Dry-Run Task
+// @Description	Lists the files that would have been created, updated or deleted, with their parsed metadata
+// @Produce		json
+// @Success		200 {object} object
+// @Failure		404 {object} ScannerStatus
+// @Router	    /tasks/{taskId}/dry-run [get]
+// @Param		taskId path string true "Task ID"
+// @Security JWT
+func (s *ScannerContext) DryRunReport(c echo.Context) error {
+	if !s.userIsAdmin(c) {
+		return userIsNotAdminResponse(c)
+	}
+	taskId, err := uuid.Parse(c.Param("taskId"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, ScannerStatus{Message: "Dry-run report not found"})
+	}
+	reportPath := t.GetDryRunReportPath(s.config.ConfigDirectory, taskId.String())
+	if _, err := os.Stat(reportPath); err != nil {
+		return c.JSON(http.StatusNotFound, ScannerStatus{Message: "Dry-run report not found"})
+	}
+	return c.File(reportPath)
+}
+
+// @Tags        Tasks
+// @Summary		Cancel a Task
+// @Description	Drops the task if it is pending, or stops it if it is running
+// @Produce		json
+// @Success		200 {object} ScannerStatus
+// @Failure		404 {object} ScannerStatus
+// @Router	    /tasks/{taskId} [delete]
+// @Param		taskId path string true "Task ID"
+// @Security JWT
+func (s *ScannerContext) CancelTask(c echo.Context) error {
+	if !s.userIsAdmin(c) {
+		return userIsNotAdminResponse(c)
+	}
+	if !s.worker.CancelTask(c.Param("taskId")) {
+		return c.JSON(http.StatusNotFound, ScannerStatus{Message: "Task is neither pending nor running"})
+	}
+	return c.JSON(http.StatusOK, ScannerStatus{Message: "Task cancelled"})
+}
+
+// @Tags        Tasks
+// @Summary		Pause the worker
+// @Description	No new task will be started. The running task is put on hold before it processes its next file.
+// @Produce		json
+// @Success		200 {object} ScannerStatus
+// @Router	    /tasks/pause [post]
+// @Security JWT
+func (s *ScannerContext) PauseWorker(c echo.Context) error {
+	if !s.userIsAdmin(c) {
+		return userIsNotAdminResponse(c)
+	}
+	s.worker.Pause()
+	return c.JSON(http.StatusOK, ScannerStatus{Message: "Worker paused"})
+}
+
+// @Tags        Tasks
+// @Summary		Resume the worker
+// @Produce		json
+// @Success		200 {object} ScannerStatus
+// @Router	    /tasks/resume [post]
+// @Security JWT
+func (s *ScannerContext) ResumeWorker(c echo.Context) error {
+	if !s.userIsAdmin(c) {
+		return userIsNotAdminResponse(c)
+	}
+	s.worker.Resume()
+	return c.JSON(http.StatusOK, ScannerStatus{Message: "Worker resumed"})
+}
+
+// @Tags        Tasks
+// @Summary		Get Scheduled Tasks and their next run time
+// @Produce		json
+// @Success		200 {array} ScheduledTaskStatus
+// @Router	    /schedules [get]
+func (s *ScannerContext) Schedules(c echo.Context) error {
+	schedules := internal.Fmap(s.scheduler.GetSchedules(), func(schedule scheduler.ScheduleInfo, _ int) ScheduledTaskStatus {
+		status := ScheduledTaskStatus{
+			Task:    string(schedule.Settings.Task),
+			Cron:    schedule.Settings.Cron,
+			NextRun: schedule.NextRun,
+		}
+		if len(schedule.Settings.Library) > 0 {
+			status.Library = &schedule.Settings.Library
+		}
+		if !schedule.PreviousRun.IsZero() {
+			status.PreviousRun = &schedule.PreviousRun
+		}
+		return status
 	})
+	return c.JSON(http.StatusOK, schedules)
 }
 
 // @Tags        Tasks
 // @Summary		Request a Scan for all libraries
 // @Produce		json
-// @Success		202	{object}	ScannerStatus
+// @Success		202	{object}	ScannerTasksQueued
 // @Router	    /scan [post]
+// @Param		dryRun	query		boolean		false	"only report what would be registered (default: false)"
 // @Security JWT
 func (s *ScannerContext) ScanAll(c echo.Context) error {
 	if !s.userIsAdmin(c) {
 		return userIsNotAdminResponse(c)
 	}
-	libraries, err := api.GetAllLibraries(*s.config)
+	libraries, err := api.GetAllLibraries(c.Request().Context(), *s.config)
 	if err != nil {
 		return c.NoContent(http.StatusServiceUnavailable)
 	}
+	queuedTasks := []t.Task{}
 	for _, lib := range libraries {
-		task := s.worker.AddTask(t.NewLibraryScanTask(lib, *s.config))
+		task := s.worker.AddTask(withDryRun(c, t.NewLibraryScanTask(lib, *s.config)))
 		logTaskAdded(task)
+		queuedTasks = append(queuedTasks, task)
 	}
-	return c.JSON(http.StatusAccepted, ScannerStatus{Message: TaskAddedtoQueueMessage})
+	return tasksQueuedResponse(c, queuedTasks...)
 }
 
 // @Tags        Tasks
 // @Summary		Request a Scan for a single library
 // @Produce		json
-// @Success		202	{object}	ScannerStatus
+// @Success		202	{object}	ScannerTasksQueued
 // @Router	    /scan/{libraryId} [post]
 // @Param		libraryId path string true "Library Slug or ID"
+// @Param		dryRun	query		boolean		false	"only report what would be registered (default: false)"
 // @Security JWT
 func (s *ScannerContext) Scan(c echo.Context) error {
 	if !s.userIsAdmin(c) {
 		return userIsNotAdminResponse(c)
 	}
 	libraryId := c.Param("libraryId")
-	library, err := api.GetLibrary(*s.config, libraryId)
+	library, err := api.GetLibrary(c.Request().Context(), *s.config, libraryId)
 	if err != nil {
 		return c.NoContent(http.StatusBadRequest)
 	}
-	task := s.worker.AddTask(t.NewLibraryScanTask(library, *s.config))
+	task := s.worker.AddTask(withDryRun(c, t.NewLibraryScanTask(library, *s.config)))
 	logTaskAdded(task)
-	return c.JSON(http.StatusAccepted, ScannerStatus{Message: TaskAddedtoQueueMessage})
+	return tasksQueuedResponse(c, task)
 }
 
 // @Tags        Tasks
 // @Summary		Request a Clean
 // @Produce		json
-// @Success		202	{object}	ScannerStatus
+// @Success		202	{object}	ScannerTasksQueued
 // @Router	    /clean [post]
+// @Param		dryRun	query		boolean		false	"only report what would be deleted (default: false)"
 // @Security JWT
 func (s *ScannerContext) Clean(c echo.Context) error {
 	if !s.userIsAdmin(c) {
 		return userIsNotAdminResponse(c)
 	}
-	libraries, err := api.GetAllLibraries(*s.config)
+	libraries, err := api.GetAllLibraries(c.Request().Context(), *s.config)
 	if err != nil {
 		return c.NoContent(http.StatusServiceUnavailable)
 	}
+	queuedTasks := []t.Task{}
 	for _, lib := range libraries {
-		task := s.worker.AddTask(t.NewLibraryCleanTask(lib, *s.config))
+		task := s.worker.AddTask(withDryRun(c, t.NewLibraryCleanTask(lib, *s.config)))
 		logTaskAdded(task)
+		queuedTasks = append(queuedTasks, task)
 	}
-	return c.JSON(http.StatusAccepted, ScannerStatus{Message: TaskAddedtoQueueMessage})
+	return tasksQueuedResponse(c, queuedTasks...)
 }
 
 // @Tags        Tasks
 // @Summary		Request a Clean for a single library
 // @Produce		json
-// @Success		202	{object}	ScannerStatus
+// @Success		202	{object}	ScannerTasksQueued
 // @Router	    /clean/{libraryId} [post]
 // @Param		libraryId path string true "Library Slug or ID"
+// @Param		dryRun	query		boolean		false	"only report what would be deleted (default: false)"
 // @Security JWT
 func (s *ScannerContext) CleanLibrary(c echo.Context) error {
 	if !s.userIsAdmin(c) {
 		return userIsNotAdminResponse(c)
 	}
 	libraryId := c.Param("libraryId")
-	library, err := api.GetLibrary(*s.config, libraryId)
+	library, err := api.GetLibrary(c.Request().Context(), *s.config, libraryId)
 	if err != nil {
 		return c.NoContent(http.StatusBadRequest)
 	}
-	task := s.worker.AddTask(t.NewLibraryCleanTask(library, *s.config))
+	task := s.worker.AddTask(withDryRun(c, t.NewLibraryCleanTask(library, *s.config)))
 	logTaskAdded(task)
-	return c.JSON(http.StatusAccepted, ScannerStatus{Message: TaskAddedtoQueueMessage})
+	return tasksQueuedResponse(c, task)
 }
 
 // @Tags        Tasks
 // @Summary		Refresh Metadata of selected files
 // @Description	Exactly one query parameter must be given
 // @Produce		json
-// @Success		202	{object}	ScannerStatus
+// @Success		202	{object}	ScannerTasksQueued
 // @Router	    /refresh [post]
 // @Security JWT
 // @Param			library	query		string		false	"refresh files from library"
@@ -159,6 +531,7 @@ func (s *ScannerContext) CleanLibrary(c echo.Context) error {
 // @Param			song	query		string		false	"refresh files from song"
 // @Param			track	query		string		false	"refresh file from track"
 // @Param			force	query		boolean		false	"force metadata refresh, even if files have not changed (default: false)"
+// @Param			dryRun	query		boolean		false	"only report what would be updated (default: false)"
 func (s *ScannerContext) Refresh(c echo.Context) error {
 	if !s.userIsAdmin(c) {
 		return userIsNotAdminResponse(c)
@@ -179,16 +552,48 @@ func (s *ScannerContext) Refresh(c echo.Context) error {
 	if len(params) != 1 {
 		return c.JSON(http.StatusBadRequest, ScannerStatus{Message: "Expected exactly one query parameter"})
 	}
-	task := s.worker.AddTask(t.NewMetadataRefreshTask(api.FileSelectorDto{
+	task := s.worker.AddTask(withDryRun(c, t.NewMetadataRefreshTask(api.FileSelectorDto{
 		Library: library,
 		Album:   album,
 		Release: release,
 		Song:    song,
 		Track:   track,
-	}, force, *s.config))
+	}, force, *s.config)))
 
 	logTaskAdded(task)
-	return c.JSON(http.StatusAccepted, ScannerStatus{Message: TaskAddedtoQueueMessage})
+	return tasksQueuedResponse(c, task)
+}
+
+// @Tags        Debug
+// @Summary		Parse a file, and explain where each field of the metadata comes from
+// @Description	Nothing is sent to the API. The response includes the results of each source, the matched regex, the source and tag key of each field, and the validation errors
+// @Accept		json
+// @Produce		json
+// @Param		request	body		DebugParseRequest	true	"File to parse"
+// @Success		200	{object}	object
+// @Router	    /debug/parse [post]
+// @Security JWT
+func (s *ScannerContext) DebugParse(c echo.Context) error {
+	if !s.userIsAdmin(c) {
+		return userIsNotAdminResponse(c)
+	}
+	var request DebugParseRequest
+	if err := c.Bind(&request); err != nil || len(request.Library) == 0 || len(request.Path) == 0 {
+		return c.JSON(http.StatusBadRequest, ScannerStatus{Message: "Expected a library and a path"})
+	}
+	library, err := api.GetLibrary(c.Request().Context(), *s.config, request.Library)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	libraryRoot := path.Join(s.config.DataDirectory, library.Path)
+	fullPath := path.Join(libraryRoot, request.Path)
+	if !strings.HasPrefix(fullPath, libraryRoot+"/") {
+		return c.JSON(http.StatusBadRequest, ScannerStatus{Message: "Path is outside of the library"})
+	}
+	if info, err := os.Stat(fullPath); err != nil || info.IsDir() {
+		return c.JSON(http.StatusNotFound, ScannerStatus{Message: "File not found"})
+	}
+	return c.JSON(http.StatusOK, parser.ExplainMetadata(c.Request().Context(), s.config.UserSettings, fullPath))
 }
 
 // Checks that the requesting user
@@ -197,7 +602,7 @@ func (s *ScannerContext) userIsAdmin(c echo.Context) bool {
 	if userToken == "" {
 		return false
 	}
-	user, err := api.GetUserFromAccessToken(*s.config, userToken)
+	user, err := api.GetUserFromAccessToken(c.Request().Context(), *s.config, userToken)
 	if err != nil {
 		log.Error().Msg(err.Error())
 		return false
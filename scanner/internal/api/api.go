@@ -2,32 +2,39 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
+	"maps"
+	"math"
 	"mime/multipart"
 	"net/http"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/Arthi-chaud/Meelo/scanner/internal"
 	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/metrics"
 	"github.com/go-playground/validator/v10"
 )
 
 const JsonContentType = "application/json"
 
+// Sends a single request, even if the API is considered down
 func HealthCheck(config config.Config) error {
-	_, err := request("GET", "/", nil, config, "")
+	_, err := send(context.Background(), "GET", "/", nil, false, config, "")
+	breaker.record(err, config)
 	return err
 }
 
-func GetUserFromAccessToken(config config.Config, accessToken string) (User, error) {
+func GetUserFromAccessToken(ctx context.Context, config config.Config, accessToken string) (User, error) {
 	config.AccessToken = accessToken
-	res, err := request("GET", "/users/me", nil, config, "")
+	res, err := request(ctx, "GET", "/users/me", nil, config, "")
 	if err != nil {
 		return User{}, err
 	}
@@ -36,7 +43,30 @@ func GetUserFromAccessToken(config config.Config, accessToken string) (User, err
 	return u, err
 }
 
-func GetAllFiles(selector FileSelectorDto, config config.Config) ([]File, error) {
+func GetAllFiles(ctx context.Context, selector FileSelectorDto, config config.Config) ([]File, error) {
+	return getAllItemsInPaginatedQuery[File](ctx, getFilesUrl(selector), config)
+}
+
+// Returns true if exactly that many files match the selector
+// At most two files are fetched, so that the files do not have to be fetched all to be counted
+func HasFileCount(ctx context.Context, selector FileSelectorDto, count int, config config.Config) (bool, error) {
+	url := getFilesUrl(selector) + "take=2"
+	if count > 1 {
+		url = url + fmt.Sprintf("&skip=%d", count-1)
+	}
+	res, err := request(ctx, "GET", url, nil, config, "")
+	if err != nil {
+		return false, err
+	}
+	var page = Page[File]{}
+	if err := validate(res, &page); err != nil {
+		return false, err
+	}
+	return len(page.Items) == min(count, 1), nil
+}
+
+// Ends with either '?' or '&'
+func getFilesUrl(selector FileSelectorDto) string {
 	url := "/files?"
 	v := reflect.ValueOf(selector)
 	typeOfS := v.Type()
@@ -45,15 +75,15 @@ func GetAllFiles(selector FileSelectorDto, config config.Config) ([]File, error)
 			url = url + fmt.Sprintf("%s=%s&", strings.ToLower(typeOfS.Field(i).Name), v.Field(i).String())
 		}
 	}
-	return getAllItemsInPaginatedQuery[File](url, config)
+	return url
 }
 
-func GetAllLibraries(config config.Config) ([]Library, error) {
-	return getAllItemsInPaginatedQuery[Library]("/libraries", config)
+func GetAllLibraries(ctx context.Context, config config.Config) ([]Library, error) {
+	return getAllItemsInPaginatedQuery[Library](ctx, "/libraries", config)
 }
 
-func GetLibrary(config config.Config, librarySlug string) (Library, error) {
-	res, err := request("GET", fmt.Sprintf("/libraries/%s", librarySlug), nil, config, "")
+func GetLibrary(ctx context.Context, config config.Config, librarySlug string) (Library, error) {
+	res, err := request(ctx, "GET", fmt.Sprintf("/libraries/%s", librarySlug), nil, config, "")
 	if err != nil {
 		return Library{}, err
 	}
@@ -66,18 +96,38 @@ type FileDeletionDto struct {
 	FileIds []int `json:"ids"`
 }
 
-func DeleteFiles(config config.Config, fileIds []int) error {
+func DeleteFiles(ctx context.Context, config config.Config, fileIds []int) error {
 	dto := FileDeletionDto{FileIds: fileIds}
 	serialized, err := json.Marshal(dto)
 	if err != nil {
 		return err
 	}
-	_, err = request("DELETE", "/files", bytes.NewBuffer(serialized), config, JsonContentType)
+	_, err = request(ctx, "DELETE", "/files", bytes.NewBuffer(serialized), config, JsonContentType)
+	return err
+}
+
+type FileMoveDto struct {
+	Path     string `json:"path"`
+	Checksum string `json:"checksum"`
+}
+
+// Updates the path and the checksum of a registered file, keeping its track
+// Unlike in SaveMetadata, the path is relative to the library of the file
+func MoveFile(ctx context.Context, config config.Config, fileId int, filePath string, checksum string) error {
+	dto := FileMoveDto{Path: filePath, Checksum: checksum}
+	serialized, err := json.Marshal(dto)
+	if err != nil {
+		return err
+	}
+	_, err = request(ctx, "PUT", fmt.Sprintf("/files/%d", fileId), bytes.NewBuffer(serialized), config, JsonContentType)
 	return err
 }
 
-func HasLyrics(config config.Config, songId int) (bool, error) {
-	_, err := request("GET", fmt.Sprintf("/songs/%d/lyrics", songId), nil, config, "")
+func HasLyrics(ctx context.Context, config config.Config, songId int) (bool, error) {
+	_, err := request(ctx, "GET", fmt.Sprintf("/songs/%d/lyrics", songId), nil, config, "")
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
 	return err == nil, err
 }
 
@@ -85,13 +135,13 @@ type LyricsDto struct {
 	Lyrics string `json:"plain"`
 }
 
-func PostLyrics(config config.Config, songId int, lyrics []string) error {
+func PostLyrics(ctx context.Context, config config.Config, songId int, lyrics []string) error {
 	dto := LyricsDto{Lyrics: strings.Join(lyrics, "\n")}
 	serialized, err := json.Marshal(dto)
 	if err != nil {
 		return err
 	}
-	_, err = request("POST", fmt.Sprintf("/songs/%d/lyrics", songId), bytes.NewBuffer(serialized), config, JsonContentType)
+	_, err = request(ctx, "POST", fmt.Sprintf("/songs/%d/lyrics", songId), bytes.NewBuffer(serialized), config, JsonContentType)
 	return err
 }
 
@@ -102,73 +152,240 @@ const (
 	Create SaveMetadataMethod = "Create"
 )
 
-func SaveMetadata(config config.Config, m internal.Metadata, saveMethod SaveMetadataMethod) (MetadataCreated, error) {
+func SaveMetadata(ctx context.Context, config config.Config, m internal.Metadata, saveMethod SaveMetadataMethod) (MetadataCreated, error) {
 	reqBody := new(bytes.Buffer)
 	mp := multipart.NewWriter(reqBody)
-	mp.WriteField("compilation", strconv.FormatBool(m.IsCompilation))
-	mp.WriteField("artist", m.Artist)
+	fields := metadataFields(m)
+	for _, name := range slices.Sorted(maps.Keys(fields)) {
+		switch value := fields[name].(type) {
+		case []string:
+			for i, item := range value {
+				mp.WriteField(fmt.Sprintf("%s[%d]", name, i), item)
+			}
+		case string:
+			mp.WriteField(name, value)
+		case bool:
+			mp.WriteField(name, strconv.FormatBool(value))
+		case int:
+			mp.WriteField(name, strconv.Itoa(value))
+		case int64:
+			mp.WriteField(name, strconv.FormatInt(value, 10))
+		case float64:
+			mp.WriteField(name, strconv.FormatFloat(value, 'f', -1, 64))
+		}
+	}
+	mp.Close()
+
+	res, err := request(ctx, saveMethod.httpMethod(), "/metadata", reqBody, config, mp.FormDataContentType())
+	if err != nil {
+		return MetadataCreated{}, err
+	}
+	dto := MetadataCreated{}
+	err = validate(res, &dto)
+	return dto, err
+}
+
+func (m SaveMetadataMethod) httpMethod() string {
+	if m == Update {
+		return "PUT"
+	}
+	return "POST"
+}
+
+// Fields of the metadata, as expected by the API
+// Values are strings, lists of strings, booleans or numbers
+func metadataFields(m internal.Metadata) map[string]any {
+	fields := map[string]any{}
+	fields["compilation"] = m.IsCompilation
+	fields["artist"] = m.Artist
 	if len(m.AlbumArtist) > 0 {
-		mp.WriteField("albumArtist", m.AlbumArtist)
+		fields["albumArtist"] = m.AlbumArtist
+	}
+	// In order. Only set if the names were split, otherwise the API parses 'artist'
+	for field, names := range map[string][]string{
+		"artists":              internal.FilterArtistNames(m.Artists, false),
+		"featuring":            internal.FilterArtistNames(m.Artists, true),
+		"albumArtists":         internal.FilterArtistNames(m.AlbumArtists, false),
+		"featuredAlbumArtists": internal.FilterArtistNames(m.AlbumArtists, true),
+	} {
+		if len(names) > 0 {
+			fields[field] = names
+		}
 	}
 	if len(m.Album) > 0 {
-		mp.WriteField("album", m.Album)
+		fields["album"] = m.Album
 	}
 	if len(m.Release) > 0 {
-		mp.WriteField("release", m.Release)
+		fields["release"] = m.Release
 	}
-	mp.WriteField("name", m.Name)
+	fields["name"] = m.Name
 	if m.ReleaseDate != nil {
-		mp.WriteField("releaseDate", (*m.ReleaseDate).Format(time.RFC3339))
+		fields["releaseDate"] = (*m.ReleaseDate).Format(time.RFC3339)
 	}
 	if m.Index > 0 {
-		mp.WriteField("index", strconv.FormatInt(m.Index, 10))
+		fields["index"] = m.Index
 	}
 	if m.DiscIndex > 0 {
-		mp.WriteField("discIndex", strconv.FormatInt(m.DiscIndex, 10))
+		fields["discIndex"] = m.DiscIndex
 	}
 	if len(m.DiscName) > 0 {
-		mp.WriteField("discName", m.DiscName)
+		fields["discName"] = m.DiscName
 	}
 	if m.Bitrate > 0 {
-		mp.WriteField("bitrate", strconv.FormatInt(m.Bitrate, 10))
+		fields["bitrate"] = m.Bitrate
 	}
 	if m.Duration > 0 {
-		mp.WriteField("duration", strconv.FormatInt(m.Duration, 10))
+		fields["duration"] = m.Duration
 	}
 	if m.Bpm > 0 {
-		mp.WriteField("bpm", fmt.Sprintf("%.2f", m.Bpm))
+		fields["bpm"] = roundFloat(m.Bpm, 2)
 	}
 
-	mp.WriteField("type", string(m.Type))
-	for i, genre := range m.Genres {
-		mp.WriteField(fmt.Sprintf("genres[%d]", i), genre)
+	fields["type"] = string(m.Type)
+	for field, value := range map[string]string{
+		"container":     m.Container,
+		"audioCodec":    m.AudioCodec,
+		"channelLayout": m.ChannelLayout,
+		"videoCodec":    m.VideoCodec,
+		"hdrFormat":     m.HdrFormat,
+	} {
+		if len(value) > 0 {
+			fields[field] = value
+		}
+	}
+	for field, value := range map[string]int{
+		"sampleRate": m.SampleRate,
+		"bitDepth":   m.BitDepth,
+		"channels":   m.Channels,
+		"width":      m.Width,
+		"height":     m.Height,
+	} {
+		if value > 0 {
+			fields[field] = value
+		}
+	}
+	if len(m.AudioCodec) > 0 {
+		fields["lossless"] = m.Lossless
+	}
+	if m.FrameRate > 0 {
+		fields["frameRate"] = roundFloat(m.FrameRate, 3)
+	}
+	if len(m.Genres) > 0 {
+		fields["genres"] = m.Genres
 	}
 	if len(m.DiscogsId) > 0 {
-		mp.WriteField("discogsId", m.DiscogsId)
+		fields["discogsId"] = m.DiscogsId
+	}
+	for field, value := range map[string]string{
+		"musicbrainzRecordingId":    m.MusicBrainzRecordingId,
+		"musicbrainzTrackId":        m.MusicBrainzTrackId,
+		"musicbrainzReleaseId":      m.MusicBrainzReleaseId,
+		"musicbrainzReleaseGroupId": m.MusicBrainzReleaseGroupId,
+		"isrc":                      m.Isrc,
+		"barcode":                   m.Barcode,
+		"catalogNumber":             m.CatalogNumber,
+		"label":                     m.Label,
+	} {
+		if len(value) > 0 {
+			fields[field] = value
+		}
 	}
-	mp.WriteField("registrationDate", m.RegistrationDate.Format(time.RFC3339))
-	mp.WriteField("checksum", m.Checksum)
-	mp.WriteField("path", m.Path)
+	for field, value := range map[string]*float64{
+		"trackGain": m.TrackGain,
+		"albumGain": m.AlbumGain,
+	} {
+		if value != nil {
+			fields[field] = roundFloat(*value, 2)
+		}
+	}
+	for field, value := range map[string]*float64{
+		"trackPeak": m.TrackPeak,
+		"albumPeak": m.AlbumPeak,
+	} {
+		if value != nil {
+			fields[field] = roundFloat(*value, 6)
+		}
+	}
+	if len(m.MusicBrainzArtistIds) > 0 {
+		fields["musicbrainzArtistIds"] = m.MusicBrainzArtistIds
+	}
+	if len(m.MusicBrainzAlbumArtistIds) > 0 {
+		fields["musicbrainzAlbumArtistIds"] = m.MusicBrainzAlbumArtistIds
+	}
+	fields["registrationDate"] = m.RegistrationDate.Format(time.RFC3339)
+	fields["checksum"] = m.Checksum
+	fields["path"] = m.Path
 	if m.Fingerprint != nil {
-		mp.WriteField("fingerprint", *m.Fingerprint)
+		fields["fingerprint"] = *m.Fingerprint
 	}
-	mp.Close()
+	return fields
+}
 
-	method := "POST"
-	if saveMethod == Update {
-		method = "PUT"
-	}
+func roundFloat(value float64, decimals int) float64 {
+	factor := math.Pow(10, float64(decimals))
+	return math.Round(value*factor) / factor
+}
+
+// Returned by SaveMetadataBatch if the API cannot save several files in one request
+var ErrBatchNotSupported = errors.New("the API does not support batch registration")
 
-	res, err := request(method, "/metadata", reqBody, config, mp.FormDataContentType())
+// Set once the API responded that it does not support batches, so that it is not asked again
+var isBatchNotSupported atomic.Bool
+
+type MetadataBatchDto struct {
+	Items []map[string]any `json:"items"`
+}
+
+// Outcome of the registration of one file of a batch
+// Error is empty if the file was saved
+type MetadataBatchResult struct {
+	MetadataCreated
+	Error string `json:"error"`
+}
+
+type MetadataBatchResultsDto struct {
+	Items []MetadataBatchResult `json:"items" validate:"required"`
+}
+
+// Saves the metadata of several files in one request
+// The results are in the same order as the metadata
+// Returns ErrBatchNotSupported if the API only accepts files one by one
+func SaveMetadataBatch(ctx context.Context, config config.Config, ms []internal.Metadata, saveMethod SaveMetadataMethod) ([]MetadataBatchResult, error) {
+	if isBatchNotSupported.Load() {
+		return nil, ErrBatchNotSupported
+	}
+	dto := MetadataBatchDto{Items: internal.Fmap(ms, func(m internal.Metadata, _ int) map[string]any {
+		return metadataFields(m)
+	})}
+	serialized, err := json.Marshal(dto)
 	if err != nil {
-		return MetadataCreated{}, err
+		return nil, err
 	}
-	dto := MetadataCreated{}
-	err = validate(res, &dto)
-	return dto, err
+	res, err := request(ctx, saveMethod.httpMethod(), "/metadata/batch", bytes.NewBuffer(serialized), config, JsonContentType)
+	var apiError *ApiError
+	if errors.As(err, &apiError) && (apiError.StatusCode == http.StatusNotFound || apiError.StatusCode == http.StatusMethodNotAllowed) {
+		isBatchNotSupported.Store(true)
+		return nil, ErrBatchNotSupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	results := MetadataBatchResultsDto{}
+	if err := validate(res, &results); err != nil {
+		return nil, err
+	}
+	if len(results.Items) != len(ms) {
+		return nil, fmt.Errorf("expected %d results from batch registration, got %d", len(ms), len(results.Items))
+	}
+	for _, result := range results.Items {
+		if len(result.Error) == 0 && result.TrackId == 0 {
+			return nil, errors.New("batch registration returned a result without track id")
+		}
+	}
+	return results.Items, nil
 }
 
-func PostIllustration(config config.Config, trackId int, imageType IllustrationType, imageBytes []byte) error {
+func PostIllustration(ctx context.Context, config config.Config, trackId int, imageType IllustrationType, imageBytes []byte) error {
 	reqBody := new(bytes.Buffer)
 	mp := multipart.NewWriter(reqBody)
 
@@ -181,44 +398,18 @@ func PostIllustration(config config.Config, trackId int, imageType IllustrationT
 	part.Write(imageBytes)
 	mp.Close()
 
-	_, err = request("POST", "/illustrations/file", reqBody, config, mp.FormDataContentType())
-	return err
-}
-
-func request(method string, url string, body io.Reader, config config.Config, contentType string) (string, error) {
-	client := &http.Client{}
-	req, _ := http.NewRequest(method, fmt.Sprintf("%s%s", config.ApiUrl, url), body)
-	if body != nil {
-		req.Header.Set("Content-Type", contentType)
-	}
-	if config.AccessToken != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", config.AccessToken))
-	}
-	req.Header.Set("x-api-key", config.ApiKey)
-	resp, err := client.Do(req)
-
-	if err != nil {
-		return "", errors.Join(errors.New("Request to API failed: "), err)
-	}
-	defer resp.Body.Close()
-	b, err := io.ReadAll(resp.Body)
-	if resp.StatusCode >= 400 {
-		return "", errors.Join(
-			errors.New("Request to API failed: "),
-			fmt.Errorf("Unexpected Status Code: %d", resp.StatusCode),
-			errors.New(string(b)))
+	_, err = request(ctx, "POST", "/illustrations/file", reqBody, config, mp.FormDataContentType())
+	if err == nil {
+		metrics.IllustrationsPosted.WithLabelValues(string(imageType)).Inc()
 	}
-	if err != nil {
-		return "", err
-	}
-	return string(b), nil
+	return err
 }
 
-func getAllItemsInPaginatedQuery[T any](path string, c config.Config) ([]T, error) {
+func getAllItemsInPaginatedQuery[T any](ctx context.Context, path string, c config.Config) ([]T, error) {
 	next := path
 	items := []T{}
 	for len(next) != 0 {
-		res, err := request("GET", next, nil, c, "")
+		res, err := request(ctx, "GET", next, nil, c, "")
 		if err != nil {
 			return []T{}, err
 		}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestBatchServer(t *testing.T, handler http.HandlerFunc) (config.Config, *int) {
+	breaker = &circuitBreaker{}
+	isBatchNotSupported.Store(false)
+	requestCount := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount++
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return config.Config{ApiUrl: server.URL}, &requestCount
+}
+
+func TestSaveMetadataBatch(t *testing.T) {
+	var body MetadataBatchDto
+	c, _ := getTestBatchServer(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/metadata/batch", r.URL.Path)
+		content, _ := io.ReadAll(r.Body)
+		assert.Nil(t, json.Unmarshal(content, &body))
+		w.Write([]byte(`{"items":[{"trackId":1,"songId":2},{"error":"Conflict"}]}`))
+	})
+	ms := []internal.Metadata{
+		{Name: "A", Artist: "Artist", Genres: []string{"Pop", "Rock"}, Type: internal.Audio, AudioCodec: "flac", Lossless: true, SampleRate: 96000,
+			MusicBrainzReleaseId: "4b1e3ad2-1bd8-4f68-8c38-3c2c4b8b5e0a", MusicBrainzArtistIds: []string{"a1b2c3d4-0000-4000-8000-000000000001"}},
+		{Name: "B", Artist: "Artist", Type: internal.Audio, Artists: []internal.ArtistCredit{
+			{Name: "Artist"}, {Name: "Other Artist"}, {Name: "Featured Artist", Featured: true},
+		}},
+	}
+
+	results, err := SaveMetadataBatch(context.Background(), c, ms, Create)
+
+	assert.Nil(t, err)
+	assert.Len(t, results, 2)
+	assert.Equal(t, 1, results[0].TrackId)
+	assert.Equal(t, 2, results[0].SongId)
+	assert.Empty(t, results[0].Error)
+	assert.Equal(t, "Conflict", results[1].Error)
+	assert.Len(t, body.Items, 2)
+	assert.Equal(t, "A", body.Items[0]["name"])
+	assert.Equal(t, []any{"Pop", "Rock"}, body.Items[0]["genres"])
+	assert.Equal(t, "4b1e3ad2-1bd8-4f68-8c38-3c2c4b8b5e0a", body.Items[0]["musicbrainzReleaseId"])
+	assert.Equal(t, []any{"a1b2c3d4-0000-4000-8000-000000000001"}, body.Items[0]["musicbrainzArtistIds"])
+	assert.NotContains(t, body.Items[0], "isrc")
+	assert.Equal(t, false, body.Items[0]["compilation"])
+	assert.Equal(t, true, body.Items[0]["lossless"])
+	assert.Equal(t, float64(96000), body.Items[0]["sampleRate"])
+	assert.NotContains(t, body.Items[1], "lossless")
+	assert.Equal(t, "B", body.Items[1]["name"])
+	assert.Equal(t, []any{"Artist", "Other Artist"}, body.Items[1]["artists"])
+	assert.Equal(t, []any{"Featured Artist"}, body.Items[1]["featuring"])
+	assert.NotContains(t, body.Items[0], "featuring")
+}
+
+func TestSaveMetadataBatchIsNotRetriedIfNotSupported(t *testing.T) {
+	c, requestCount := getTestBatchServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	ms := []internal.Metadata{{Name: "A"}, {Name: "B"}}
+
+	_, err := SaveMetadataBatch(context.Background(), c, ms, Update)
+	assert.True(t, errors.Is(err, ErrBatchNotSupported))
+	_, err = SaveMetadataBatch(context.Background(), c, ms, Update)
+	assert.True(t, errors.Is(err, ErrBatchNotSupported))
+	assert.Equal(t, 1, *requestCount)
+}
+
+func TestSaveMetadataBatchChecksResultCount(t *testing.T) {
+	c, _ := getTestBatchServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"items":[{"trackId":1}]}`))
+	})
+
+	_, err := SaveMetadataBatch(context.Background(), c, []internal.Metadata{{Name: "A"}, {Name: "B"}}, Create)
+
+	assert.NotNil(t, err)
+	assert.False(t, errors.Is(err, ErrBatchNotSupported))
+}
+
+func TestHasFileCount(t *testing.T) {
+	var query url.Values
+	// There are 3 files in the API
+	c, _ := getTestBatchServer(t, func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		skip, _ := strconv.Atoi(query.Get("skip"))
+		take, _ := strconv.Atoi(query.Get("take"))
+		items := []File{}
+		for id := skip + 1; id <= min(skip+take, 3); id++ {
+			items = append(items, File{Id: id, Path: fmt.Sprintf("%d.mp3", id), Checksum: "a", LibraryId: 1})
+		}
+		body, _ := json.Marshal(Page[File]{Items: items})
+		w.Write(body)
+	})
+
+	hasFileCount, err := HasFileCount(context.Background(), FileSelectorDto{Library: "music"}, 3, c)
+	assert.Nil(t, err)
+	assert.True(t, hasFileCount)
+	assert.Equal(t, "music", query.Get("library"))
+	assert.Equal(t, "2", query.Get("skip"))
+
+	hasFileCount, err = HasFileCount(context.Background(), FileSelectorDto{Library: "music"}, 2, c)
+	assert.Nil(t, err)
+	assert.False(t, hasFileCount)
+
+	hasFileCount, err = HasFileCount(context.Background(), FileSelectorDto{Library: "music"}, 4, c)
+	assert.Nil(t, err)
+	assert.False(t, hasFileCount)
+
+	hasFileCount, err = HasFileCount(context.Background(), FileSelectorDto{Library: "music"}, 0, c)
+	assert.Nil(t, err)
+	assert.False(t, hasFileCount)
+	assert.False(t, query.Has("skip"))
+}
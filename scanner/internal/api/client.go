@@ -0,0 +1,276 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+// Maximum duration of a request, including the upload of the body and the reading of the response
+const RequestTimeout = 2 * time.Minute
+
+// Number of times a request is sent before giving up, if the API is unreachable or restarting
+const MaxRequestAttempts = 5
+
+// Number of consecutive failed requests after which the API is considered down
+const CircuitBreakerThreshold = 3
+
+// While the API is down, it is checked this often
+const HealthCheckInterval = 5 * time.Second
+
+// Delay before the first retry. Doubled after each attempt
+var initialRetryDelay = 500 * time.Millisecond
+
+var (
+	// The API could not be reached, or did not respond in time
+	ErrUnreachable = errors.New("could not reach the API")
+	// The API is considered down, so the request was not sent
+	ErrUnavailable = errors.New("API is unavailable")
+	// Matches ApiErrors with a 400 status code
+	ErrBadRequest = errors.New("bad request")
+	// Matches ApiErrors with a 404 status code
+	ErrNotFound = errors.New("not found")
+	// Matches ApiErrors with a 409 status code
+	ErrConflict = errors.New("conflict")
+	// Matches ApiErrors with a 5xx status code
+	ErrServerError = errors.New("server error")
+	// Matches ApiErrors with a 502, 503 or 504 status code, i.e. when the API is restarting or behind a proxy that cannot reach it
+	ErrServiceUnavailable = errors.New("service unavailable")
+)
+
+// Returned when the API responds with an error status code
+// Use errors.Is with ErrBadRequest, ErrNotFound, ErrConflict, ErrServerError or ErrServiceUnavailable to know what went wrong
+type ApiError struct {
+	StatusCode int
+	Method     string
+	Url        string
+	// Body of the response
+	Body string
+}
+
+func (e *ApiError) Error() string {
+	return fmt.Sprintf("Request to API failed: %s %s: Unexpected Status Code: %d: %s", e.Method, e.Url, e.StatusCode, e.Body)
+}
+
+func (e *ApiError) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrServerError:
+		return e.StatusCode >= 500
+	case ErrServiceUnavailable:
+		return e.StatusCode == http.StatusBadGateway ||
+			e.StatusCode == http.StatusServiceUnavailable ||
+			e.StatusCode == http.StatusGatewayTimeout
+	}
+	return false
+}
+
+// Shared by all requests, so that connections are reused
+var httpClient = &http.Client{
+	Timeout: RequestTimeout,
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   16,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	},
+}
+
+// Sends the request, retrying with an exponential backoff if the API is unreachable or restarting
+// Other errors, like a 500, are not retried, as the request would likely fail again
+// Fails right away if the API is down
+// The context interrupts the retries, and GET requests being sent. Other requests being sent are not,
+// so that we do not miss the response of a write that the API handled
+func request(ctx context.Context, method string, url string, body io.Reader, config config.Config, contentType string) (string, error) {
+	if !IsAvailable() {
+		return "", ErrUnavailable
+	}
+	var payload []byte
+	if body != nil {
+		var err error
+		// Read once, so that it can be sent again
+		if payload, err = io.ReadAll(body); err != nil {
+			return "", err
+		}
+	}
+	delay := initialRetryDelay
+	for attempt := 1; ; attempt++ {
+		res, err := send(ctx, method, url, payload, body != nil, config, contentType)
+		if err != nil && ctx.Err() != nil {
+			// Not recorded by the breaker, as we do not know if the API is down
+			return "", ctx.Err()
+		}
+		if err == nil || !isRetryable(err) || attempt == MaxRequestAttempts {
+			breaker.record(err, config)
+			return res, err
+		}
+		log.Debug().Str("url", url).Err(err).Msgf("Request to API failed. Retrying in %s", delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			// Not recorded by the breaker, as we do not know if the API is down
+			return "", ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// Sends the request once
+func send(ctx context.Context, method string, url string, payload []byte, hasBody bool, config config.Config, contentType string) (string, error) {
+	var body io.Reader
+	if hasBody {
+		body = bytes.NewReader(payload)
+	}
+	if method != "GET" {
+		ctx = context.WithoutCancel(ctx)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s%s", config.ApiUrl, url), body)
+	if err != nil {
+		return "", err
+	}
+	if hasBody {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if config.AccessToken != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", config.AccessToken))
+	}
+	req.Header.Set("x-api-key", config.ApiKey)
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	metrics.ObserveDuration(metrics.ApiRequestDuration.WithLabelValues(method), start)
+
+	if err != nil {
+		metrics.ApiErrors.WithLabelValues("network").Inc()
+		return "", fmt.Errorf("%w: %w", ErrUnreachable, err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 400 {
+		metrics.ApiErrors.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+		return "", &ApiError{StatusCode: resp.StatusCode, Method: method, Url: url, Body: string(b)}
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrUnreachable, err)
+	}
+	return string(b), nil
+}
+
+// True if the error means the API is down, or restarting
+// A 500 is not, as it means the API could handle the request, but failed to
+func isRetryable(err error) bool {
+	return errors.Is(err, ErrUnreachable) || errors.Is(err, ErrServiceUnavailable)
+}
+
+// True if the request failed because the API is down, or restarting, after all attempts
+// In that case, sending the request again later may succeed
+func IsDown(err error) bool {
+	return isRetryable(err) || errors.Is(err, ErrUnavailable)
+}
+
+// Stops sending requests once the API seems down, until a health check succeeds
+type circuitBreaker struct {
+	// Number of consecutive requests that failed because of the API
+	failures int
+	isOpen   bool
+	// Key is the ID of the listener
+	listeners      map[int]func(isAvailable bool)
+	nextListenerId int
+	mu             sync.Mutex
+}
+
+var breaker = &circuitBreaker{}
+
+// Returns false while the API is considered down
+func IsAvailable() bool {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	return !breaker.isOpen
+}
+
+// Registers a function to call when the API goes down, and when it is back
+// Returns a function to call to unregister it
+func OnAvailabilityChange(listener func(isAvailable bool)) func() {
+	b := breaker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.listeners == nil {
+		b.listeners = map[int]func(bool){}
+	}
+	listenerId := b.nextListenerId
+	b.nextListenerId++
+	b.listeners[listenerId] = listener
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.listeners, listenerId)
+	}
+}
+
+// Updates the state of the breaker with the outcome of a request
+func (b *circuitBreaker) record(err error, c config.Config) {
+	b.mu.Lock()
+	if err == nil || !isRetryable(err) {
+		// The API responded, so it is up
+		wasOpen := b.isOpen
+		b.failures = 0
+		b.isOpen = false
+		b.mu.Unlock()
+		if wasOpen {
+			log.Info().Msg("API is back")
+			b.notify(true)
+		}
+		return
+	}
+	b.failures++
+	if b.isOpen || b.failures < CircuitBreakerThreshold {
+		b.mu.Unlock()
+		return
+	}
+	b.isOpen = true
+	b.mu.Unlock()
+	log.Warn().Msg("API seems down. Waiting for it to be back")
+	b.notify(false)
+	go b.waitForApi(c)
+}
+
+func (b *circuitBreaker) notify(isAvailable bool) {
+	b.mu.Lock()
+	listeners := make([]func(bool), 0, len(b.listeners))
+	for _, listener := range b.listeners {
+		listeners = append(listeners, listener)
+	}
+	b.mu.Unlock()
+	for _, listener := range listeners {
+		listener(isAvailable)
+	}
+}
+
+// Checks the health of the API until it is back
+func (b *circuitBreaker) waitForApi(c config.Config) {
+	for !IsAvailable() {
+		time.Sleep(HealthCheckInterval)
+		HealthCheck(c)
+	}
+}
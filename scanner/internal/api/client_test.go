@@ -0,0 +1,155 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns a server that responds with the given statuses, then 200
+func getTestServer(t *testing.T, statuses ...int) (config.Config, func() int) {
+	initialRetryDelay = time.Millisecond
+	breaker = &circuitBreaker{}
+	var mu sync.Mutex
+	requestCount := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requestCount++
+		if requestCount <= len(statuses) {
+			w.WriteHeader(statuses[requestCount-1])
+		}
+		w.Write([]byte("{}"))
+	}))
+	t.Cleanup(server.Close)
+	return config.Config{ApiUrl: server.URL}, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requestCount
+	}
+}
+
+func TestRequestIsRetriedOnServerError(t *testing.T) {
+	c, requestCount := getTestServer(t, http.StatusBadGateway, http.StatusServiceUnavailable)
+
+	res, err := request(context.Background(), "POST", "/metadata", nil, c, "")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "{}", res)
+	assert.Equal(t, 3, requestCount())
+}
+
+func TestRequestIsNotRetriedOnClientError(t *testing.T) {
+	c, requestCount := getTestServer(t, http.StatusNotFound, http.StatusConflict)
+
+	_, err := request(context.Background(), "GET", "/songs/1/lyrics", nil, c, "")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.False(t, errors.Is(err, ErrConflict))
+	_, err = request(context.Background(), "POST", "/metadata", nil, c, "")
+	assert.True(t, errors.Is(err, ErrConflict))
+	assert.Equal(t, 2, requestCount())
+	var apiError *ApiError
+	assert.True(t, errors.As(err, &apiError))
+	assert.Equal(t, http.StatusConflict, apiError.StatusCode)
+}
+
+func TestRequestIsNotRetriedOnInternalServerError(t *testing.T) {
+	statuses := make([]int, CircuitBreakerThreshold)
+	for i := range statuses {
+		statuses[i] = http.StatusInternalServerError
+	}
+	c, requestCount := getTestServer(t, statuses...)
+
+	for range CircuitBreakerThreshold {
+		_, err := request(context.Background(), "POST", "/metadata", nil, c, "")
+		assert.True(t, errors.Is(err, ErrServerError))
+		assert.False(t, IsDown(err))
+	}
+	assert.Equal(t, CircuitBreakerThreshold, requestCount())
+	// The API responded, so it is not down
+	assert.True(t, IsAvailable())
+}
+
+func TestBodyIsSentAgainOnRetry(t *testing.T) {
+	initialRetryDelay = time.Millisecond
+	breaker = &circuitBreaker{}
+	bodies := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := make([]byte, r.ContentLength)
+		r.Body.Read(body)
+		bodies = append(bodies, string(body))
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	_, err := request(context.Background(), "DELETE", "/files", strings.NewReader(`{"ids":[1]}`), config.Config{ApiUrl: server.URL}, JsonContentType)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{`{"ids":[1]}`, `{"ids":[1]}`}, bodies)
+}
+
+func TestRetriesStopWhenContextIsCancelled(t *testing.T) {
+	c, requestCount := getTestServer(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	initialRetryDelay = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	_, err := request(ctx, "GET", "/", nil, c, "")
+
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, 1, requestCount())
+	assert.True(t, IsAvailable())
+}
+
+func TestGetRequestIsInterruptedByContext(t *testing.T) {
+	breaker = &circuitBreaker{}
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	_, err := request(ctx, "GET", "/", nil, config.Config{ApiUrl: server.URL}, "")
+
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, IsAvailable())
+}
+
+func TestCircuitBreakerOpensWhenApiIsDown(t *testing.T) {
+	statuses := make([]int, CircuitBreakerThreshold*MaxRequestAttempts)
+	for i := range statuses {
+		statuses[i] = http.StatusGatewayTimeout
+	}
+	c, requestCount := getTestServer(t, statuses...)
+	availability := []bool{}
+	unsubscribe := OnAvailabilityChange(func(isAvailable bool) {
+		availability = append(availability, isAvailable)
+	})
+	defer unsubscribe()
+
+	for range CircuitBreakerThreshold {
+		_, err := request(context.Background(), "GET", "/", nil, c, "")
+		assert.True(t, errors.Is(err, ErrServerError))
+	}
+	assert.False(t, IsAvailable())
+	_, err := request(context.Background(), "GET", "/", nil, c, "")
+	assert.True(t, errors.Is(err, ErrUnavailable))
+	assert.Equal(t, CircuitBreakerThreshold*MaxRequestAttempts, requestCount())
+
+	assert.Nil(t, HealthCheck(c))
+	assert.True(t, IsAvailable())
+	assert.Equal(t, []bool{false, true}, availability)
+}
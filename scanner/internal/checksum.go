@@ -3,6 +3,7 @@ package internal
 import (
 	"crypto/sha256"
 	"fmt"
+	"io"
 	"os"
 )
 
@@ -22,3 +23,80 @@ func ComputeChecksum(filepath string) (string, error) {
 
 	return fmt.Sprintf("%x", checksum), nil
 }
+
+// Size of each sample of the payload that is hashed
+const contentHashSampleSize = 64 * 1024
+
+// Computes a hash of the audio/video payload of the file, ignoring ID3 and FLAC metadata
+// Only a few samples of the payload are hashed, so it is fast but not exhaustive.
+// Unlike ComputeChecksum, it does not depend on the path of the file
+func ComputeContentHash(filepath string) (string, error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	start, end := getPayloadBounds(file, stat.Size())
+	payloadSize := end - start
+	h := sha256.New()
+	fmt.Fprintf(h, "%d-", payloadSize)
+	sampleOffsets := []int64{start}
+	sampleSize := payloadSize
+	if payloadSize > 3*contentHashSampleSize {
+		sampleSize = contentHashSampleSize
+		// Beginning, middle and end of the payload
+		sampleOffsets = []int64{start, start + (payloadSize-sampleSize)/2, end - sampleSize}
+	}
+	for _, offset := range sampleOffsets {
+		if _, err := io.Copy(h, io.NewSectionReader(file, offset, sampleSize)); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// Returns the offsets of the beginning and of the end of the payload
+// If the tags are malformed, the whole file is considered the payload
+func getPayloadBounds(file *os.File, fileSize int64) (int64, int64) {
+	start := int64(0)
+	end := fileSize
+	header := make([]byte, 10)
+	n, _ := file.ReadAt(header, 0)
+	if n == len(header) && string(header[:3]) == "ID3" {
+		// The size is a syncsafe integer, and excludes the header and the footer
+		tagSize := int64(header[6]&0x7f)<<21 | int64(header[7]&0x7f)<<14 |
+			int64(header[8]&0x7f)<<7 | int64(header[9]&0x7f)
+		start = 10 + tagSize
+		if header[5]&0x10 != 0 {
+			start += 10
+		}
+	} else if n >= 4 && string(header[:4]) == "fLaC" {
+		start = 4
+		blockHeader := make([]byte, 4)
+		for {
+			if _, err := file.ReadAt(blockHeader, start); err != nil {
+				return 0, fileSize
+			}
+			start += 4 + (int64(blockHeader[1])<<16 | int64(blockHeader[2])<<8 | int64(blockHeader[3]))
+			// Last metadata block
+			if blockHeader[0]&0x80 != 0 {
+				break
+			}
+		}
+	}
+	// ID3v1 tag
+	trailer := make([]byte, 3)
+	if end-start >= 128 {
+		if _, err := file.ReadAt(trailer, end-128); err == nil && string(trailer) == "TAG" {
+			end -= 128
+		}
+	}
+	if start >= end {
+		return 0, fileSize
+	}
+	return start, end
+}
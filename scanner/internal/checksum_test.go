@@ -0,0 +1,39 @@
+package internal
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func id3v2Tag(content string) []byte {
+	size := len(content)
+	header := []byte{'I', 'D', '3', 4, 0, 0, 0, 0, byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+	return append(header, []byte(content)...)
+}
+
+func TestContentHashIgnoresTagsAndPath(t *testing.T) {
+	dir := t.TempDir()
+	payload := make([]byte, 500*1024)
+	for i := range payload {
+		payload[i] = byte(i % 251)
+	}
+	file1 := path.Join(dir, "a.mp3")
+	file2 := path.Join(dir, "b.mp3")
+	file3 := path.Join(dir, "c.mp3")
+	assert.Nil(t, os.WriteFile(file1, append(id3v2Tag("Title"), payload...), 0644))
+	assert.Nil(t, os.WriteFile(file2, append(id3v2Tag("Another Title"), payload...), 0644))
+	payload[len(payload)/2]++
+	assert.Nil(t, os.WriteFile(file3, append(id3v2Tag("Title"), payload...), 0644))
+
+	hash1, err := ComputeContentHash(file1)
+	assert.Nil(t, err)
+	hash2, err := ComputeContentHash(file2)
+	assert.Nil(t, err)
+	hash3, err := ComputeContentHash(file3)
+	assert.Nil(t, err)
+	assert.Equal(t, hash1, hash2)
+	assert.NotEqual(t, hash1, hash3)
+}
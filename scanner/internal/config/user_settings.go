@@ -2,13 +2,16 @@ package config
 
 import (
 	e "errors"
+	"fmt"
 	"os"
 	"regexp"
+	"runtime"
 	"strings"
 
 	"github.com/Arthi-chaud/Meelo/scanner/internal"
 	"github.com/go-playground/validator/v10"
 	"github.com/goccy/go-json"
+	"github.com/robfig/cron/v3"
 )
 
 const UserSettingsFileName = "settings.json"
@@ -18,6 +21,23 @@ type CompilationSettings struct {
 	UseID3CompTag bool     `json:"useID3CompTag"`
 }
 
+// How artist names are split into several artists
+// Nothing is split unless separators are set
+type ArtistSettings struct {
+	// Separators between main artists (e.g. '; ' or ' & ')
+	Separators []string `json:"separators" validate:"dive,required"`
+	// Artists after these separators are featured artists (e.g. ' feat. ')
+	Featuring []string `json:"featuring" validate:"dive,required"`
+	// Names that should not be split, even if they contain a separator (e.g. 'Simon & Garfunkel')
+	Exceptions []string `json:"exceptions" validate:"dive,required"`
+}
+
+type LoudnessSettings struct {
+	// If true, the loudness of audio files without ReplayGain tags is measured with ffmpeg
+	// The whole file is decoded, which makes scans much slower
+	Analyze bool `json:"analyze"`
+}
+
 type MetadataSettings struct {
 	Source MetadataSource       `json:"source" validate:"required,oneof=path embedded"`
 	Order  MetadataParsingOrder `json:"order" validate:"required,oneof=only preferred"`
@@ -37,11 +57,94 @@ const (
 	Preferred MetadataParsingOrder = "preferred"
 )
 
+type WatcherSettings struct {
+	// If true, changes in the libraries' directories trigger scans automatically
+	Enabled bool `json:"enabled"`
+	// Number of seconds without filesystem events to wait for before processing changes
+	// Changes are processed after a minute anyway, unless this delay is longer
+	// If 0, a default value is used
+	Debounce int `json:"debounce" validate:"gte=0"`
+}
+
+type ConcurrencySettings struct {
+	// Number of files parsed at the same time
+	// If 0, the number of CPUs is used
+	Parsing int `json:"parsing" validate:"gte=0"`
+	// Number of files sent to the API at the same time
+	// If 0, files are sent one at a time
+	Pushing int `json:"pushing" validate:"gte=0"`
+	// Number of thumbnails extracted at the same time
+	// If 0, thumbnails are extracted one at a time
+	Thumbnails int `json:"thumbnails" validate:"gte=0"`
+}
+
+func (s ConcurrencySettings) GetParsingWorkerCount() int {
+	if s.Parsing == 0 {
+		return runtime.NumCPU()
+	}
+	return s.Parsing
+}
+
+func (s ConcurrencySettings) GetPushingWorkerCount() int {
+	return max(s.Pushing, 1)
+}
+
+func (s ConcurrencySettings) GetThumbnailWorkerCount() int {
+	return max(s.Thumbnails, 1)
+}
+
+type WebhookEvent string
+
+const (
+	// Sent when a task finishes, be it successfully or not
+	TaskFinishedWebhook WebhookEvent = "task-finished"
+	// Sent when a file cannot be parsed or registered
+	FileFailedWebhook WebhookEvent = "file-failed"
+)
+
+type WebhookSettings struct {
+	// The payload is POSTed to this URL
+	Url string `json:"url" validate:"required,url"`
+	// Events to send. If empty, all events are sent
+	Events []WebhookEvent `json:"events" validate:"dive,oneof=task-finished file-failed"`
+	// Additional headers of the requests (e.g. for authentication)
+	Headers map[string]string `json:"headers"`
+}
+
+type ScheduledTaskType string
+
+const (
+	ScheduledScan    ScheduledTaskType = "scan"
+	ScheduledClean   ScheduledTaskType = "clean"
+	ScheduledRefresh ScheduledTaskType = "refresh"
+)
+
+type ScheduleSettings struct {
+	// Standard cron expression (e.g. '0 3 * * *' or '@daily')
+	// Can be prefixed with 'CRON_TZ=<timezone>'
+	Cron string            `json:"cron" validate:"required"`
+	Task ScheduledTaskType `json:"task" validate:"required,oneof=scan clean refresh"`
+	// Slug of the library to run the task on
+	// If empty, the task runs on every library
+	Library string `json:"library"`
+	// Only for refresh tasks. Refreshes files even if they did not change
+	Force bool `json:"force"`
+}
+
 type UserSettings struct {
 	Compilations          CompilationSettings `json:"compilations" validate:"required"`
 	TrackRegex            []string            `json:"trackRegex" validate:"required"`
 	Metadata              MetadataSettings    `json:"metadata" validate:"required"`
+	Artists               ArtistSettings      `json:"artists"`
+	Loudness              LoudnessSettings    `json:"loudness"`
 	UseEmbeddedThumbnails bool                `json:"useEmbeddedThumbnails"`
+	Watcher               WatcherSettings     `json:"watcher"`
+	Concurrency           ConcurrencySettings `json:"concurrency"`
+	Schedules             []ScheduleSettings  `json:"schedules" validate:"dive"`
+	Webhooks              []WebhookSettings   `json:"webhooks" validate:"dive"`
+	// If true, a hash of the audio/video content of each file is kept,
+	// so that moved and renamed files are updated instead of being deleted and registered again
+	ContentHash bool `json:"contentHash"`
 }
 
 func GetUserSettings(settingsFilePath string) (UserSettings, []error) {
@@ -76,5 +179,10 @@ func GetUserSettings(settingsFilePath string) (UserSettings, []error) {
 			errors = append(errors, regexError)
 		}
 	}
+	for _, schedule := range userSettings.Schedules {
+		if _, cronError := cron.ParseStandard(schedule.Cron); cronError != nil {
+			errors = append(errors, fmt.Errorf("user settings: invalid cron expression '%s': %s", schedule.Cron, cronError.Error()))
+		}
+	}
 	return userSettings, errors
 }
@@ -87,3 +87,53 @@ func TestWrongMetadataType(t *testing.T) {
 
 	assert.Len(t, errors, 1)
 }
+
+func TestSchedules(t *testing.T) {
+	s, errors := getTestConfig("settings-schedules")
+
+	assert.Empty(t, errors)
+	assert.Len(t, s.Schedules, 2)
+	assert.Equal(t, ScheduledScan, s.Schedules[0].Task)
+	assert.Equal(t, "", s.Schedules[0].Library)
+	assert.Equal(t, ScheduledRefresh, s.Schedules[1].Task)
+	assert.Equal(t, "my-library", s.Schedules[1].Library)
+	assert.Equal(t, true, s.Schedules[1].Force)
+}
+
+func TestInvalidCronExpression(t *testing.T) {
+	_, errors := getTestConfig("settings-invalid-schedule")
+
+	assert.Len(t, errors, 1)
+}
+
+func TestWebhooks(t *testing.T) {
+	s, errors := getTestConfig("settings-webhooks")
+
+	assert.Empty(t, errors)
+	assert.Len(t, s.Webhooks, 2)
+	assert.Equal(t, "https://discord.com/api/webhooks/123/abc", s.Webhooks[0].Url)
+	assert.Empty(t, s.Webhooks[0].Events)
+	assert.Equal(t, []WebhookEvent{FileFailedWebhook}, s.Webhooks[1].Events)
+	assert.Equal(t, "Bearer token", s.Webhooks[1].Headers["Authorization"])
+}
+
+func TestInvalidWebhookEvent(t *testing.T) {
+	_, errors := getTestConfig("settings-invalid-webhook")
+
+	assert.Len(t, errors, 1)
+}
+
+func TestArtistSettings(t *testing.T) {
+	s, errors := getTestConfig("settings-artists")
+
+	assert.Empty(t, errors)
+	assert.Equal(t, []string{"; ", " & "}, s.Artists.Separators)
+	assert.Equal(t, []string{" feat. ", " ft. "}, s.Artists.Featuring)
+	assert.Equal(t, []string{"Simon & Garfunkel"}, s.Artists.Exceptions)
+}
+
+func TestEmptyArtistSeparator(t *testing.T) {
+	_, errors := getTestConfig("settings-invalid-artists")
+
+	assert.Len(t, errors, 1)
+}
@@ -1,16 +1,21 @@
 package internal
 
 import (
+	"context"
 	"encoding/json"
 	"os/exec"
+	"time"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal/metrics"
 )
 
 type Fingerprint struct {
 	Fingerprint string `json:"fingerprint"`
 }
 
-func GetFileAcousticFingerprint(filepath string) (string, error) {
-	cmd := exec.Command("fpcalc", filepath, "-json", "-algorithm", "2", "-overlap", "-channels", "2")
+func GetFileAcousticFingerprint(ctx context.Context, filepath string) (string, error) {
+	defer metrics.ObserveDuration(metrics.FpcalcDuration, time.Now())
+	cmd := exec.CommandContext(ctx, "fpcalc", filepath, "-json", "-algorithm", "2", "-overlap", "-channels", "2")
 	output, err := cmd.Output()
 	if err != nil {
 		return "", err
@@ -2,6 +2,7 @@ package illustration
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	ffmpeg_go "github.com/u2takey/ffmpeg-go"
 	"gopkg.in/vansante/go-ffprobe.v2"
@@ -16,12 +17,14 @@ func GetEmbeddedIllustrationStreamIndex(probeData ffprobe.ProbeData) int {
 	return -1
 }
 
-func ExtractEmbeddedIllustration(filePath string, illustrationStreamIndex int) ([]byte, error) {
+func ExtractEmbeddedIllustration(ctx context.Context, filePath string, illustrationStreamIndex int) ([]byte, error) {
 	buf := bytes.NewBuffer(nil)
-	err := ffmpeg_go.Input(filePath).
+	cmd := ffmpeg_go.Input(filePath).
 		Silent(true).
 		Get(fmt.Sprintf("%d", illustrationStreamIndex)).
-		Output("pipe:", ffmpeg_go.KwArgs{"vcodec": "mjpeg", "format": "image2"}).
-		WithOutput(buf).Run()
+		Output("pipe:", ffmpeg_go.KwArgs{"vcodec": "mjpeg", "format": "image2"})
+	// The context has to be set before the output, which is stored in the context
+	cmd.Context = ctx
+	err := cmd.WithOutput(buf).Run()
 	return buf.Bytes(), err
 }
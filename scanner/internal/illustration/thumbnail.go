@@ -2,6 +2,7 @@ package illustration
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"strings"
 
@@ -16,7 +17,7 @@ type CropDimensions struct {
 	y      int
 }
 
-func GetFrame(filepath string, timestamp int64) ([]byte, error) {
+func GetFrame(ctx context.Context, filepath string, timestamp int64) ([]byte, error) {
 	formattedDuration := fmt.Sprintf("%.2d:%.2d:%.2d", int(timestamp/3600), (timestamp/60)%60, timestamp%60)
 	thumbnail := bytes.NewBuffer(nil)
 	filters := []string{
@@ -31,34 +32,35 @@ func GetFrame(filepath string, timestamp int64) ([]byte, error) {
 			"vframes": 1,
 			"format":  "image2",
 			"vcodec":  "mjpeg",
-			"vf":      strings.Join(filters, ", ")}).
-		WithOutput(thumbnail)
-	err := cmd.Run()
+			"vf":      strings.Join(filters, ", ")})
+	cmd.Context = ctx
+	err := cmd.WithOutput(thumbnail).Run()
 	if err != nil {
 		return nil, err
 	}
 	thumbnailBytes := thumbnail.Bytes()
-	croppedThumbnail, err := RemoveBlackBars(&thumbnailBytes)
+	croppedThumbnail, err := RemoveBlackBars(ctx, &thumbnailBytes)
 	if croppedThumbnail != nil {
 		return croppedThumbnail.Bytes(), nil
 	}
 	return thumbnailBytes, err
 }
 
-func RemoveBlackBars(frame *[]byte) (*bytes.Buffer, error) {
+func RemoveBlackBars(ctx context.Context, frame *[]byte) (*bytes.Buffer, error) {
 
-	crops, err := GetCropDimensions(bytes.NewBuffer(*frame))
+	crops, err := GetCropDimensions(ctx, bytes.NewBuffer(*frame))
 	if err != nil || crops == nil {
 		return nil, err
 	}
 	newFrame := bytes.NewBuffer(nil)
-	err = ffmpeg_go.Input("pipe:", ffmpeg_go.KwArgs{"format": "image2pipe"}).
+	cmd := ffmpeg_go.Input("pipe:", ffmpeg_go.KwArgs{"format": "image2pipe"}).
 		Filter("crop", ffmpeg_go.Args{fmt.Sprintf("%d:%d:%d:%d", crops.width, crops.height, crops.x, crops.y)}).
 		Output("pipe:", ffmpeg_go.KwArgs{
 			"format":  "image2",
 			"vframes": "1",
-			"vcodec":  "mjpeg"}).
-		WithInput(bytes.NewBuffer(*frame)).
+			"vcodec":  "mjpeg"})
+	cmd.Context = ctx
+	err = cmd.WithInput(bytes.NewBuffer(*frame)).
 		WithOutput(newFrame).Run()
 	if err != nil {
 		return nil, err
@@ -66,17 +68,18 @@ func RemoveBlackBars(frame *[]byte) (*bytes.Buffer, error) {
 	return newFrame, err
 }
 
-func GetCropDimensions(thumbnail *bytes.Buffer) (*CropDimensions, error) {
+func GetCropDimensions(ctx context.Context, thumbnail *bytes.Buffer) (*CropDimensions, error) {
 
 	rawout := bytes.NewBuffer(nil)
 
-	err := ffmpeg_go.Input("pipe:", ffmpeg_go.KwArgs{"f": "image2pipe", "loop": "1"}).
+	cmd := ffmpeg_go.Input("pipe:", ffmpeg_go.KwArgs{"f": "image2pipe", "loop": "1"}).
 		Silent(true).
 		Output("pipe:", ffmpeg_go.KwArgs{
 			"f":        "null",
 			"frames:v": "3",
-			"vf":       "cropdetect=limit=0:round=0"}).
-		WithErrorOutput(rawout).
+			"vf":       "cropdetect=limit=0:round=0"})
+	cmd.Context = ctx
+	err := cmd.WithErrorOutput(rawout).
 		WithInput(thumbnail).
 		WithOutput(rawout).Run()
 	if err != nil {
@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal/metrics"
+)
+
+// Loudness targeted by ReplayGain 2.0, in LUFS
+const ReplayGainReferenceLoudness = -18.0
+
+// Loudness that Opus R128 gains are relative to, in LUFS
+const R128ReferenceLoudness = -23.0
+
+type Loudness struct {
+	// Integrated loudness, in LUFS
+	Integrated float64
+	// True peak, in dBFS
+	TruePeak float64
+}
+
+// Gain to apply to reach the ReplayGain reference loudness, in dB
+func (l Loudness) Gain() float64 {
+	return ReplayGainReferenceLoudness - l.Integrated
+}
+
+// Linear peak, where 1 is full scale
+func (l Loudness) Peak() float64 {
+	return math.Pow(10, l.TruePeak/20)
+}
+
+// Measures the EBU R128 loudness of the first audio stream, using ffmpeg's ebur128 filter
+// The whole file is decoded
+func GetFileLoudness(ctx context.Context, filePath string) (Loudness, error) {
+	defer metrics.ObserveDuration(metrics.LoudnessAnalysisDuration, time.Now())
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-nostats", "-i", filePath,
+		"-map", "0:a:0", "-filter:a", "ebur128=peak=true:framelog=verbose", "-f", "null", "-")
+	// The summary is logged on stderr
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return Loudness{}, err
+	}
+	return parseEbur128Summary(string(output))
+}
+
+var (
+	integratedLoudnessRegex = regexp.MustCompile(`I:\s+(-?[0-9.]+) LUFS`)
+	truePeakRegex           = regexp.MustCompile(`Peak:\s+(-?[0-9.]+|-inf) dBFS`)
+)
+
+// The summary comes last, so the last values are used
+func parseEbur128Summary(output string) (Loudness, error) {
+	integratedMatches := integratedLoudnessRegex.FindAllStringSubmatch(output, -1)
+	peakMatches := truePeakRegex.FindAllStringSubmatch(output, -1)
+	if len(integratedMatches) == 0 || len(peakMatches) == 0 {
+		return Loudness{}, errors.New("could not find the loudness summary in the output of ffmpeg")
+	}
+	integrated, err := strconv.ParseFloat(integratedMatches[len(integratedMatches)-1][1], 64)
+	if err != nil {
+		return Loudness{}, err
+	}
+	// Only silent files have an infinite peak, which ParseFloat handles
+	peak, err := strconv.ParseFloat(peakMatches[len(peakMatches)-1][1], 64)
+	if err != nil {
+		return Loudness{}, err
+	}
+	return Loudness{Integrated: integrated, TruePeak: peak}, nil
+}
@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const ebur128Output = `[Parsed_ebur128_0 @ 0x5580] t: 0.4       TARGET:-23 LUFS    M: -25.1 S:-120.7     I: -25.1 LUFS       LRA:   0.0 LU  FTPK: -3.2 dBFS  TPK: -3.2 dBFS
+[Parsed_ebur128_0 @ 0x5580] Summary:
+
+  Integrated loudness:
+    I:         -14.2 LUFS
+    Threshold: -24.6 LUFS
+
+  Loudness range:
+    LRA:         5.1 LU
+    Threshold: -34.5 LUFS
+    LRA low:   -18.8 LUFS
+    LRA high:  -13.7 LUFS
+
+  True peak:
+    Peak:        0.4 dBFS
+`
+
+func TestParseEbur128Summary(t *testing.T) {
+	loudness, err := parseEbur128Summary(ebur128Output)
+
+	assert.Nil(t, err)
+	assert.Equal(t, -14.2, loudness.Integrated)
+	assert.Equal(t, 0.4, loudness.TruePeak)
+	assert.InDelta(t, -3.8, loudness.Gain(), 0.001)
+	assert.InDelta(t, 1.047, loudness.Peak(), 0.001)
+}
+
+func TestParseEbur128SummaryWithoutSummary(t *testing.T) {
+	_, err := parseEbur128Summary("Output file is empty, nothing was encoded")
+
+	assert.NotNil(t, err)
+}
@@ -16,6 +16,11 @@ type Metadata struct {
 	Artist string `validate:"required"`
 	// Name of the artist of the parent album
 	AlbumArtist string
+	// Artists of the track, in order. The first main artist is the one in Artist
+	// Empty if the name of the artist was not split
+	Artists []ArtistCredit
+	// Artists of the parent album, in order. Empty if the name of the album artist was not split
+	AlbumArtists []ArtistCredit
 	// Lyrics of the song. One string == one line. Empty lines are line jumps
 	Lyrics []string
 	// Name of the album of the track
@@ -39,8 +44,53 @@ type Metadata struct {
 	Duration int64 `validate:"gte=0"`
 	// Type of the track
 	Type TrackType `validate:"required"`
+	// Technical properties of the file. Empty if unknown
+	// Container format, as named by ffmpeg (e.g. 'flac', 'mp4', 'matroska')
+	Container string
+	// Codec of the main audio stream, as named by ffmpeg (e.g. 'flac', 'mp3', 'aac')
+	AudioCodec string
+	// True if the audio codec is lossless (e.g. FLAC, ALAC or PCM)
+	Lossless bool
+	// In Hz
+	SampleRate int `validate:"gte=0"`
+	// Only for lossless codecs
+	BitDepth int `validate:"gte=0"`
+	Channels int `validate:"gte=0"`
+	// As named by ffmpeg (e.g. 'stereo' or '5.1')
+	ChannelLayout string
+	// Only for videos
+	VideoCodec string
+	Width      int `validate:"gte=0"`
+	Height     int `validate:"gte=0"`
+	// In frames per second
+	FrameRate float64 `validate:"gte=0"`
+	// e.g. 'HDR10' or 'Dolby Vision'. Empty for SDR videos
+	HdrFormat string
 	// Genres of the track
 	Genres []string
+	// MusicBrainz IDs, as written by MusicBrainz Picard
+	MusicBrainzRecordingId    string `validate:"omitempty,uuid"`
+	MusicBrainzTrackId        string `validate:"omitempty,uuid"`
+	MusicBrainzReleaseId      string `validate:"omitempty,uuid"`
+	MusicBrainzReleaseGroupId string `validate:"omitempty,uuid"`
+	// IDs of the artists of the track, in the order of the artists of the tags
+	MusicBrainzArtistIds []string `validate:"dive,uuid"`
+	// IDs of the artists of the parent album, in the order of the album artists of the tags
+	MusicBrainzAlbumArtistIds []string `validate:"dive,uuid"`
+	// International Standard Recording Code (e.g. 'USRC17607839')
+	Isrc string
+	// Barcode of the parent release (e.g. UPC or EAN)
+	Barcode string
+	// Catalog number of the parent release, given by its label
+	CatalogNumber string
+	// Name of the label of the parent release
+	Label string
+	// ReplayGain 2.0 gains, in dB, relative to -18 LUFS. Nil if unknown
+	TrackGain *float64
+	AlbumGain *float64
+	// Linear sample peaks, where 1 is full scale. Nil if unknown
+	TrackPeak *float64
+	AlbumPeak *float64
 	// Discogs ID of the parent release
 	DiscogsId               string
 	IllustrationLocation    IllustrationLocation
@@ -52,6 +102,21 @@ type Metadata struct {
 	Fingerprint             *string
 }
 
+type ArtistCredit struct {
+	Name string
+	// True if the artist is featured, instead of being one of the main artists
+	Featured bool
+}
+
+// Returns the names of the main artists, or of the featured ones
+func FilterArtistNames(credits []ArtistCredit, featured bool) []string {
+	return Fmap(Filter(credits, func(credit ArtistCredit) bool {
+		return credit.Featured == featured
+	}), func(credit ArtistCredit, _ int) string {
+		return credit.Name
+	})
+}
+
 type TrackType string
 
 const (
@@ -76,11 +141,14 @@ func SanitizeAndValidateMetadata(m *Metadata) []error {
 	}
 	if m.IsCompilation {
 		m.AlbumArtist = ""
+		m.AlbumArtists = nil
 	} else if len(m.AlbumArtist) == 0 {
 		m.AlbumArtist = m.Artist
+		m.AlbumArtists = m.Artists
 	}
 	if len(m.Artist) == 0 {
 		m.Artist = m.AlbumArtist
+		m.Artists = m.AlbumArtists
 	}
 	// Validation
 	validationsErrs := validator.New(validator.WithRequiredStructEnabled()).Struct(m)
@@ -25,3 +25,21 @@ func TestVMergeMetadataCorrectOverride(t *testing.T) {
 	assert.Equal(t, "B", m3.AlbumArtist)
 	assert.Equal(t, 2007, m3.ReleaseDate.Year())
 }
+
+func TestValidateMusicBrainzIds(t *testing.T) {
+	m := Metadata{
+		Artist:                 "A",
+		Name:                   "Track",
+		Type:                   Audio,
+		RegistrationDate:       time.Now(),
+		Checksum:               "checksum",
+		Path:                   "/data/track.flac",
+		MusicBrainzReleaseId:   "4b1e3ad2-1bd8-4f68-8c38-3c2c4b8b5e0a",
+		MusicBrainzRecordingId: "not-an-id",
+	}
+
+	errs := SanitizeAndValidateMetadata(&m)
+
+	assert.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "MusicBrainzRecordingId")
+}
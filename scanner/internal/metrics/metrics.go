@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "meelo_scanner"
+
+// Stages at which a file can fail
+const (
+	ParsingStage      = "parsing"
+	RegistrationStage = "registration"
+)
+
+// Buckets for external commands, that can take several seconds on large files
+var commandBuckets = prometheus.ExponentialBuckets(0.05, 2, 10)
+
+var (
+	FilesParsed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "files_parsed_total",
+		Help:      "Number of files parsed without error",
+	})
+	FilesFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "files_failed_total",
+		Help:      "Number of files that could not be parsed or registered",
+	}, []string{"stage"})
+	FilesRegistered = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "files_registered_total",
+		Help:      "Number of files whose metadata was saved in the API",
+	})
+	IllustrationsPosted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "illustrations_posted_total",
+		Help:      "Number of illustrations sent to the API, by type",
+	}, []string{"type"})
+
+	FfprobeDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ffprobe_duration_seconds",
+		Help:      "Duration of ffprobe calls",
+		Buckets:   commandBuckets,
+	})
+	FpcalcDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "fpcalc_duration_seconds",
+		Help:      "Duration of fpcalc calls",
+		Buckets:   commandBuckets,
+	})
+	LoudnessAnalysisDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "loudness_analysis_duration_seconds",
+		Help:      "Duration of the loudness analysis of files without ReplayGain tags",
+		Buckets:   commandBuckets,
+	})
+	ThumbnailExtractionDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "thumbnail_extraction_duration_seconds",
+		Help:      "Duration of the extraction of video thumbnails",
+		Buckets:   commandBuckets,
+	})
+	ApiRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "api_request_duration_seconds",
+		Help:      "Latency of the requests to the API, by method",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+	// The status is 'network' if the API could not be reached
+	ApiErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_errors_total",
+		Help:      "Number of failed requests to the API, by status code",
+	}, []string{"status"})
+
+	QueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "Number of pending tasks, by lane",
+	}, []string{"lane"})
+	TaskProgress = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "task_progress",
+		Help:      "Progress (between 0 and 100) of the task running in each lane. Zero if the lane is idle",
+	}, []string{"lane"})
+	OutboxSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "outbox_size",
+		Help:      "Number of API writes waiting for the API to be available",
+	})
+)
+
+// Observes the time elapsed since start in the histogram
+// Usage: defer ObserveDuration(histogram, time.Now())
+func ObserveDuration(histogram prometheus.Observer, start time.Time) {
+	histogram.Observe(time.Since(start).Seconds())
+}
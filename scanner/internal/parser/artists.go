@@ -0,0 +1,126 @@
+package parser
+
+import (
+	"strings"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal"
+	c "github.com/Arthi-chaud/Meelo/scanner/internal/config"
+)
+
+// Empty names are ignored
+func toArtistCredits(names []string) []internal.ArtistCredit {
+	credits := []internal.ArtistCredit{}
+	for _, name := range names {
+		if name = strings.TrimSpace(name); len(name) > 0 {
+			credits = append(credits, internal.ArtistCredit{Name: name})
+		}
+	}
+	return credits
+}
+
+// Fills the artist credits from the artist names, if the source did not set them
+// So that the names and the credits of a source are merged together
+func fillArtistCredits(m *internal.Metadata) {
+	if len(m.Artists) == 0 && len(m.Artist) > 0 {
+		m.Artists = toArtistCredits([]string{m.Artist})
+	}
+	if len(m.AlbumArtists) == 0 && len(m.AlbumArtist) > 0 {
+		m.AlbumArtists = toArtistCredits([]string{m.AlbumArtist})
+	}
+}
+
+// Splits the artist credits using the separators of the settings,
+// and sets the artist names to the first main artists
+// Without separators, the names are left for the API to parse, and the credits are only kept
+// if they come from a tag with one value per artist
+func splitArtistCredits(m *internal.Metadata, settings c.ArtistSettings) {
+	if len(settings.Separators) == 0 && len(settings.Featuring) == 0 {
+		m.Artists = keepListedCredits(m.Artists)
+		m.AlbumArtists = keepListedCredits(m.AlbumArtists)
+		// e.g. if the artists were only read from an 'ARTISTS' tag
+		if len(m.Artist) == 0 && len(m.Artists) > 0 {
+			m.Artist = m.Artists[0].Name
+		}
+		if len(m.AlbumArtist) == 0 && len(m.AlbumArtists) > 0 {
+			m.AlbumArtist = m.AlbumArtists[0].Name
+		}
+		return
+	}
+	m.Artists = splitCredits(m.Artists, settings)
+	m.AlbumArtists = splitCredits(m.AlbumArtists, settings)
+	if mainArtists := internal.FilterArtistNames(m.Artists, false); len(mainArtists) > 0 {
+		m.Artist = mainArtists[0]
+	}
+	if mainArtists := internal.FilterArtistNames(m.AlbumArtists, false); len(mainArtists) > 0 {
+		m.AlbumArtist = mainArtists[0]
+	}
+}
+
+// A single credit only holds the name of the artist, so it is dropped
+func keepListedCredits(credits []internal.ArtistCredit) []internal.ArtistCredit {
+	if len(credits) > 1 {
+		return credits
+	}
+	return nil
+}
+
+// Duplicates are removed. Names are compared case-insensitively
+func splitCredits(credits []internal.ArtistCredit, settings c.ArtistSettings) []internal.ArtistCredit {
+	if len(credits) == 0 {
+		return credits
+	}
+	splitCredits := []internal.ArtistCredit{}
+	seenNames := map[string]bool{}
+	for _, credit := range credits {
+		for _, splitCredit := range splitArtistName(credit.Name, settings) {
+			splitCredit.Featured = splitCredit.Featured || credit.Featured
+			if key := strings.ToLower(splitCredit.Name); !seenNames[key] {
+				seenNames[key] = true
+				splitCredits = append(splitCredits, splitCredit)
+			}
+		}
+	}
+	return splitCredits
+}
+
+// e.g. 'A & B feat. C' gives A and B as main artists, and C as a featured artist
+func splitArtistName(name string, settings c.ArtistSettings) []internal.ArtistCredit {
+	credits := []internal.ArtistCredit{}
+	featured := false
+	start := 0
+	addCredit := func(end int) {
+		if artistName := strings.TrimSpace(name[start:end]); len(artistName) > 0 {
+			credits = append(credits, internal.ArtistCredit{Name: artistName, Featured: featured})
+		}
+	}
+	for i := 0; i < len(name); {
+		if exception := matchAt(name, i, settings.Exceptions); len(exception) > 0 {
+			i += len(exception)
+		} else if separator := matchAt(name, i, settings.Featuring); len(separator) > 0 {
+			addCredit(i)
+			featured = true
+			i += len(separator)
+			start = i
+		} else if separator := matchAt(name, i, settings.Separators); len(separator) > 0 {
+			addCredit(i)
+			i += len(separator)
+			start = i
+		} else {
+			i++
+		}
+	}
+	addCredit(len(name))
+	return credits
+}
+
+// Returns the first candidate found at the index of the string, ignoring case
+// Returns an empty string if none is found
+func matchAt(s string, index int, candidates []string) string {
+	for _, candidate := range candidates {
+		end := index + len(candidate)
+		if len(candidate) > 0 && end <= len(s) && strings.EqualFold(s[index:end], candidate) {
+			return candidate
+		}
+	}
+	return ""
+}
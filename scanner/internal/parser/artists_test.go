@@ -0,0 +1,89 @@
+package parser
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func getArtistTestSettings() config.ArtistSettings {
+	return config.ArtistSettings{
+		Separators: []string{"; ", " & "},
+		Featuring:  []string{" feat. ", " ft. "},
+		Exceptions: []string{"Simon & Garfunkel"},
+	}
+}
+
+func TestSplitArtistName(t *testing.T) {
+	credits := splitArtistName("A & B feat. C; D", getArtistTestSettings())
+
+	assert.Equal(t, []internal.ArtistCredit{
+		{Name: "A"},
+		{Name: "B"},
+		{Name: "C", Featured: true},
+		{Name: "D", Featured: true},
+	}, credits)
+}
+
+func TestSplitArtistNameException(t *testing.T) {
+	credits := splitArtistName("simon & garfunkel FT. Artist", getArtistTestSettings())
+
+	assert.Equal(t, []internal.ArtistCredit{
+		{Name: "simon & garfunkel"},
+		{Name: "Artist", Featured: true},
+	}, credits)
+}
+
+func TestSplitArtistNameWithoutSeparators(t *testing.T) {
+	credits := splitArtistName("A & B feat. C", config.ArtistSettings{})
+
+	assert.Equal(t, []internal.ArtistCredit{{Name: "A & B feat. C"}}, credits)
+}
+
+func TestSplitArtistCredits(t *testing.T) {
+	m := internal.Metadata{
+		Artist:       "A feat. B",
+		Artists:      []internal.ArtistCredit{{Name: "A feat. B"}, {Name: "a"}},
+		AlbumArtist:  "A & C",
+		AlbumArtists: []internal.ArtistCredit{{Name: "A & C"}},
+	}
+
+	splitArtistCredits(&m, getArtistTestSettings())
+
+	assert.Equal(t, "A", m.Artist)
+	assert.Equal(t, []internal.ArtistCredit{{Name: "A"}, {Name: "B", Featured: true}}, m.Artists)
+	assert.Equal(t, "A", m.AlbumArtist)
+	assert.Equal(t, []internal.ArtistCredit{{Name: "A"}, {Name: "C"}}, m.AlbumArtists)
+}
+
+func TestArtistNamesAreNotSplitByDefault(t *testing.T) {
+	m := internal.Metadata{
+		Artist:       "A feat. B",
+		Artists:      []internal.ArtistCredit{{Name: "A feat. B"}},
+		AlbumArtist:  "A & C",
+		AlbumArtists: []internal.ArtistCredit{{Name: "A"}, {Name: "C"}},
+	}
+
+	splitArtistCredits(&m, config.ArtistSettings{})
+
+	assert.Equal(t, "A feat. B", m.Artist)
+	assert.Empty(t, m.Artists)
+	// The name of the album artist is kept, so that the album does not change
+	assert.Equal(t, "A & C", m.AlbumArtist)
+	assert.Equal(t, []internal.ArtistCredit{{Name: "A"}, {Name: "C"}}, m.AlbumArtists)
+}
+
+func TestParserSplitsArtists(t *testing.T) {
+	settings := getParserTestConfig()
+	settings.Artists = getArtistTestSettings()
+	path := "/data/My Album Artist/My Album (2006)/1-02 My Track (My Artist ft. Other Artist).m4a"
+	m, _ := ParseMetadata(context.Background(), settings, path)
+
+	assert.Equal(t, "My Artist", m.Artist)
+	assert.Equal(t, []internal.ArtistCredit{{Name: "My Artist"}, {Name: "Other Artist", Featured: true}}, m.Artists)
+	assert.Equal(t, "My Album Artist", m.AlbumArtist)
+	assert.Equal(t, []internal.ArtistCredit{{Name: "My Album Artist"}}, m.AlbumArtists)
+}
@@ -2,6 +2,7 @@ package parser
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
 	"strconv"
@@ -11,6 +12,8 @@ import (
 	"github.com/Arthi-chaud/Meelo/scanner/internal"
 	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
 	"github.com/Arthi-chaud/Meelo/scanner/internal/illustration"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/metrics"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/tags"
 	"gopkg.in/vansante/go-ffprobe.v2"
 )
 
@@ -18,6 +21,12 @@ type parseTagFn func(string)
 
 // Tries to get each tag by key one after the other. If it success, calls function and returns
 func ParseTag(t ffprobe.Tags, keys []string, fun parseTagFn) {
+	findTag(t, keys, fun)
+}
+
+// Same as ParseTag, but returns the key of the tag that was used
+// Returns an empty string if none of the keys were found
+func findTag(t ffprobe.Tags, keys []string, fun parseTagFn) string {
 	for _, key := range keys {
 		value, found := t[key]
 		if !found {
@@ -26,9 +35,10 @@ func ParseTag(t ffprobe.Tags, keys []string, fun parseTagFn) {
 		var s string = value.(string)
 		if len(s) > 0 {
 			fun(s)
-			return
+			return key
 		}
 	}
+	return ""
 }
 
 func CollectTags(probeData *ffprobe.ProbeData) ffprobe.Tags {
@@ -52,14 +62,87 @@ func CollectTags(probeData *ffprobe.ProbeData) ffprobe.Tags {
 // https://github.com/FFmpeg/FFmpeg/blob/c5287178b4dc373e763f7cd49703a6e3192aab3a/libavformat/id3v2.c#L105
 // https://mutagen-specs.readthedocs.io/en/latest/id3/id3v2.4.0-frames.html
 
-func parseMetadataFromEmbeddedTags(filePath string, c config.UserSettings) (internal.Metadata, []error) {
-	ctx, cancelFn := context.WithCancel(context.Background())
-	defer cancelFn()
+// ffprobe keys of each field, by order of priority
+var ffprobeKeys = map[tags.Key][]string{
+	tags.Artist:       {"artist", "tope"},
+	tags.Album:        {"album"},
+	tags.AlbumArtist:  {"album_artist", "albumartist"},
+	tags.Artists:      {"artists"},
+	tags.AlbumArtists: {"albumartists", "album_artists"},
+	tags.Title:        {"title"},
+	tags.DiscSubtitle: {"discsubtitle"},
+	tags.Genre:        {"genres", "genre", "tcon"},
+	tags.Compilation:  {"compilation", "compilations", "itunescompilation"},
+	tags.TrackNumber:  {"track", "trck"},
+	tags.Lyrics:       {"lyrics", "uslt"},
+	tags.Bpm:          {"bpm", "tbp"},
+	tags.DiscNumber:   {"disc", "tpos"},
+	// MP3s only store year(?)
+	tags.Date: {"date", "tory", "tyer", "year"},
+
+	tags.MusicBrainzRecordingId:    {"musicbrainz_trackid", "musicbrainz track id"},
+	tags.MusicBrainzTrackId:        {"musicbrainz_releasetrackid", "musicbrainz release track id"},
+	tags.MusicBrainzReleaseId:      {"musicbrainz_albumid", "musicbrainz album id"},
+	tags.MusicBrainzReleaseGroupId: {"musicbrainz_releasegroupid", "musicbrainz release group id"},
+	tags.MusicBrainzArtistId:       {"musicbrainz_artistid", "musicbrainz artist id"},
+	tags.MusicBrainzAlbumArtistId:  {"musicbrainz_albumartistid", "musicbrainz album artist id"},
+	tags.Isrc:                      {"isrc", "tsrc"},
+	tags.Barcode:                   {"barcode"},
+	tags.CatalogNumber:             {"catalognumber"},
+	tags.Label:                     {"label", "publisher", "organization"},
+	tags.ReplayGainTrackGain:       {"replaygain_track_gain"},
+	tags.ReplayGainTrackPeak:       {"replaygain_track_peak"},
+	tags.ReplayGainAlbumGain:       {"replaygain_album_gain"},
+	tags.ReplayGainAlbumPeak:       {"replaygain_album_peak"},
+	tags.R128TrackGain:             {"r128_track_gain"},
+	tags.R128AlbumGain:             {"r128_album_gain"},
+}
+
+// Returns the values of the field, and the key of the tag they were read from
+// Returns nil if the field is not set
+type tagLookupFn func(key tags.Key) ([]string, string)
+
+func parseMetadataFromEmbeddedTags(ctx context.Context, filePath string, c config.UserSettings) (internal.Metadata, []error) {
+	metadata, _, errors := parseEmbeddedTags(ctx, filePath, c)
+	return metadata, errors
+}
+
+// Also returns the key of the tag each field was read from
+// Tags of common audio formats are read natively. ffprobe is used for the other files, e.g. videos
+func parseEmbeddedTags(ctx context.Context, filePath string, c config.UserSettings) (internal.Metadata, map[string]string, []error) {
+	file, err := tags.Read(filePath)
+	if errors.Is(err, tags.ErrUnsupportedFormat) {
+		return parseProbedTags(ctx, filePath, c)
+	}
+	if err != nil {
+		return internal.Metadata{}, map[string]string{}, []error{err}
+	}
+	metadata := internal.Metadata{
+		Type:     internal.Audio,
+		Duration: int64(file.Duration.Seconds()),
+		Bitrate:  file.Bitrate / 1000,
+	}
+	parseFileProperties(&metadata, file)
+	tagKeys := parseTagFields(&metadata, c, func(key tags.Key) ([]string, string) {
+		frame, _ := file.Find(key)
+		return frame.Values, frame.Id
+	})
+	if !c.UseEmbeddedThumbnails && len(file.Pictures) > 0 {
+		metadata.IllustrationLocation = internal.Embedded
+		// ffmpeg creates a stream for each picture, after the audio streams
+		metadata.IllustrationStreamIndex = file.StreamCount
+	}
+	return metadata, tagKeys, nil
+}
+
+func parseProbedTags(ctx context.Context, filePath string, c config.UserSettings) (internal.Metadata, map[string]string, []error) {
 	var errors []error
 
+	probeStart := time.Now()
 	probeData, err := ffprobe.ProbeURL(ctx, filePath)
+	metrics.ObserveDuration(metrics.FfprobeDuration, probeStart)
 	if err != nil {
-		return internal.Metadata{}, []error{err}
+		return internal.Metadata{}, map[string]string{}, []error{err}
 	}
 	var metadata internal.Metadata
 	if bitrate, err := strconv.Atoi(probeData.Format.BitRate); err == nil {
@@ -69,30 +152,78 @@ func parseMetadataFromEmbeddedTags(filePath string, c config.UserSettings) (inte
 	}
 	metadata.Duration = int64(probeData.Format.DurationSeconds)
 	metadata.Type = getType(*probeData)
-	tags := CollectTags(probeData)
+	parseProbedProperties(&metadata, *probeData)
+	probedTags := CollectTags(probeData)
+	tagKeys := parseTagFields(&metadata, c, func(key tags.Key) ([]string, string) {
+		var values []string
+		tagKey := findTag(probedTags, ffprobeKeys[key], func(value string) {
+			values = []string{value}
+		})
+		return values, tagKey
+	})
+
+	if !c.UseEmbeddedThumbnails {
+		if streamIndex := illustration.GetEmbeddedIllustrationStreamIndex(*probeData); streamIndex >= 0 {
+			metadata.IllustrationLocation = internal.Embedded
+			metadata.IllustrationStreamIndex = streamIndex
+		}
+	}
+	return metadata, tagKeys, errors
+}
 
-	ParseTag(tags, []string{"artist", "tope"}, func(value string) {
+// Sets the fields of the metadata from the tags
+// Returns the key of the tag each field was read from
+func parseTagFields(metadata *internal.Metadata, c config.UserSettings, lookup tagLookupFn) map[string]string {
+	tagKeys := map[string]string{}
+	parseTags := func(field string, key tags.Key, fun func([]string)) {
+		if values, tagKey := lookup(key); len(values) > 0 {
+			fun(values)
+			tagKeys[field] = tagKey
+		}
+	}
+	parseTag := func(field string, key tags.Key, fun parseTagFn) {
+		parseTags(field, key, func(values []string) {
+			if len(values[0]) > 0 {
+				fun(values[0])
+			}
+		})
+	}
+
+	parseTag("Artist", tags.Artist, func(value string) {
 		metadata.Artist = value
 	})
-	ParseTag(tags, []string{"album"}, func(value string) {
+	parseTag("Album", tags.Album, func(value string) {
 		metadata.Album = value
 	})
-	ParseTag(tags, []string{"album_artist", "albumartist"}, func(value string) {
+	parseTag("AlbumArtist", tags.AlbumArtist, func(value string) {
 		metadata.AlbumArtist = value
 	})
-	ParseTag(tags, []string{"title"}, func(value string) {
+	// Tags with one value per artist are preferred over repeated artist tags
+	for _, key := range []tags.Key{tags.Artist, tags.Artists} {
+		parseTags("Artists", key, func(values []string) {
+			metadata.Artists = toArtistCredits(values)
+		})
+	}
+	for _, key := range []tags.Key{tags.AlbumArtist, tags.AlbumArtists} {
+		parseTags("AlbumArtists", key, func(values []string) {
+			metadata.AlbumArtists = toArtistCredits(values)
+		})
+	}
+	parseTag("Name", tags.Title, func(value string) {
 		metadata.Name = value
 	})
-	ParseTag(tags, []string{"discsubtitle"}, func(value string) {
+	parseTag("DiscName", tags.DiscSubtitle, func(value string) {
 		metadata.DiscName = value
 	})
-	ParseTag(tags, []string{"genres", "genre", "tcon"}, func(value string) {
-		metadata.Genres = strings.FieldsFunc(value, func(r rune) bool {
-			return r == ';' || r == '\\' || r == ','
-		})
+	parseTags("Genres", tags.Genre, func(values []string) {
+		for _, value := range values {
+			metadata.Genres = append(metadata.Genres, strings.FieldsFunc(value, func(r rune) bool {
+				return r == ';' || r == '\\' || r == ','
+			})...)
+		}
 	})
 	if c.Compilations.UseID3CompTag {
-		ParseTag(tags, []string{"compilation", "compilations", "itunescompilation"}, func(value string) {
+		parseTag("IsCompilation", tags.Compilation, func(value string) {
 			isCompilation, err := strconv.ParseBool(value)
 			if err != nil {
 				flag, err := strconv.ParseInt(value, 10, 64)
@@ -104,12 +235,12 @@ func parseMetadataFromEmbeddedTags(filePath string, c config.UserSettings) (inte
 			}
 		})
 	}
-	ParseTag(tags, []string{"track", "trck"}, func(value string) {
+	parseTag("Index", tags.TrackNumber, func(value string) {
 		rawTrackValue, _, _ := strings.Cut(value, "/")
 		trackValue, _ := strconv.Atoi(rawTrackValue)
 		metadata.Index = int64(trackValue)
 	})
-	ParseTag(tags, []string{"lyrics", "uslt"}, func(value string) {
+	parseTag("Lyrics", tags.Lyrics, func(value string) {
 		metadata.Lyrics = strings.Split(
 			strings.ReplaceAll(
 				strings.ReplaceAll(value, "\r", "\n"),
@@ -119,19 +250,78 @@ func parseMetadataFromEmbeddedTags(filePath string, c config.UserSettings) (inte
 			"\n",
 		)
 	})
-	ParseTag(tags, []string{"bpm", "tbp"}, func(value string) {
+	parseTag("Bpm", tags.Bpm, func(value string) {
 		bpm, err := strconv.ParseFloat(value, 64)
 		if err == nil {
 			metadata.Bpm = bpm
 		}
 	})
-	ParseTag(tags, []string{"disc", "tpos"}, func(value string) {
+	parseTag("DiscIndex", tags.DiscNumber, func(value string) {
 		rawDiscValue, _, _ := strings.Cut(value, "/")
 		discValue, _ := strconv.Atoi(rawDiscValue)
 		metadata.DiscIndex = int64(discValue)
 	})
 
-	ParseTag(tags, []string{"date", "tory", "tyer"}, func(value string) {
+	parseTag("MusicBrainzRecordingId", tags.MusicBrainzRecordingId, func(value string) {
+		metadata.MusicBrainzRecordingId = strings.TrimSpace(value)
+	})
+	parseTag("MusicBrainzTrackId", tags.MusicBrainzTrackId, func(value string) {
+		metadata.MusicBrainzTrackId = strings.TrimSpace(value)
+	})
+	parseTag("MusicBrainzReleaseId", tags.MusicBrainzReleaseId, func(value string) {
+		metadata.MusicBrainzReleaseId = strings.TrimSpace(value)
+	})
+	parseTag("MusicBrainzReleaseGroupId", tags.MusicBrainzReleaseGroupId, func(value string) {
+		metadata.MusicBrainzReleaseGroupId = strings.TrimSpace(value)
+	})
+	parseTags("MusicBrainzArtistIds", tags.MusicBrainzArtistId, func(values []string) {
+		metadata.MusicBrainzArtistIds = splitIds(values)
+	})
+	parseTags("MusicBrainzAlbumArtistIds", tags.MusicBrainzAlbumArtistId, func(values []string) {
+		metadata.MusicBrainzAlbumArtistIds = splitIds(values)
+	})
+	parseTag("Isrc", tags.Isrc, func(value string) {
+		// Usually written with hyphens (e.g. 'US-RC1-76-07839')
+		metadata.Isrc = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(value))
+	})
+	parseTag("Barcode", tags.Barcode, func(value string) {
+		metadata.Barcode = strings.TrimSpace(value)
+	})
+	parseTag("CatalogNumber", tags.CatalogNumber, func(value string) {
+		metadata.CatalogNumber = strings.TrimSpace(value)
+	})
+	parseTag("Label", tags.Label, func(value string) {
+		metadata.Label = strings.TrimSpace(value)
+	})
+
+	// e.g. '-6.54 dB'
+	parseGain := func(field string, key tags.Key, target **float64) {
+		parseTag(field, key, func(value string) {
+			value = strings.TrimSpace(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(value)), "db"))
+			if gain, err := strconv.ParseFloat(value, 64); err == nil {
+				*target = &gain
+			}
+		})
+	}
+	parseGain("TrackGain", tags.ReplayGainTrackGain, &metadata.TrackGain)
+	parseGain("TrackPeak", tags.ReplayGainTrackPeak, &metadata.TrackPeak)
+	parseGain("AlbumGain", tags.ReplayGainAlbumGain, &metadata.AlbumGain)
+	parseGain("AlbumPeak", tags.ReplayGainAlbumPeak, &metadata.AlbumPeak)
+	parseR128Gain := func(field string, key tags.Key, target **float64) {
+		if *target != nil {
+			return
+		}
+		parseTag(field, key, func(value string) {
+			if q78, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+				gain := float64(q78)/256 + internal.ReplayGainReferenceLoudness - internal.R128ReferenceLoudness
+				*target = &gain
+			}
+		})
+	}
+	parseR128Gain("TrackGain", tags.R128TrackGain, &metadata.TrackGain)
+	parseR128Gain("AlbumGain", tags.R128AlbumGain, &metadata.AlbumGain)
+
+	parseDate := func(value string) {
 		// iTunes purchases use an ISO format
 		for _, format := range []string{"2006", time.DateOnly, time.DateTime, time.RFC3339} {
 			date, err := time.Parse(format, value)
@@ -139,24 +329,27 @@ func parseMetadataFromEmbeddedTags(filePath string, c config.UserSettings) (inte
 				metadata.ReleaseDate = &date
 			}
 		}
-	})
+	}
+	parseTag("ReleaseDate", tags.Date, parseDate)
 	if metadata.ReleaseDate == nil {
-		ParseTag(tags, []string{"year"}, func(value string) {
-			// MP3s only store year(?)
-			date, err := time.Parse("2006", value)
-			if err == nil {
-				metadata.ReleaseDate = &date
-			}
-		})
+		parseTag("ReleaseDate", tags.OriginalDate, parseDate)
 	}
+	return tagKeys
+}
 
-	if !c.UseEmbeddedThumbnails {
-		if streamIndex := illustration.GetEmbeddedIllustrationStreamIndex(*probeData); streamIndex >= 0 {
-			metadata.IllustrationLocation = internal.Embedded
-			metadata.IllustrationStreamIndex = streamIndex
+// Multiple IDs can also be in a single value (e.g. with ffprobe, or in ID3v2.3 tags)
+func splitIds(values []string) []string {
+	ids := []string{}
+	for _, value := range values {
+		for _, id := range strings.FieldsFunc(value, func(r rune) bool {
+			return r == ';' || r == '/' || r == ','
+		}) {
+			if id = strings.TrimSpace(id); len(id) > 0 {
+				ids = append(ids, id)
+			}
 		}
 	}
-	return metadata, errors
+	return ids
 }
 
 func getType(probeData ffprobe.ProbeData) internal.TrackType {
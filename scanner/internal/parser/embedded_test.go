@@ -1,12 +1,14 @@
 package parser
 
 import (
+	"context"
 	p "path"
 	"testing"
 	"time"
 
 	"github.com/Arthi-chaud/Meelo/scanner/internal"
 	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/tags"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -20,7 +22,7 @@ func getTestConfig() config.UserSettings {
 
 func TestEmbedded(t *testing.T) {
 	path := p.Join("../..", "testdata", "dreams.m4a")
-	m, err := parseMetadataFromEmbeddedTags(path, getTestConfig())
+	m, err := parseMetadataFromEmbeddedTags(context.Background(), path, getTestConfig())
 
 	assert.Len(t, err, 0)
 	assert.Equal(t, "My Album Artist", m.AlbumArtist)
@@ -37,11 +39,15 @@ func TestEmbedded(t *testing.T) {
 	assert.Equal(t, []string{"Pop", "Rock", "Trip-Hop"}, m.Genres)
 	assert.Equal(t, []string{"A", "B", "C", "", "D", "", "", "E", ""}, m.Lyrics)
 	assert.Equal(t, "Dreams", m.Name)
+	assert.Equal(t, "mp4", m.Container)
+	assert.Equal(t, "aac", m.AudioCodec)
+	assert.Equal(t, false, m.Lossless)
+	assert.Equal(t, 0, m.BitDepth)
 }
 
 func TestEmbeddedFlac(t *testing.T) {
 	path := p.Join("../..", "testdata", "test.flac")
-	m, err := parseMetadataFromEmbeddedTags(path, getTestConfig())
+	m, err := parseMetadataFromEmbeddedTags(context.Background(), path, getTestConfig())
 
 	assert.Len(t, err, 0)
 	assert.Equal(t, "Album Artist", m.AlbumArtist)
@@ -57,11 +63,18 @@ func TestEmbeddedFlac(t *testing.T) {
 	assert.Equal(t, int64(1), m.Index)
 	assert.Empty(t, m.Genres)
 	assert.Equal(t, "Title", m.Name)
+	assert.Equal(t, "flac", m.Container)
+	assert.Equal(t, "flac", m.AudioCodec)
+	assert.Equal(t, true, m.Lossless)
+	assert.Equal(t, 44100, m.SampleRate)
+	assert.Equal(t, 24, m.BitDepth)
+	assert.Equal(t, 2, m.Channels)
+	assert.Equal(t, "stereo", m.ChannelLayout)
 }
 
 func TestEmbeddedOpus(t *testing.T) {
 	path := p.Join("../..", "testdata", "test.opus")
-	m, err := parseMetadataFromEmbeddedTags(path, getTestConfig())
+	m, err := parseMetadataFromEmbeddedTags(context.Background(), path, getTestConfig())
 
 	assert.Len(t, err, 0)
 	assert.Equal(t, "Album Artist", m.AlbumArtist)
@@ -77,3 +90,48 @@ func TestEmbeddedOpus(t *testing.T) {
 	assert.Empty(t, m.Genres)
 	assert.Equal(t, "Title", m.Name)
 }
+
+func TestEmbeddedMusicBrainzIds(t *testing.T) {
+	values := map[tags.Key][]string{
+		tags.MusicBrainzRecordingId:   {"0f9c1ab6-6d6a-4a3e-9b4c-2b8b9b1d7f10"},
+		tags.MusicBrainzReleaseId:     {"4b1e3ad2-1bd8-4f68-8c38-3c2c4b8b5e0a"},
+		tags.MusicBrainzArtistId:      {"a1b2c3d4-0000-4000-8000-000000000001; a1b2c3d4-0000-4000-8000-000000000002"},
+		tags.MusicBrainzAlbumArtistId: {"a1b2c3d4-0000-4000-8000-000000000001"},
+		tags.Isrc:                     {"us-rc1-76-07839"},
+		tags.CatalogNumber:            {"CAT 001"},
+		tags.Label:                    {"Label"},
+	}
+	var m internal.Metadata
+	tagKeys := parseTagFields(&m, getTestConfig(), func(key tags.Key) ([]string, string) {
+		return values[key], string(key)
+	})
+
+	assert.Equal(t, "0f9c1ab6-6d6a-4a3e-9b4c-2b8b9b1d7f10", m.MusicBrainzRecordingId)
+	assert.Equal(t, "4b1e3ad2-1bd8-4f68-8c38-3c2c4b8b5e0a", m.MusicBrainzReleaseId)
+	assert.Empty(t, m.MusicBrainzReleaseGroupId)
+	assert.Equal(t, []string{"a1b2c3d4-0000-4000-8000-000000000001", "a1b2c3d4-0000-4000-8000-000000000002"}, m.MusicBrainzArtistIds)
+	assert.Equal(t, []string{"a1b2c3d4-0000-4000-8000-000000000001"}, m.MusicBrainzAlbumArtistIds)
+	assert.Equal(t, "USRC17607839", m.Isrc)
+	assert.Equal(t, "CAT 001", m.CatalogNumber)
+	assert.Equal(t, "Label", m.Label)
+	assert.Equal(t, "musicbrainz_release_id", tagKeys["MusicBrainzReleaseId"])
+}
+
+func TestEmbeddedReplayGain(t *testing.T) {
+	values := map[tags.Key][]string{
+		tags.ReplayGainTrackGain: {"-6.54 dB"},
+		tags.ReplayGainTrackPeak: {"0.988831"},
+		tags.R128TrackGain:       {"-512"},
+		tags.R128AlbumGain:       {"-768"},
+	}
+	var m internal.Metadata
+	parseTagFields(&m, getTestConfig(), func(key tags.Key) ([]string, string) {
+		return values[key], string(key)
+	})
+
+	assert.Equal(t, -6.54, *m.TrackGain)
+	assert.Equal(t, 0.988831, *m.TrackPeak)
+	// R128 gains are only used if there are no ReplayGain tags, and are relative to -23 LUFS
+	assert.Equal(t, 2.0, *m.AlbumGain)
+	assert.Nil(t, m.AlbumPeak)
+}
@@ -0,0 +1,120 @@
+package parser
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal"
+	c "github.com/Arthi-chaud/Meelo/scanner/internal/config"
+)
+
+type FieldSource string
+
+const (
+	PathSource     FieldSource = "path"
+	EmbeddedSource FieldSource = "embedded"
+	// The value was computed by the scanner (e.g. checksum) or during sanitization
+	ScannerSource FieldSource = "scanner"
+)
+
+// Where the value of a metadata field comes from
+type FieldOrigin struct {
+	Source FieldSource `json:"source"`
+	// For the path source, the name of the regex group the value was read from
+	RegexGroup string `json:"regex_group,omitempty"`
+	// For the embedded source, the key of the tag the value was read from
+	TagKey string `json:"tag_key,omitempty"`
+}
+
+// Result of a single source
+type SourceExplanation struct {
+	Metadata internal.Metadata `json:"metadata"`
+	Errors   []string          `json:"errors"`
+}
+
+// Explains how the metadata of a file was parsed
+type ParseExplanation struct {
+	Metadata internal.Metadata `json:"metadata"`
+	// Null if the source was not used
+	Path *SourceExplanation `json:"path"`
+	// Null if the source was not used
+	Embedded *SourceExplanation `json:"embedded"`
+	// Regex that matched the path. Empty if none did, or if the path was not parsed
+	MatchedRegex string `json:"matched_regex"`
+	// Key is the name of the field in the final metadata. Empty fields are omitted
+	Fields map[string]FieldOrigin `json:"fields"`
+	// Errors that are not validation errors (merge, checksum, etc.)
+	Errors           []string `json:"errors"`
+	ValidationErrors []string `json:"validation_errors"`
+}
+
+// Parses the metadata of the file, and explains where each field came from
+func ExplainMetadata(ctx context.Context, config c.UserSettings, filePath string) ParseExplanation {
+	res := parseMetadata(ctx, config, filePath)
+	explanation := ParseExplanation{
+		Metadata:         res.metadata,
+		Path:             explainSource(res.path),
+		Embedded:         explainSource(res.embedded),
+		MatchedRegex:     res.matchedRegex,
+		Fields:           map[string]FieldOrigin{},
+		Errors:           errorsToStrings(res.errors),
+		ValidationErrors: errorsToStrings(res.validationErrors),
+	}
+	// Sources, by order of priority in the merge
+	sources := []FieldSource{EmbeddedSource, PathSource}
+	if config.Metadata.Source == c.Path {
+		sources = []FieldSource{PathSource, EmbeddedSource}
+	}
+	final := reflect.ValueOf(res.metadata)
+	for i := 0; i < final.NumField(); i++ {
+		field := final.Type().Field(i).Name
+		value := final.Field(i)
+		if value.IsZero() {
+			continue
+		}
+		origin := FieldOrigin{Source: ScannerSource}
+		for _, source := range sources {
+			var sourceMetadata *sourceMetadata
+			if source == PathSource {
+				sourceMetadata = res.path
+			} else {
+				sourceMetadata = res.embedded
+			}
+			if sourceMetadata == nil {
+				continue
+			}
+			sourceValue := reflect.ValueOf(sourceMetadata.metadata).Field(i)
+			if sourceValue.IsZero() {
+				continue
+			}
+			// Otherwise, the value was changed during sanitization
+			if reflect.DeepEqual(value.Interface(), sourceValue.Interface()) {
+				origin = FieldOrigin{Source: source}
+				if source == PathSource {
+					origin.RegexGroup = sourceMetadata.keys[field]
+				} else {
+					origin.TagKey = sourceMetadata.keys[field]
+				}
+			}
+			break
+		}
+		explanation.Fields[field] = origin
+	}
+	return explanation
+}
+
+func explainSource(source *sourceMetadata) *SourceExplanation {
+	if source == nil {
+		return nil
+	}
+	return &SourceExplanation{
+		Metadata: source.metadata,
+		Errors:   errorsToStrings(source.errors),
+	}
+}
+
+func errorsToStrings(errs []error) []string {
+	return internal.Fmap(errs, func(err error, _ int) string {
+		return err.Error()
+	})
+}
@@ -1,38 +1,86 @@
 package parser
 
 import (
-	"path"
+	"context"
 	"strings"
 	"time"
 
 	"github.com/Arthi-chaud/Meelo/scanner/internal"
 	c "github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/metrics"
 	"github.com/rs/zerolog/log"
 )
 
-func ParseMetadata(config c.UserSettings, filePath string) (internal.Metadata, []error) {
-	var metadata internal.Metadata
-	var errors []error
+func ParseMetadata(ctx context.Context, config c.UserSettings, filePath string) (internal.Metadata, []error) {
+	res := parseMetadata(ctx, config, filePath)
+	errs := append(res.errors, res.validationErrors...)
+	if len(errs) > 0 {
+		metrics.FilesFailed.WithLabelValues(metrics.ParsingStage).Inc()
+	} else {
+		metrics.FilesParsed.Inc()
+	}
+	return res.metadata, errs
+}
+
+// Metadata parsed from a single source (path or embedded tags)
+type sourceMetadata struct {
+	metadata internal.Metadata
+	errors   []error
+	// Name of the regex group or of the tag each field was read from
+	keys map[string]string
+}
+
+// Outcome of the parsing, with the intermediate results
+type parseResult struct {
+	metadata internal.Metadata
+	// Errors that are not validation errors
+	errors           []error
+	validationErrors []error
+	// Nil if the source was not used
+	path     *sourceMetadata
+	embedded *sourceMetadata
+	// Regex that matched the path. Empty if none did, or if the path was not parsed
+	matchedRegex string
+}
+
+func parseMetadata(ctx context.Context, config c.UserSettings, filePath string) parseResult {
+	res := parseResult{}
+	parsePathSource := func() *sourceMetadata {
+		source := &sourceMetadata{}
+		source.metadata, res.matchedRegex, source.keys, source.errors = parsePath(config, filePath)
+		fillArtistCredits(&source.metadata)
+		return source
+	}
+	parseEmbeddedSource := func() *sourceMetadata {
+		source := &sourceMetadata{}
+		source.metadata, source.keys, source.errors = parseEmbeddedTags(ctx, filePath, config)
+		fillArtistCredits(&source.metadata)
+		return source
+	}
 	if config.Metadata.Order == c.Only {
 		if config.Metadata.Source == c.Path {
-			metadata, errors = parseMetadataFromPath(config, filePath)
+			res.path = parsePathSource()
+			res.metadata, res.errors = res.path.metadata, res.path.errors
 		} else {
-			metadata, errors = parseMetadataFromEmbeddedTags(filePath, config)
+			res.embedded = parseEmbeddedSource()
+			res.metadata, res.errors = res.embedded.metadata, res.embedded.errors
 		}
 	} else {
-		embeddedMetadata, embeddedErrors := parseMetadataFromEmbeddedTags(filePath, config)
-		pathMetadata, pathErrors := parseMetadataFromPath(config, filePath)
-		errors = append(pathErrors, embeddedErrors...)
+		res.embedded = parseEmbeddedSource()
+		res.path = parsePathSource()
+		res.errors = append(res.path.errors, res.embedded.errors...)
 		var err error
 		if config.Metadata.Source == c.Path {
-			metadata, err = internal.Merge(pathMetadata, embeddedMetadata)
+			res.metadata, err = internal.Merge(res.path.metadata, res.embedded.metadata)
 		} else {
-			metadata, err = internal.Merge(embeddedMetadata, pathMetadata)
+			res.metadata, err = internal.Merge(res.embedded.metadata, res.path.metadata)
 		}
 		if err != nil {
-			errors = append(errors, err)
+			res.errors = append(res.errors, err)
 		}
 	}
+	metadata := &res.metadata
+	splitArtistCredits(metadata, config.Artists)
 	compilationArtistNames := internal.Fmap(
 		append(config.Compilations.Artists, internal.CompilationKeyword),
 		func(a string, _ int) string {
@@ -45,19 +93,30 @@ func ParseMetadata(config c.UserSettings, filePath string) (internal.Metadata, [
 	metadata.RegistrationDate = time.Now()
 	checksum, err := internal.ComputeChecksum(filePath)
 	if err != nil {
-		errors = append(errors, err)
+		res.errors = append(res.errors, err)
 	}
 	metadata.Checksum = checksum
 	// Let's save some time by skipping acoustid for unreasonably long media
 	if metadata.Type == internal.Audio || metadata.Duration < 1200 { // 20 minutes
-		fingerprint, err := internal.GetFileAcousticFingerprint(filePath)
+		fingerprint, err := internal.GetFileAcousticFingerprint(ctx, filePath)
 		if err != nil {
 			// Fingerprinting failure is not fatal
-			log.Error().Str("file", path.Base(filePath)).Msg("failed to compute fingerprint")
-			log.Trace().Msg(err.Error())
+			log.Ctx(ctx).Error().Err(err).Msg("failed to compute fingerprint")
 		} else {
 			metadata.Fingerprint = &fingerprint
 		}
 	}
-	return metadata, append(errors, internal.SanitizeAndValidateMetadata(&metadata)...)
+	if config.Loudness.Analyze && metadata.TrackGain == nil && metadata.Type == internal.Audio {
+		loudness, err := internal.GetFileLoudness(ctx, filePath)
+		if err != nil {
+			// Like fingerprinting, the analysis is not fatal
+			log.Ctx(ctx).Error().Err(err).Msg("failed to analyze loudness")
+		} else {
+			gain, peak := loudness.Gain(), loudness.Peak()
+			metadata.TrackGain = &gain
+			metadata.TrackPeak = &peak
+		}
+	}
+	res.validationErrors = internal.SanitizeAndValidateMetadata(metadata)
+	return res
 }
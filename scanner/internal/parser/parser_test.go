@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"context"
 	"path"
 	"testing"
 	"time"
@@ -30,7 +31,7 @@ func getParserTestConfig() config.UserSettings {
 
 func TestParser(t *testing.T) {
 	path := "/data/My Album Artist/My Album (2006)/1-02 My Track (My Artist).m4a"
-	m, err := ParseMetadata(getParserTestConfig(), path)
+	m, err := ParseMetadata(context.Background(), getParserTestConfig(), path)
 
 	assert.Len(t, err, 2) // stat failure and no checksum
 	assert.Equal(t, "My Album Artist", m.AlbumArtist)
@@ -50,7 +51,7 @@ func TestParser(t *testing.T) {
 
 func TestParserCompilation(t *testing.T) {
 	path := "/data/Compilations/My Album (2006)/1-02 My Track.m4v"
-	m, err := ParseMetadata(getParserTestConfig(), path)
+	m, err := ParseMetadata(context.Background(), getParserTestConfig(), path)
 
 	assert.Len(t, err, 3) // stat failure, missing artist and no checksum
 	assert.Contains(t, err[1].Error(), "Metadata.Artist")
@@ -75,7 +76,7 @@ func TestParserEmbedded(t *testing.T) {
 	c.TrackRegex = []string{"^.*$"}
 	c.Metadata.Order = config.Preferred
 	c.Metadata.Source = config.Path
-	m, err := ParseMetadata(c, path)
+	m, err := ParseMetadata(context.Background(), c, path)
 
 	assert.Len(t, err, 0)
 	assert.Equal(t, "My Album Artist", m.AlbumArtist)
@@ -102,7 +103,7 @@ func TestParserEmbeddedIsCompilation(t *testing.T) {
 	c.Metadata.Order = config.Preferred
 	c.Metadata.Source = config.Path
 	c.Compilations.UseID3CompTag = true
-	m, err := ParseMetadata(c, path)
+	m, err := ParseMetadata(context.Background(), c, path)
 
 	assert.Len(t, err, 0)
 	assert.Equal(t, "", m.AlbumArtist)
@@ -124,7 +125,7 @@ func TestParserEmbeddedIsCompilation(t *testing.T) {
 
 func TestParserStandaloneTrack(t *testing.T) {
 	path := "/data/Lady Gaga/Unknown Album/Bad Romance.m4v"
-	m, err := ParseMetadata(getParserTestConfig(), path)
+	m, err := ParseMetadata(context.Background(), getParserTestConfig(), path)
 
 	assert.Len(t, err, 2) // fpcalc error and no checksum
 	assert.Equal(t, "Lady Gaga", m.AlbumArtist)
@@ -140,3 +141,27 @@ func TestParserStandaloneTrack(t *testing.T) {
 	assert.Empty(t, m.Genres)
 	assert.Equal(t, "Bad Romance", m.Name)
 }
+
+func TestExplainParser(t *testing.T) {
+	path := "/data/My Album Artist/My Album (2006)/1-02 My Track (My Artist).m4a"
+	c := getParserTestConfig()
+	e := ExplainMetadata(context.Background(), c, path)
+
+	assert.Equal(t, "My Artist", e.Metadata.Artist)
+	assert.Nil(t, e.Embedded)
+	assert.NotNil(t, e.Path)
+	assert.Equal(t, "My Artist", e.Path.Metadata.Artist)
+	assert.Empty(t, e.Path.Errors)
+	assert.Equal(t, c.TrackRegex[0], e.MatchedRegex)
+	assert.Len(t, e.Errors, 1)           // stat failure
+	assert.Len(t, e.ValidationErrors, 1) // no checksum
+
+	assert.Equal(t, FieldOrigin{Source: PathSource, RegexGroup: "Artist"}, e.Fields["Artist"])
+	assert.Equal(t, FieldOrigin{Source: PathSource, RegexGroup: "Track"}, e.Fields["Name"])
+	assert.Equal(t, FieldOrigin{Source: PathSource, RegexGroup: "Year"}, e.Fields["ReleaseDate"])
+	assert.Equal(t, FieldOrigin{Source: PathSource}, e.Fields["Type"])
+	// Set during sanitization
+	assert.Equal(t, FieldOrigin{Source: ScannerSource}, e.Fields["Release"])
+	assert.Equal(t, FieldOrigin{Source: ScannerSource}, e.Fields["Path"])
+	assert.NotContains(t, e.Fields, "Checksum")
+}
@@ -15,8 +15,40 @@ import (
 	"github.com/Arthi-chaud/Meelo/scanner/internal/illustration"
 )
 
+// Name of the metadata field each regex group is parsed into
+var regexGroupFields = map[string]string{
+	"AlbumArtist": "AlbumArtist",
+	"Artist":      "Artist",
+	"Release":     "Release",
+	"Album":       "Album",
+	"Year":        "ReleaseDate",
+	"DiscName":    "DiscName",
+	"Disc":        "DiscIndex",
+	"Index":       "Index",
+	"Track":       "Name",
+	"Genre":       "Genres",
+	"DiscogsId":   "DiscogsId",
+	"BPM":         "Bpm",
+
+	"MusicBrainzRecordingId":    "MusicBrainzRecordingId",
+	"MusicBrainzReleaseId":      "MusicBrainzReleaseId",
+	"MusicBrainzReleaseGroupId": "MusicBrainzReleaseGroupId",
+	"Isrc":                      "Isrc",
+	"Barcode":                   "Barcode",
+	"CatalogNumber":             "CatalogNumber",
+	"Label":                     "Label",
+}
+
 func parseMetadataFromPath(config config.UserSettings, filePath string) (internal.Metadata, []error) {
+	metadata, _, _, errors := parsePath(config, filePath)
+	return metadata, errors
+}
+
+// Also returns the regex that matched (empty if none did),
+// and the name of the regex group each field was read from
+func parsePath(config config.UserSettings, filePath string) (internal.Metadata, string, map[string]string, []error) {
 	var errors []error
+	groupNames := map[string]string{}
 	var matches []string
 	var regex *regexp.Regexp
 	for _, tregex := range config.TrackRegex {
@@ -30,7 +62,12 @@ func parseMetadataFromPath(config config.UserSettings, filePath string) (interna
 	}
 	if len(matches) == 0 {
 		errors = append(errors, fmt.Errorf("file did not match any regexes: '%s'", filePath))
-		return internal.Metadata{}, errors
+		return internal.Metadata{}, "", groupNames, errors
+	}
+	for i, groupName := range regex.SubexpNames() {
+		if field, isKnownGroup := regexGroupFields[groupName]; isKnownGroup && len(matches[i]) > 0 {
+			groupNames[field] = groupName
+		}
 	}
 
 	metadata, metadataErrors := getMetadataFromMatches(matches, regex)
@@ -45,7 +82,7 @@ func parseMetadataFromPath(config config.UserSettings, filePath string) (interna
 		metadata.IllustrationPath = illustrationPath
 		metadata.IllustrationLocation = internal.Inline
 	}
-	return metadata, errors
+	return metadata, regex.String(), groupNames, errors
 }
 
 func getMetadataFromMatches(matches []string, regex *regexp.Regexp) (internal.Metadata, []error) {
@@ -100,6 +137,27 @@ func getMetadataFromMatches(matches []string, regex *regexp.Regexp) (internal.Me
 	if index := regex.SubexpIndex("DiscogsId"); index != -1 {
 		metadata.DiscogsId = matches[index]
 	}
+	if index := regex.SubexpIndex("MusicBrainzRecordingId"); index != -1 {
+		metadata.MusicBrainzRecordingId = matches[index]
+	}
+	if index := regex.SubexpIndex("MusicBrainzReleaseId"); index != -1 {
+		metadata.MusicBrainzReleaseId = matches[index]
+	}
+	if index := regex.SubexpIndex("MusicBrainzReleaseGroupId"); index != -1 {
+		metadata.MusicBrainzReleaseGroupId = matches[index]
+	}
+	if index := regex.SubexpIndex("Isrc"); index != -1 {
+		metadata.Isrc = matches[index]
+	}
+	if index := regex.SubexpIndex("Barcode"); index != -1 {
+		metadata.Barcode = matches[index]
+	}
+	if index := regex.SubexpIndex("CatalogNumber"); index != -1 {
+		metadata.CatalogNumber = matches[index]
+	}
+	if index := regex.SubexpIndex("Label"); index != -1 {
+		metadata.Label = matches[index]
+	}
 	if index := regex.SubexpIndex("BPM"); index != -1 {
 		bpm, err := strconv.ParseFloat(matches[index], 64)
 		if err == nil {
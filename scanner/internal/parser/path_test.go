@@ -80,3 +80,17 @@ func TestPathBPM(t *testing.T) {
 	assert.Equal(t, "My Track", m.Name)
 	assert.Equal(t, float64(140), m.Bpm)
 }
+
+func TestPathMusicBrainzId(t *testing.T) {
+	config := getPathTestConfig()
+	config.TrackRegex = []string{
+		"^([\\/\\\\]+.*)*[\\/\\\\]+(?P<AlbumArtist>.+)[\\/\\\\]+(?P<Album>.+)\\s+\\[(?P<MusicBrainzReleaseId>[0-9a-f-]{36})\\][\\/\\\\]+(?P<Index>[0-9]+)\\s+(?P<Track>.*)\\..*$",
+	}
+	path := "/data/Artist/Album [4b1e3ad2-1bd8-4f68-8c38-3c2c4b8b5e0a]/01 Track.flac"
+	m, err := parseMetadataFromPath(config, path)
+
+	assert.Len(t, err, 0)
+	assert.Equal(t, "Album", m.Album)
+	assert.Equal(t, "4b1e3ad2-1bd8-4f68-8c38-3c2c4b8b5e0a", m.MusicBrainzReleaseId)
+	assert.Equal(t, "Track", m.Name)
+}
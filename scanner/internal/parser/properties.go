@@ -0,0 +1,128 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/tags"
+	"gopkg.in/vansante/go-ffprobe.v2"
+)
+
+// Names of the containers read natively, as ffmpeg names them
+var containerNames = map[tags.Container]string{
+	tags.MPEG: "mp3",
+	tags.FLAC: "flac",
+	tags.Ogg:  "ogg",
+	tags.MP4:  "mp4",
+}
+
+// ffmpeg lists the names of the formats sharing a demuxer (e.g. 'matroska,webm')
+var formatNames = map[string]string{
+	"mov,mp4,m4a,3gp,3g2,mj2": "mp4",
+	"matroska,webm":           "matroska",
+}
+
+var losslessCodecs = []string{"flac", "alac", "ape", "wavpack", "tta", "truehd", "mlp", "wmalossless", "shorten"}
+
+// Layouts ffmpeg uses by default for each number of channels
+var defaultChannelLayouts = map[int]string{
+	1: "mono",
+	2: "stereo",
+	3: "2.1",
+	4: "quad",
+	5: "5.0",
+	6: "5.1",
+	7: "6.1",
+	8: "7.1",
+}
+
+// Side data of Dolby Vision streams
+const doviSideDataType = "DOVI configuration record"
+
+func isLosslessCodec(codec string) bool {
+	return strings.HasPrefix(codec, "pcm_") || internal.Contains(losslessCodecs, codec)
+}
+
+// Sets the technical properties of a file read natively
+func parseFileProperties(metadata *internal.Metadata, file *tags.File) {
+	metadata.Container = containerNames[file.Container]
+	metadata.AudioCodec = file.Codec
+	metadata.Lossless = isLosslessCodec(file.Codec)
+	metadata.SampleRate = file.SampleRate
+	metadata.Channels = file.Channels
+	metadata.ChannelLayout = defaultChannelLayouts[file.Channels]
+	if metadata.Lossless {
+		metadata.BitDepth = file.BitDepth
+	}
+}
+
+// Sets the technical properties of the first audio stream, and of the first video stream for videos
+func parseProbedProperties(metadata *internal.Metadata, probeData ffprobe.ProbeData) {
+	if probeData.Format != nil {
+		metadata.Container = probeData.Format.FormatName
+		if name, found := formatNames[probeData.Format.FormatName]; found {
+			metadata.Container = name
+		}
+	}
+	if audioStream := probeData.FirstAudioStream(); audioStream != nil {
+		metadata.AudioCodec = audioStream.CodecName
+		metadata.Lossless = isLosslessCodec(audioStream.CodecName)
+		metadata.SampleRate, _ = strconv.Atoi(audioStream.SampleRate)
+		metadata.Channels = audioStream.Channels
+		metadata.ChannelLayout = audioStream.ChannelLayout
+		if len(metadata.ChannelLayout) == 0 {
+			metadata.ChannelLayout = defaultChannelLayouts[audioStream.Channels]
+		}
+		if metadata.Lossless {
+			// Not set for PCM streams
+			if bitDepth, err := strconv.Atoi(audioStream.BitsPerRawSample); err == nil {
+				metadata.BitDepth = bitDepth
+			} else {
+				metadata.BitDepth = audioStream.BitsPerSample
+			}
+		}
+	}
+	if videoStream := probeData.FirstVideoStream(); metadata.Type == internal.Video && videoStream != nil {
+		metadata.VideoCodec = videoStream.CodecName
+		metadata.Width = videoStream.Width
+		metadata.Height = videoStream.Height
+		metadata.FrameRate = parseFrameRate(videoStream.AvgFrameRate)
+		if metadata.FrameRate == 0 {
+			metadata.FrameRate = parseFrameRate(videoStream.RFrameRate)
+		}
+		metadata.HdrFormat = getHdrFormat(*videoStream)
+	}
+}
+
+// Frame rates are fractions (e.g. '30000/1001')
+// Returns 0 if the frame rate is unknown
+func parseFrameRate(value string) float64 {
+	numerator, denominator, found := strings.Cut(value, "/")
+	n, err := strconv.ParseFloat(numerator, 64)
+	if err != nil {
+		return 0
+	}
+	if !found {
+		return n
+	}
+	d, err := strconv.ParseFloat(denominator, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// Empty if the video is not HDR, or if its format is not known (e.g. HLG)
+func getHdrFormat(videoStream ffprobe.Stream) string {
+	if _, err := videoStream.SideDataList.FindSideData(doviSideDataType); err == nil {
+		return "Dolby Vision"
+	}
+	if _, err := videoStream.SideDataList.GetMasteringDisplayMetadata(); err == nil {
+		return "HDR10"
+	}
+	if _, err := videoStream.SideDataList.GetContentLightLevel(); err == nil {
+		return "HDR10"
+	}
+	return ""
+}
@@ -0,0 +1,59 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/vansante/go-ffprobe.v2"
+)
+
+func TestProbedVideoProperties(t *testing.T) {
+	probeData := ffprobe.ProbeData{
+		Format: &ffprobe.Format{FormatName: "matroska,webm"},
+		Streams: []*ffprobe.Stream{
+			{CodecType: "video", CodecName: "hevc", Width: 3840, Height: 2160, AvgFrameRate: "24000/1001", ColorSpace: "bt2020nc"},
+			{CodecType: "audio", CodecName: "truehd", SampleRate: "48000", Channels: 8, ChannelLayout: "7.1", BitsPerRawSample: "24"},
+		},
+	}
+	m := internal.Metadata{Type: internal.Video}
+	parseProbedProperties(&m, probeData)
+
+	assert.Equal(t, "matroska", m.Container)
+	assert.Equal(t, "truehd", m.AudioCodec)
+	assert.Equal(t, true, m.Lossless)
+	assert.Equal(t, 48000, m.SampleRate)
+	assert.Equal(t, 24, m.BitDepth)
+	assert.Equal(t, 8, m.Channels)
+	assert.Equal(t, "7.1", m.ChannelLayout)
+	assert.Equal(t, "hevc", m.VideoCodec)
+	assert.Equal(t, 3840, m.Width)
+	assert.Equal(t, 2160, m.Height)
+	assert.InDelta(t, 23.976, m.FrameRate, 0.001)
+	// A wide color space is not enough to tell that the video is HDR
+	assert.Equal(t, "", m.HdrFormat)
+}
+
+func TestProbedLossyAudioProperties(t *testing.T) {
+	probeData := ffprobe.ProbeData{
+		Format: &ffprobe.Format{FormatName: "mp3"},
+		Streams: []*ffprobe.Stream{
+			{CodecType: "audio", CodecName: "mp3", SampleRate: "44100", Channels: 2, BitsPerRawSample: "16"},
+		},
+	}
+	m := internal.Metadata{Type: internal.Audio}
+	parseProbedProperties(&m, probeData)
+
+	assert.Equal(t, "mp3", m.Container)
+	assert.Equal(t, false, m.Lossless)
+	assert.Equal(t, 0, m.BitDepth)
+	assert.Equal(t, "stereo", m.ChannelLayout)
+	assert.Empty(t, m.VideoCodec)
+}
+
+func TestParseFrameRate(t *testing.T) {
+	assert.Equal(t, 25.0, parseFrameRate("25/1"))
+	assert.Equal(t, 30.0, parseFrameRate("30"))
+	assert.Equal(t, 0.0, parseFrameRate("0/0"))
+	assert.Equal(t, 0.0, parseFrameRate(""))
+}
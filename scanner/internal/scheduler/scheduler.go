@@ -0,0 +1,103 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal/api"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/tasks"
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// Queues tasks periodically, as configured in the user settings
+type Scheduler struct {
+	cron      *cron.Cron
+	config    config.Config
+	worker    *tasks.Worker
+	schedules map[cron.EntryID]config.ScheduleSettings
+}
+
+type ScheduleInfo struct {
+	Settings config.ScheduleSettings
+	NextRun  time.Time
+	// Zero if the task has not run yet
+	PreviousRun time.Time
+}
+
+func NewScheduler(c config.Config, w *tasks.Worker) (*Scheduler, error) {
+	s := &Scheduler{
+		cron:      cron.New(),
+		config:    c,
+		worker:    w,
+		schedules: map[cron.EntryID]config.ScheduleSettings{},
+	}
+	for _, schedule := range c.UserSettings.Schedules {
+		id, err := s.cron.AddFunc(schedule.Cron, func() { s.run(schedule) })
+		if err != nil {
+			return nil, err
+		}
+		s.schedules[id] = schedule
+	}
+	return s, nil
+}
+
+func (s *Scheduler) Start() {
+	s.cron.Start()
+	if len(s.schedules) > 0 {
+		log.Info().Msgf("Scheduled %d recurring task(s)", len(s.schedules))
+	}
+}
+
+func (s *Scheduler) Stop() {
+	s.cron.Stop()
+}
+
+// Returns the schedules, sorted by next run time
+func (s *Scheduler) GetSchedules() []ScheduleInfo {
+	infos := []ScheduleInfo{}
+	// Entries are sorted by the cron lib
+	for _, entry := range s.cron.Entries() {
+		infos = append(infos, ScheduleInfo{
+			Settings:    s.schedules[entry.ID],
+			NextRun:     entry.Next,
+			PreviousRun: entry.Prev,
+		})
+	}
+	return infos
+}
+
+func (s *Scheduler) run(schedule config.ScheduleSettings) {
+	var libraries []api.Library
+	if len(schedule.Library) > 0 {
+		library, err := api.GetLibrary(context.Background(), s.config, schedule.Library)
+		if err != nil {
+			log.Error().Str("library", schedule.Library).Err(err).Msg("Could not get library for scheduled task")
+			return
+		}
+		libraries = []api.Library{library}
+	} else {
+		allLibraries, err := api.GetAllLibraries(context.Background(), s.config)
+		if err != nil {
+			log.Error().Err(err).Msg("Could not get libraries for scheduled task")
+			return
+		}
+		libraries = allLibraries
+	}
+	for _, library := range libraries {
+		task := s.worker.AddTask(newScheduledTask(schedule, library, s.config))
+		log.Info().Str("name", task.Name).Msg("Scheduled task added to queue")
+	}
+}
+
+func newScheduledTask(schedule config.ScheduleSettings, library api.Library, c config.Config) tasks.Task {
+	switch schedule.Task {
+	case config.ScheduledClean:
+		return tasks.NewLibraryCleanTask(library, c)
+	case config.ScheduledRefresh:
+		return tasks.NewMetadataRefreshTask(api.FileSelectorDto{Library: library.Slug}, schedule.Force, c)
+	default:
+		return tasks.NewLibraryScanTask(library, c)
+	}
+}
@@ -0,0 +1,58 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal/api"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/tasks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewScheduledTask(t *testing.T) {
+	library := api.Library{Id: 1, Slug: "music", Path: "Music"}
+	c := config.Config{}
+
+	scan := newScheduledTask(config.ScheduleSettings{Task: config.ScheduledScan}, library, c)
+	assert.Equal(t, "Scan library 'music'", scan.Name)
+	assert.Equal(t, tasks.BulkLane, scan.Lane)
+	assert.Equal(t, "music", scan.Library)
+
+	clean := newScheduledTask(config.ScheduleSettings{Task: config.ScheduledClean}, library, c)
+	assert.Equal(t, "Clean library 'music'", clean.Name)
+	assert.Equal(t, tasks.InteractiveLane, clean.Lane)
+
+	refresh := newScheduledTask(config.ScheduleSettings{Task: config.ScheduledRefresh, Force: true}, library, c)
+	assert.Equal(t, "Refresh metadata Library=music", refresh.Name)
+	assert.Equal(t, tasks.InteractiveLane, refresh.Lane)
+	assert.Contains(t, refresh.Key, "true")
+}
+
+func TestGetSchedules(t *testing.T) {
+	hourly := config.ScheduleSettings{Cron: "@every 1h", Task: config.ScheduledClean, Library: "music"}
+	everyMinute := config.ScheduleSettings{Cron: "@every 1m", Task: config.ScheduledScan}
+	c := config.Config{UserSettings: config.UserSettings{Schedules: []config.ScheduleSettings{hourly, everyMinute}}}
+	s, err := NewScheduler(c, tasks.NewWorker())
+	assert.Nil(t, err)
+	s.Start()
+	defer s.Stop()
+
+	schedules := s.GetSchedules()
+
+	assert.Len(t, schedules, 2)
+	// Sorted by next run
+	assert.Equal(t, everyMinute, schedules[0].Settings)
+	assert.Equal(t, hourly, schedules[1].Settings)
+	assert.True(t, schedules[0].NextRun.After(time.Now()))
+	assert.True(t, schedules[0].NextRun.Before(schedules[1].NextRun))
+	assert.True(t, schedules[0].PreviousRun.IsZero())
+}
+
+func TestNewSchedulerInvalidCron(t *testing.T) {
+	c := config.Config{UserSettings: config.UserSettings{Schedules: []config.ScheduleSettings{
+		{Cron: "not a cron", Task: config.ScheduledScan},
+	}}}
+	_, err := NewScheduler(c, tasks.NewWorker())
+	assert.NotNil(t, err)
+}
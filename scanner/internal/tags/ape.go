@@ -0,0 +1,108 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+)
+
+const apeFooterSize = 32
+
+// Key is the lower-cased item key
+var apeKeys = map[string]Key{
+	"title":        Title,
+	"artist":       Artist,
+	"album artist": AlbumArtist,
+	"albumartist":  AlbumArtist,
+	"artists":      Artists,
+	"albumartists": AlbumArtists,
+	"album":        Album,
+	"discsubtitle": DiscSubtitle,
+	"genre":        Genre,
+	"compilation":  Compilation,
+	"track":        TrackNumber,
+	"disc":         DiscNumber,
+	"year":         Date,
+	"originaldate": OriginalDate,
+	"lyrics":       Lyrics,
+	"bpm":          Bpm,
+
+	"isrc":                       Isrc,
+	"barcode":                    Barcode,
+	"catalognumber":              CatalogNumber,
+	"label":                      Label,
+	"musicbrainz_trackid":        MusicBrainzRecordingId,
+	"musicbrainz_releasetrackid": MusicBrainzTrackId,
+	"musicbrainz_albumid":        MusicBrainzReleaseId,
+	"musicbrainz_releasegroupid": MusicBrainzReleaseGroupId,
+	"musicbrainz_artistid":       MusicBrainzArtistId,
+	"musicbrainz_albumartistid":  MusicBrainzAlbumArtistId,
+
+	"replaygain_track_gain": ReplayGainTrackGain,
+	"replaygain_track_peak": ReplayGainTrackPeak,
+	"replaygain_album_gain": ReplayGainAlbumGain,
+	"replaygain_album_peak": ReplayGainAlbumPeak,
+}
+
+const (
+	apeHasHeaderFlag = 1 << 31
+	apeTextItem      = 0
+	apeBinaryItem    = 1
+)
+
+// Reads the APEv2 tag that ends at the offset, if any
+// Returns its size, including its header
+func (f *File) readApe(r *io.SectionReader, end int64) int64 {
+	if end < apeFooterSize {
+		return 0
+	}
+	footer := make([]byte, apeFooterSize)
+	if _, err := r.ReadAt(footer, end-apeFooterSize); err != nil || !bytes.HasPrefix(footer, []byte("APETAGEX")) {
+		return 0
+	}
+	// Includes the footer, but not the header
+	size := int64(binary.LittleEndian.Uint32(footer[12:16]))
+	count := int(binary.LittleEndian.Uint32(footer[16:20]))
+	flags := binary.LittleEndian.Uint32(footer[20:24])
+	if size < apeFooterSize || size > end {
+		return 0
+	}
+	data, err := readBytes(r, end-size, size-apeFooterSize)
+	if err != nil {
+		return 0
+	}
+	for range count {
+		// Value size, flags, then the null-terminated key
+		if len(data) < 8 {
+			break
+		}
+		valueSize := int(binary.LittleEndian.Uint32(data))
+		itemType := binary.LittleEndian.Uint32(data[4:8]) >> 1 & 0x3
+		key, rest := cutNull(data[8:])
+		if valueSize > len(rest) {
+			break
+		}
+		value := rest[:valueSize]
+		data = rest[valueSize:]
+		switch itemType {
+		case apeTextItem:
+			// Values are separated by null bytes
+			f.addValues(APEv2, string(key), apeKeys[strings.ToLower(string(key))], strings.Split(string(value), "\x00")...)
+		case apeBinaryItem:
+			if strings.HasPrefix(strings.ToLower(string(key)), "cover art") {
+				// File name, then the content
+				_, content := cutNull(value)
+				pictureType := 0
+				if strings.Contains(strings.ToLower(string(key)), "front") {
+					pictureType = 3
+				}
+				f.Pictures = append(f.Pictures, Picture{Type: pictureType, Data: content})
+			}
+		}
+	}
+	if flags&apeHasHeaderFlag != 0 {
+		size += apeFooterSize
+	}
+	return size
+}
@@ -0,0 +1,68 @@
+package tags
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+const (
+	flacStreamInfoBlock    = 0
+	flacVorbisCommentBlock = 4
+	flacPictureBlock       = 6
+)
+
+// Reads the metadata blocks of a FLAC stream starting at the offset
+func readFlac(r *io.SectionReader, offset int64) (*File, error) {
+	f := &File{Container: FLAC, Codec: "flac", StreamCount: 1}
+	// Skips the 'fLaC' marker
+	offset += 4
+	for {
+		header := make([]byte, 4)
+		if _, err := r.ReadAt(header, offset); err != nil {
+			return nil, errMalformed
+		}
+		isLast := header[0]&0x80 != 0
+		blockType := header[0] & 0x7f
+		length := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		offset += 4
+		switch blockType {
+		case flacStreamInfoBlock, flacVorbisCommentBlock, flacPictureBlock:
+			block, err := readBytes(r, offset, length)
+			if err != nil {
+				return nil, err
+			}
+			switch blockType {
+			case flacStreamInfoBlock:
+				if err := f.readFlacStreamInfo(block); err != nil {
+					return nil, err
+				}
+			case flacVorbisCommentBlock:
+				if err := f.readVorbisComment(block); err != nil {
+					return nil, err
+				}
+			case flacPictureBlock:
+				f.readPictureBlock(block)
+			}
+		}
+		offset += length
+		if isLast {
+			return f, nil
+		}
+	}
+}
+
+func (f *File) readFlacStreamInfo(block []byte) error {
+	if len(block) < 18 {
+		return errMalformed
+	}
+	// 20 bits of sample rate, 3 of channels, 5 of bits per sample and 36 of sample count
+	packed := binary.BigEndian.Uint64(block[10:18])
+	f.SampleRate = int(packed >> 44)
+	f.Channels = int(packed>>41&0x7) + 1
+	f.BitDepth = int(packed>>36&0x1f) + 1
+	sampleCount := packed & (1<<36 - 1)
+	if f.SampleRate > 0 {
+		f.Duration = secondsToDuration(float64(sampleCount) / float64(f.SampleRate))
+	}
+	return nil
+}
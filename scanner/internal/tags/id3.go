@@ -0,0 +1,388 @@
+package tags
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const id3v2HeaderSize = 10
+
+const id3v1Size = 128
+
+var id3v2Keys = map[string]Key{
+	"TIT2": Title,
+	"TPE1": Artist,
+	"TPE2": AlbumArtist,
+	"TALB": Album,
+	"TSST": DiscSubtitle,
+	"TCON": Genre,
+	"TCMP": Compilation,
+	"TRCK": TrackNumber,
+	"TPOS": DiscNumber,
+	"TDRC": Date,
+	"TYER": Date,
+	"TDOR": OriginalDate,
+	"TORY": OriginalDate,
+	"USLT": Lyrics,
+	"TBPM": Bpm,
+	"TSRC": Isrc,
+	"TPUB": Label,
+}
+
+// Key is the upper-cased description of the TXXX frame
+var id3v2UserKeys = map[string]Key{
+	"ARTISTS":      Artists,
+	"ALBUMARTISTS": AlbumArtists,
+
+	"BARCODE":                      Barcode,
+	"CATALOGNUMBER":                CatalogNumber,
+	"MUSICBRAINZ RELEASE TRACK ID": MusicBrainzTrackId,
+	"MUSICBRAINZ ALBUM ID":         MusicBrainzReleaseId,
+	"MUSICBRAINZ RELEASE GROUP ID": MusicBrainzReleaseGroupId,
+	"MUSICBRAINZ ARTIST ID":        MusicBrainzArtistId,
+	"MUSICBRAINZ ALBUM ARTIST ID":  MusicBrainzAlbumArtistId,
+
+	"REPLAYGAIN_TRACK_GAIN": ReplayGainTrackGain,
+	"REPLAYGAIN_TRACK_PEAK": ReplayGainTrackPeak,
+	"REPLAYGAIN_ALBUM_GAIN": ReplayGainAlbumGain,
+	"REPLAYGAIN_ALBUM_PEAK": ReplayGainAlbumPeak,
+}
+
+// Owner of the UFID frame holding the MusicBrainz recording ID
+const musicBrainzUfidOwner = "http://musicbrainz.org"
+
+// ID3v2.2 frames have 3-character identifiers. They are mapped to their ID3v2.3 equivalent
+var id3v22FrameIds = map[string]string{
+	"TT2": "TIT2",
+	"TP1": "TPE1",
+	"TP2": "TPE2",
+	"TAL": "TALB",
+	"TCO": "TCON",
+	"TCP": "TCMP",
+	"TRK": "TRCK",
+	"TPA": "TPOS",
+	"TYE": "TYER",
+	"TOR": "TORY",
+	"ULT": "USLT",
+	"TBP": "TBPM",
+	"TRC": "TSRC",
+	"TPB": "TPUB",
+	"TXX": "TXXX",
+	"UFI": "UFID",
+	"PIC": "APIC",
+	"COM": "COMM",
+}
+
+// Text encodings of ID3v2 frames
+const (
+	id3Latin1  = 0
+	id3Utf16   = 1
+	id3Utf16BE = 2
+	id3Utf8    = 3
+)
+
+// Returns the size of the ID3v2 tag at the offset, including its header and footer
+// Returns zero if there is none
+func getId3v2Size(r io.ReaderAt, offset int64) int64 {
+	header := make([]byte, id3v2HeaderSize)
+	if _, err := r.ReadAt(header, offset); err != nil || !bytes.HasPrefix(header, []byte("ID3")) {
+		return 0
+	}
+	size := id3v2HeaderSize + int64(decodeSyncsafe(header[6:10]))
+	if header[5]&0x10 != 0 {
+		// Footer
+		size += id3v2HeaderSize
+	}
+	return size
+}
+
+// Reads the ID3v2 tag at the offset
+func (f *File) readId3v2(r *io.SectionReader, offset int64) error {
+	header := make([]byte, id3v2HeaderSize)
+	if _, err := r.ReadAt(header, offset); err != nil {
+		return errMalformed
+	}
+	version := header[3]
+	flags := header[5]
+	data, err := readBytes(r, offset+id3v2HeaderSize, int64(decodeSyncsafe(header[6:10])))
+	if err != nil {
+		return err
+	}
+	tag := map[byte]TagFormat{2: ID3v22, 3: ID3v23, 4: ID3v24}[version]
+	if len(tag) == 0 {
+		// Unknown version, that we cannot read
+		return nil
+	}
+	if flags&0x80 != 0 && version < 4 {
+		// Unsynchronisation of the whole tag
+		data = removeUnsynchronisation(data)
+	}
+	if flags&0x40 != 0 && version >= 3 && len(data) >= 4 {
+		// Extended header. Its size includes itself in ID3v2.4 only
+		size := int(binary.BigEndian.Uint32(data))
+		if version == 4 {
+			size = decodeSyncsafe(data[:4])
+		} else {
+			size += 4
+		}
+		if size > len(data) {
+			return errMalformed
+		}
+		data = data[size:]
+	}
+	idSize, headerSize := 4, 10
+	if version == 2 {
+		idSize, headerSize = 3, 6
+	}
+	for len(data) >= headerSize && data[0] != 0 {
+		id := string(data[:idSize])
+		var size int
+		var frameFlags uint16
+		switch version {
+		case 2:
+			size = int(data[3])<<16 | int(data[4])<<8 | int(data[5])
+			id = id3v22FrameIds[id]
+		case 3:
+			size = int(binary.BigEndian.Uint32(data[4:8]))
+			frameFlags = binary.BigEndian.Uint16(data[8:10])
+		case 4:
+			size = decodeSyncsafe(data[4:8])
+			frameFlags = binary.BigEndian.Uint16(data[8:10])
+		}
+		if size > len(data)-headerSize {
+			return errMalformed
+		}
+		content := data[headerSize : headerSize+size]
+		data = data[headerSize+size:]
+		if len(id) == 0 {
+			// ID3v2.2 frame that we do not read
+			continue
+		}
+		content, ok := decodeId3FrameContent(content, version, frameFlags, flags&0x80 != 0)
+		if ok {
+			f.readId3v2Frame(tag, id, content)
+		}
+	}
+	return nil
+}
+
+// Undoes the compression and the unsynchronisation of the frame
+// Returns false for encrypted frames
+func decodeId3FrameContent(content []byte, version byte, flags uint16, isTagUnsynchronised bool) ([]byte, bool) {
+	var isCompressed, isEncrypted, isUnsynchronised, hasGroup, hasDataLength bool
+	switch version {
+	case 3:
+		isCompressed = flags&0x0080 != 0
+		isEncrypted = flags&0x0040 != 0
+		hasGroup = flags&0x0020 != 0
+		// The decompressed size comes first
+		hasDataLength = isCompressed
+	case 4:
+		hasGroup = flags&0x0040 != 0
+		isCompressed = flags&0x0008 != 0
+		isEncrypted = flags&0x0004 != 0
+		isUnsynchronised = flags&0x0002 != 0 || isTagUnsynchronised
+		hasDataLength = flags&0x0001 != 0
+	}
+	if isEncrypted {
+		return nil, false
+	}
+	if hasGroup && len(content) > 0 {
+		content = content[1:]
+	}
+	if hasDataLength && len(content) >= 4 {
+		content = content[4:]
+	}
+	if isUnsynchronised {
+		content = removeUnsynchronisation(content)
+	}
+	if isCompressed {
+		reader, err := zlib.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, false
+		}
+		defer reader.Close()
+		if content, err = io.ReadAll(reader); err != nil {
+			return nil, false
+		}
+	}
+	return content, true
+}
+
+func (f *File) readId3v2Frame(tag TagFormat, id string, content []byte) {
+	if len(content) == 0 {
+		return
+	}
+	switch {
+	case id == "TXXX":
+		// Description, then value(s)
+		values := decodeId3Strings(content[0], content[1:])
+		if len(values) >= 2 {
+			f.addValues(tag, "TXXX:"+values[0], id3v2UserKeys[strings.ToUpper(values[0])], values[1:]...)
+		}
+	case id[0] == 'T':
+		values := decodeId3Strings(content[0], content[1:])
+		if id == "TCON" {
+			values = resolveId3Genres(values)
+		}
+		f.addValues(tag, id, id3v2Keys[id], values...)
+	case id == "USLT" || id == "COMM":
+		// Language, description, then text
+		if len(content) < 4 {
+			return
+		}
+		values := decodeId3Strings(content[0], content[4:])
+		if len(values) >= 2 {
+			f.addValues(tag, id, id3v2Keys[id], strings.Join(values[1:], "\n"))
+		}
+	case id == "UFID":
+		owner, identifier := cutNull(content)
+		var key Key
+		if string(owner) == musicBrainzUfidOwner {
+			key = MusicBrainzRecordingId
+		}
+		f.addValues(tag, "UFID:"+string(owner), key, string(identifier))
+	case id == "APIC":
+		f.readId3v2Picture(tag, content)
+	}
+}
+
+func (f *File) readId3v2Picture(tag TagFormat, content []byte) {
+	encoding := content[0]
+	content = content[1:]
+	var mimeType string
+	if tag == ID3v22 {
+		// Image format, e.g. 'JPG'
+		if len(content) < 3 {
+			return
+		}
+		mimeType = "image/" + strings.ToLower(string(content[:3]))
+		content = content[3:]
+	} else {
+		rawMimeType, rest := cutNull(content)
+		mimeType = string(rawMimeType)
+		content = rest
+	}
+	if len(content) < 1 {
+		return
+	}
+	pictureType := int(content[0])
+	_, data := cutId3String(encoding, content[1:])
+	f.Pictures = append(f.Pictures, Picture{Type: pictureType, MimeType: mimeType, Data: data})
+}
+
+// Splits the data on the first terminator of the encoding
+func cutId3String(encoding byte, data []byte) ([]byte, []byte) {
+	if encoding != id3Utf16 && encoding != id3Utf16BE {
+		return cutNull(data)
+	}
+	// The terminator is two null bytes, aligned
+	for i := 0; i+1 < len(data); i += 2 {
+		if data[i] == 0 && data[i+1] == 0 {
+			return data[:i], data[i+2:]
+		}
+	}
+	return data, nil
+}
+
+// Decodes the null-separated strings of a text frame
+func decodeId3Strings(encoding byte, data []byte) []string {
+	values := []string{}
+	for len(data) > 0 {
+		var value []byte
+		value, data = cutId3String(encoding, data)
+		values = append(values, decodeId3String(encoding, value))
+	}
+	return values
+}
+
+func decodeId3String(encoding byte, data []byte) string {
+	switch encoding {
+	case id3Utf16:
+		var order binary.ByteOrder = binary.LittleEndian
+		if len(data) >= 2 && data[0] == 0xfe && data[1] == 0xff {
+			order = binary.BigEndian
+		}
+		if len(data) >= 2 && (data[0] == 0xfe || data[0] == 0xff) {
+			data = data[2:]
+		}
+		return decodeUtf16(data, order)
+	case id3Utf16BE:
+		return decodeUtf16(data, binary.BigEndian)
+	case id3Utf8:
+		return string(data)
+	}
+	return decodeLatin1(data)
+}
+
+// e.g. '(17)', '17' or '(17)Rock'
+var id3GenreReference = regexp.MustCompile(`^\((\d+)\)(.*)$`)
+
+// Replaces ID3v1 genre references with the name of the genre
+func resolveId3Genres(values []string) []string {
+	genres := []string{}
+	for _, value := range values {
+		if index, err := strconv.Atoi(value); err == nil {
+			value = getId3v1Genre(index)
+		} else if match := id3GenreReference.FindStringSubmatch(value); match != nil {
+			index, _ := strconv.Atoi(match[1])
+			value = match[2]
+			if len(value) == 0 {
+				value = getId3v1Genre(index)
+			}
+		} else if value == "(RX)" || value == "RX" {
+			value = "Remix"
+		} else if value == "(CR)" || value == "CR" {
+			value = "Cover"
+		}
+		if len(value) > 0 {
+			genres = append(genres, value)
+		}
+	}
+	return genres
+}
+
+func decodeSyncsafe(data []byte) int {
+	return int(data[0]&0x7f)<<21 | int(data[1]&0x7f)<<14 | int(data[2]&0x7f)<<7 | int(data[3]&0x7f)
+}
+
+// Replaces 0xFF 0x00 with 0xFF
+func removeUnsynchronisation(data []byte) []byte {
+	return bytes.ReplaceAll(data, []byte{0xff, 0x00}, []byte{0xff})
+}
+
+// Reads the ID3v1 tag at the end of the file, if any
+// Returns its size
+func (f *File) readId3v1(r *io.SectionReader) int64 {
+	if r.Size() < id3v1Size {
+		return 0
+	}
+	data := make([]byte, id3v1Size)
+	if _, err := r.ReadAt(data, r.Size()-id3v1Size); err != nil || !bytes.HasPrefix(data, []byte("TAG")) {
+		return 0
+	}
+	field := func(start int, end int) string {
+		value, _ := cutNull(data[start:end])
+		return strings.TrimSpace(decodeLatin1(value))
+	}
+	add := func(id string, value string) {
+		if len(value) > 0 {
+			f.addValues(ID3v1, id, id3v2Keys[id], value)
+		}
+	}
+	add("TIT2", field(3, 33))
+	add("TPE1", field(33, 63))
+	add("TALB", field(63, 93))
+	add("TYER", field(93, 97))
+	// ID3v1.1 stores the track number at the end of the comment
+	if data[125] == 0 && data[126] != 0 {
+		add("TRCK", strconv.Itoa(int(data[126])))
+	}
+	add("TCON", getId3v1Genre(int(data[127])))
+	return id3v1Size
+}
@@ -0,0 +1,318 @@
+package tags
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode/utf16"
+)
+
+// The moov atom is read in memory. Larger ones are considered malformed
+const maxMp4MoovSize = 64 * 1024 * 1024
+
+var mp4Keys = map[string]Key{
+	"©nam": Title,
+	"©ART": Artist,
+	"aART": AlbumArtist,
+	"©alb": Album,
+	"©gen": Genre,
+	"gnre": Genre,
+	"cpil": Compilation,
+	"trkn": TrackNumber,
+	"disk": DiscNumber,
+	"©day": Date,
+	"©lyr": Lyrics,
+	"tmpo": Bpm,
+
+	"----:com.apple.iTunes:ARTISTS":      Artists,
+	"----:com.apple.iTunes:ALBUMARTISTS": AlbumArtists,
+
+	"----:com.apple.iTunes:ISRC":                         Isrc,
+	"----:com.apple.iTunes:BARCODE":                      Barcode,
+	"----:com.apple.iTunes:CATALOGNUMBER":                CatalogNumber,
+	"----:com.apple.iTunes:LABEL":                        Label,
+	"----:com.apple.iTunes:MusicBrainz Track Id":         MusicBrainzRecordingId,
+	"----:com.apple.iTunes:MusicBrainz Release Track Id": MusicBrainzTrackId,
+	"----:com.apple.iTunes:MusicBrainz Album Id":         MusicBrainzReleaseId,
+	"----:com.apple.iTunes:MusicBrainz Release Group Id": MusicBrainzReleaseGroupId,
+	"----:com.apple.iTunes:MusicBrainz Artist Id":        MusicBrainzArtistId,
+	"----:com.apple.iTunes:MusicBrainz Album Artist Id":  MusicBrainzAlbumArtistId,
+
+	// Both cases are used by taggers
+	"----:com.apple.iTunes:replaygain_track_gain": ReplayGainTrackGain,
+	"----:com.apple.iTunes:replaygain_track_peak": ReplayGainTrackPeak,
+	"----:com.apple.iTunes:replaygain_album_gain": ReplayGainAlbumGain,
+	"----:com.apple.iTunes:replaygain_album_peak": ReplayGainAlbumPeak,
+	"----:com.apple.iTunes:REPLAYGAIN_TRACK_GAIN": ReplayGainTrackGain,
+	"----:com.apple.iTunes:REPLAYGAIN_TRACK_PEAK": ReplayGainTrackPeak,
+	"----:com.apple.iTunes:REPLAYGAIN_ALBUM_GAIN": ReplayGainAlbumGain,
+	"----:com.apple.iTunes:REPLAYGAIN_ALBUM_PEAK": ReplayGainAlbumPeak,
+}
+
+// Types of the values of 'data' atoms
+const (
+	mp4Implicit = 0
+	mp4Utf8     = 1
+	mp4Utf16    = 2
+	mp4Jpeg     = 13
+	mp4Png      = 14
+	mp4Int      = 21
+	mp4Uint     = 22
+	mp4Bmp      = 27
+)
+
+type mp4Atom struct {
+	name string
+	data []byte
+}
+
+// Splits the data in atoms. Stops at the first malformed one
+func readMp4Atoms(data []byte) []mp4Atom {
+	atoms := []mp4Atom{}
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		headerSize := uint64(8)
+		if size == 1 && len(data) >= 16 {
+			size = binary.BigEndian.Uint64(data[8:])
+			headerSize = 16
+		} else if size == 0 {
+			size = uint64(len(data))
+		}
+		if size < headerSize || size > uint64(len(data)) {
+			break
+		}
+		atoms = append(atoms, mp4Atom{name: decodeLatin1(data[4:8]), data: data[headerSize:size]})
+		data = data[size:]
+	}
+	return atoms
+}
+
+func findMp4Atom(atoms []mp4Atom, path ...string) (mp4Atom, bool) {
+	for _, atom := range atoms {
+		if atom.name != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return atom, true
+		}
+		return findMp4Atom(readMp4Atoms(atom.data), path[1:]...)
+	}
+	return mp4Atom{}, false
+}
+
+// Returns the moov atom, reading the top-level atoms from the file
+func readMp4Moov(r *io.SectionReader) ([]byte, error) {
+	for offset := int64(0); offset+8 <= r.Size(); {
+		header := make([]byte, 16)
+		n, _ := r.ReadAt(header, offset)
+		if n < 8 {
+			break
+		}
+		size := int64(binary.BigEndian.Uint32(header))
+		headerSize := int64(8)
+		if size == 1 && n == 16 {
+			size = int64(binary.BigEndian.Uint64(header[8:]))
+			headerSize = 16
+		} else if size == 0 {
+			size = r.Size() - offset
+		}
+		if size < headerSize {
+			break
+		}
+		if string(header[4:8]) == "moov" {
+			if size > maxMp4MoovSize {
+				return nil, errMalformed
+			}
+			return readBytes(r, offset+headerSize, size-headerSize)
+		}
+		offset += size
+	}
+	return nil, errMalformed
+}
+
+// Video files are not supported
+func readMp4(r *io.SectionReader) (*File, error) {
+	moov, err := readMp4Moov(r)
+	if err != nil {
+		return nil, err
+	}
+	atoms := readMp4Atoms(moov)
+	f := &File{Container: MP4}
+	for _, atom := range atoms {
+		if atom.name != "trak" {
+			continue
+		}
+		trak := readMp4Atoms(atom.data)
+		handler, found := findMp4Atom(trak, "mdia", "hdlr")
+		if !found || len(handler.data) < 12 {
+			continue
+		}
+		switch string(handler.data[8:12]) {
+		case "vide":
+			return nil, ErrUnsupportedFormat
+		case "soun":
+			f.StreamCount++
+			if f.StreamCount == 1 {
+				f.readMp4SampleDescription(trak)
+			}
+		}
+	}
+	if f.StreamCount == 0 {
+		return nil, ErrUnsupportedFormat
+	}
+	if header, found := findMp4Atom(atoms, "mvhd"); found {
+		f.readMp4MovieHeader(header.data)
+	}
+	if meta, found := findMp4Atom(atoms, "udta", "meta"); found {
+		// The meta atom has version and flags, except in some QuickTime files
+		data := meta.data
+		if len(data) >= 8 && string(data[4:8]) != "hdlr" {
+			data = data[4:]
+		}
+		if list, found := findMp4Atom(readMp4Atoms(data), "ilst"); found {
+			for _, item := range readMp4Atoms(list.data) {
+				f.readMp4Item(item)
+			}
+		}
+	}
+	return f, nil
+}
+
+func (f *File) readMp4MovieHeader(data []byte) {
+	var timescale, duration uint64
+	if len(data) >= 32 && data[0] == 1 {
+		timescale = uint64(binary.BigEndian.Uint32(data[20:24]))
+		duration = binary.BigEndian.Uint64(data[24:32])
+	} else if len(data) >= 20 {
+		timescale = uint64(binary.BigEndian.Uint32(data[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(data[16:20]))
+	}
+	if timescale > 0 {
+		f.Duration = secondsToDuration(float64(duration) / float64(timescale))
+	}
+}
+
+// Reads the codec and the audio properties from the first entry of the sample description
+func (f *File) readMp4SampleDescription(trak []mp4Atom) {
+	description, found := findMp4Atom(trak, "mdia", "minf", "stbl", "stsd")
+	// Version, flags and entry count
+	if !found || len(description.data) < 8 {
+		return
+	}
+	entries := readMp4Atoms(description.data[8:])
+	if len(entries) == 0 {
+		return
+	}
+	entry := entries[0]
+	switch entry.name {
+	case "mp4a":
+		f.Codec = "aac"
+	case "alac":
+		f.Codec = "alac"
+	default:
+		f.Codec = entry.name
+	}
+	// Reserved, data reference index, version, revision and vendor come first
+	if len(entry.data) >= 28 {
+		f.Channels = int(binary.BigEndian.Uint16(entry.data[16:18]))
+		if entry.name == "alac" {
+			f.BitDepth = int(binary.BigEndian.Uint16(entry.data[18:20]))
+		}
+		// 16.16 fixed-point number
+		f.SampleRate = int(binary.BigEndian.Uint16(entry.data[24:26]))
+	}
+}
+
+func (f *File) readMp4Item(item mp4Atom) {
+	id := item.name
+	children := readMp4Atoms(item.data)
+	if id == "----" {
+		// Freeform item, identified by its mean and name atoms
+		mean, _ := findMp4Atom(children, "mean")
+		name, _ := findMp4Atom(children, "name")
+		if len(mean.data) < 4 || len(name.data) < 4 {
+			return
+		}
+		id = fmt.Sprintf("----:%s:%s", mean.data[4:], name.data[4:])
+	}
+	for _, child := range children {
+		// Type, on 4 bytes, and locale, on 4 bytes
+		if child.name != "data" || len(child.data) < 8 {
+			continue
+		}
+		dataType := binary.BigEndian.Uint32(child.data) & 0xffffff
+		value := child.data[8:]
+		switch {
+		case id == "covr":
+			mimeType := "image/jpeg"
+			if dataType == mp4Png {
+				mimeType = "image/png"
+			} else if dataType == mp4Bmp {
+				mimeType = "image/bmp"
+			}
+			// The type of the picture is not stored
+			f.Pictures = append(f.Pictures, Picture{Type: 3, MimeType: mimeType, Data: value})
+		case (id == "trkn" || id == "disk") && len(value) >= 6:
+			number := binary.BigEndian.Uint16(value[2:4])
+			total := binary.BigEndian.Uint16(value[4:6])
+			text := strconv.Itoa(int(number))
+			if total > 0 {
+				text = fmt.Sprintf("%d/%d", number, total)
+			}
+			f.addValues(MP4Atoms, id, mp4Keys[id], text)
+		case id == "gnre" && len(value) >= 2:
+			// ID3v1 genre, plus one
+			if genre := getId3v1Genre(int(binary.BigEndian.Uint16(value)) - 1); len(genre) > 0 {
+				f.addValues(MP4Atoms, id, mp4Keys[id], genre)
+			}
+		case dataType == mp4Utf8:
+			f.addValues(MP4Atoms, id, mp4Keys[id], string(value))
+		case dataType == mp4Utf16:
+			f.addValues(MP4Atoms, id, mp4Keys[id], decodeUtf16(value, binary.BigEndian))
+		case dataType == mp4Int || dataType == mp4Uint || dataType == mp4Implicit:
+			if number, ok := decodeMp4Int(value, dataType == mp4Int); ok {
+				f.addValues(MP4Atoms, id, mp4Keys[id], strconv.FormatInt(number, 10))
+			}
+		}
+	}
+}
+
+func decodeMp4Int(value []byte, isSigned bool) (int64, bool) {
+	switch len(value) {
+	case 1:
+		if isSigned {
+			return int64(int8(value[0])), true
+		}
+		return int64(value[0]), true
+	case 2:
+		if isSigned {
+			return int64(int16(binary.BigEndian.Uint16(value))), true
+		}
+		return int64(binary.BigEndian.Uint16(value)), true
+	case 4:
+		if isSigned {
+			return int64(int32(binary.BigEndian.Uint32(value))), true
+		}
+		return int64(binary.BigEndian.Uint32(value)), true
+	case 8:
+		return int64(binary.BigEndian.Uint64(value)), true
+	}
+	return 0, false
+}
+
+func decodeUtf16(data []byte, order binary.ByteOrder) string {
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		units = append(units, order.Uint16(data[i:]))
+	}
+	return string(utf16.Decode(units))
+}
+
+func decodeLatin1(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
@@ -0,0 +1,201 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Garbage between the ID3v2 tag and the first frame is skipped, up to that size
+const mpegMaxSyncSearchSize = 64 * 1024
+
+// In kbps, by version (MPEG-1 first) and layer (layer I first). Index 0 is for free-format streams
+var mpegBitrates = [2][3][15]int{
+	{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	},
+	{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	},
+}
+
+// By version: MPEG-1, MPEG-2 and MPEG-2.5
+var mpegSampleRates = [3][3]int{
+	{44100, 48000, 32000},
+	{22050, 24000, 16000},
+	{11025, 12000, 8000},
+}
+
+type mpegFrameHeader struct {
+	// 0 for MPEG-1, 1 for MPEG-2 and 2 for MPEG-2.5
+	version    int
+	layer      int
+	bitrate    int
+	sampleRate int
+	channels   int
+	// Including the header
+	size            int
+	samplesPerFrame int
+}
+
+func parseMpegFrameHeader(data []byte) (mpegFrameHeader, bool) {
+	if len(data) < 4 || data[0] != 0xff || data[1]&0xe0 != 0xe0 {
+		return mpegFrameHeader{}, false
+	}
+	h := mpegFrameHeader{}
+	switch data[1] >> 3 & 0x3 {
+	case 0:
+		h.version = 2
+	case 2:
+		h.version = 1
+	case 3:
+		h.version = 0
+	default:
+		return mpegFrameHeader{}, false
+	}
+	h.layer = 4 - int(data[1]>>1&0x3)
+	bitrateIndex := int(data[2] >> 4)
+	sampleRateIndex := int(data[2] >> 2 & 0x3)
+	if h.layer == 4 || bitrateIndex == 0 || bitrateIndex == 15 || sampleRateIndex == 3 {
+		return mpegFrameHeader{}, false
+	}
+	h.bitrate = mpegBitrates[min(h.version, 1)][h.layer-1][bitrateIndex]
+	h.sampleRate = mpegSampleRates[h.version][sampleRateIndex]
+	padding := int(data[2] >> 1 & 0x1)
+	h.channels = 2
+	if data[3]>>6 == 3 {
+		h.channels = 1
+	}
+	switch {
+	case h.layer == 1:
+		h.samplesPerFrame = 384
+		h.size = (12*h.bitrate*1000/h.sampleRate + padding) * 4
+	case h.layer == 3 && h.version > 0:
+		h.samplesPerFrame = 576
+		h.size = 72*h.bitrate*1000/h.sampleRate + padding
+	default:
+		h.samplesPerFrame = 1152
+		h.size = 144*h.bitrate*1000/h.sampleRate + padding
+	}
+	return h, true
+}
+
+// Returns the offset of the first frame, checking that it is followed by another one
+// If searchSize is zero, the frame has to be at the offset
+func findMpegFrame(r io.ReaderAt, offset int64, searchSize int) (int64, mpegFrameHeader, bool) {
+	data := make([]byte, searchSize+4)
+	n, _ := r.ReadAt(data, offset)
+	data = data[:n]
+	for i := 0; i+4 <= len(data); i++ {
+		header, ok := parseMpegFrameHeader(data[i:])
+		if !ok {
+			continue
+		}
+		next := make([]byte, 4)
+		if _, err := r.ReadAt(next, offset+int64(i+header.size)); err == nil {
+			nextHeader, ok := parseMpegFrameHeader(next)
+			if ok && nextHeader.version == header.version && nextHeader.layer == header.layer && nextHeader.sampleRate == header.sampleRate {
+				return offset + int64(i), header, true
+			}
+		}
+		if searchSize == 0 {
+			break
+		}
+	}
+	return 0, mpegFrameHeader{}, false
+}
+
+// Reads the number of frames and of bytes from the Xing or VBRI header of the first frame
+// Returns zero if there is none
+func readMpegVbrHeader(frame []byte, header mpegFrameHeader) (int, int) {
+	// The Xing header comes after the side information
+	xingOffset := 36
+	if header.version == 0 && header.channels == 1 {
+		xingOffset = 21
+	} else if header.version > 0 && header.channels == 1 {
+		xingOffset = 13
+	} else if header.version > 0 {
+		xingOffset = 21
+	}
+	if len(frame) >= xingOffset+16 {
+		xing := frame[xingOffset:]
+		if bytes.HasPrefix(xing, []byte("Xing")) || bytes.HasPrefix(xing, []byte("Info")) {
+			flags := binary.BigEndian.Uint32(xing[4:8])
+			frameCount, byteCount := 0, 0
+			position := 8
+			if flags&0x1 != 0 {
+				frameCount = int(binary.BigEndian.Uint32(xing[position:]))
+				position += 4
+			}
+			if flags&0x2 != 0 {
+				byteCount = int(binary.BigEndian.Uint32(xing[position:]))
+			}
+			return frameCount, byteCount
+		}
+	}
+	if len(frame) >= 36+18 && bytes.HasPrefix(frame[36:], []byte("VBRI")) {
+		vbri := frame[36:]
+		return int(binary.BigEndian.Uint32(vbri[14:18])), int(binary.BigEndian.Uint32(vbri[10:14]))
+	}
+	return 0, 0
+}
+
+// Reads an MPEG audio file, with its ID3v2, APEv2 and ID3v1 tags
+// FLAC files that start with an ID3v2 tag are handled too
+func readMpeg(r *io.SectionReader) (*File, error) {
+	f := &File{Container: MPEG, StreamCount: 1}
+	start := getId3v2Size(r, 0)
+	if start > 0 {
+		marker := make([]byte, 4)
+		if _, err := r.ReadAt(marker, start); err == nil && string(marker) == "fLaC" {
+			flac, err := readFlac(r, start)
+			if err == nil {
+				err = flac.readId3v2(r, 0)
+			}
+			return flac, err
+		}
+		if err := f.readId3v2(r, 0); err != nil {
+			return nil, err
+		}
+	}
+	// APEv2 tags come before the ID3v1 tag
+	id3v1 := &File{}
+	end := r.Size() - id3v1.readId3v1(r)
+	end -= f.readApe(r, end)
+	f.Frames = append(f.Frames, id3v1.Frames...)
+
+	searchSize := mpegMaxSyncSearchSize
+	if start == 0 {
+		// Without a tag, the file has to start with a frame,
+		// so that we do not mistake another format for an MPEG stream
+		searchSize = 0
+	}
+	frameOffset, header, found := findMpegFrame(r, start, searchSize)
+	if !found {
+		return nil, ErrUnsupportedFormat
+	}
+	f.Codec = fmt.Sprintf("mp%d", header.layer)
+	f.SampleRate = header.sampleRate
+	f.Channels = header.channels
+	frame := make([]byte, header.size)
+	n, _ := r.ReadAt(frame, frameOffset)
+	frameCount, byteCount := readMpegVbrHeader(frame[:n], header)
+	audioSize := end - frameOffset
+	if frameCount > 0 {
+		f.Duration = secondsToDuration(float64(frameCount*header.samplesPerFrame) / float64(header.sampleRate))
+		if byteCount > 0 {
+			audioSize = int64(byteCount)
+		}
+		f.Bitrate = int64(float64(audioSize*8) / f.Duration.Seconds())
+	} else {
+		// Constant bitrate
+		f.Bitrate = int64(header.bitrate * 1000)
+		f.Duration = secondsToDuration(float64(audioSize*8) / float64(f.Bitrate))
+	}
+	return f, nil
+}
@@ -0,0 +1,138 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+const oggPageHeaderSize = 27
+
+// Size of the end of the file that is read to find the last page
+const oggLastPageSearchSize = 64 * 1024
+
+type oggPage struct {
+	granulePosition int64
+	serial          uint32
+	segments        []byte
+	// Offset of the next page
+	next int64
+}
+
+func readOggPage(r *io.SectionReader, offset int64) (oggPage, []byte, error) {
+	header := make([]byte, oggPageHeaderSize)
+	if _, err := r.ReadAt(header, offset); err != nil || !bytes.HasPrefix(header, []byte("OggS")) {
+		return oggPage{}, nil, errMalformed
+	}
+	page := oggPage{
+		granulePosition: int64(binary.LittleEndian.Uint64(header[6:14])),
+		serial:          binary.LittleEndian.Uint32(header[14:18]),
+		segments:        make([]byte, header[26]),
+	}
+	if _, err := r.ReadAt(page.segments, offset+oggPageHeaderSize); err != nil {
+		return oggPage{}, nil, errMalformed
+	}
+	bodySize := 0
+	for _, segment := range page.segments {
+		bodySize += int(segment)
+	}
+	body := make([]byte, bodySize)
+	bodyOffset := offset + oggPageHeaderSize + int64(len(page.segments))
+	if _, err := r.ReadAt(body, bodyOffset); err != nil {
+		return oggPage{}, nil, errMalformed
+	}
+	page.next = bodyOffset + int64(bodySize)
+	return page, body, nil
+}
+
+// Returns the first packets of the first logical stream
+func readOggPackets(r *io.SectionReader, count int) ([][]byte, uint32, error) {
+	packets := [][]byte{}
+	current := []byte{}
+	var serial uint32
+	for offset, isFirstPage := int64(0), true; len(packets) < count; isFirstPage = false {
+		page, body, err := readOggPage(r, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset = page.next
+		if isFirstPage {
+			serial = page.serial
+		} else if page.serial != serial {
+			// Another stream, multiplexed with the first one
+			continue
+		}
+		for _, segment := range page.segments {
+			current = append(current, body[:segment]...)
+			body = body[segment:]
+			// A segment shorter than 255 bytes ends the packet
+			if segment < 255 {
+				packets = append(packets, current)
+				current = []byte{}
+			}
+		}
+	}
+	return packets[:count], serial, nil
+}
+
+// Returns the granule position of the last page of the stream
+func readOggLastGranulePosition(r *io.SectionReader, serial uint32) int64 {
+	start := max(0, r.Size()-oggLastPageSearchSize)
+	end := make([]byte, r.Size()-start)
+	if _, err := r.ReadAt(end, start); err != nil {
+		return 0
+	}
+	for i := len(end); i > 0; {
+		i = bytes.LastIndex(end[:i], []byte("OggS"))
+		if i < 0 || i+oggPageHeaderSize > len(end) {
+			return 0
+		}
+		if binary.LittleEndian.Uint32(end[i+14:i+18]) == serial {
+			return int64(binary.LittleEndian.Uint64(end[i+6 : i+14]))
+		}
+	}
+	return 0
+}
+
+// Only Vorbis and Opus streams are supported
+func readOgg(r *io.SectionReader) (*File, error) {
+	packets, serial, err := readOggPackets(r, 2)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{Container: Ogg, StreamCount: 1}
+	identification, comment := packets[0], packets[1]
+	var sampleCount int64
+	switch {
+	case bytes.HasPrefix(identification, []byte("OpusHead")) && len(identification) >= 12:
+		f.Codec = "opus"
+		f.Channels = int(identification[9])
+		// Opus is always decoded at 48kHz
+		f.SampleRate = 48000
+		preSkip := int64(binary.LittleEndian.Uint16(identification[10:12]))
+		sampleCount = readOggLastGranulePosition(r, serial) - preSkip
+		if !bytes.HasPrefix(comment, []byte("OpusTags")) {
+			return nil, errMalformed
+		}
+		comment = comment[len("OpusTags"):]
+	case bytes.HasPrefix(identification, []byte("\x01vorbis")) && len(identification) >= 16:
+		f.Codec = "vorbis"
+		f.Channels = int(identification[11])
+		f.SampleRate = int(binary.LittleEndian.Uint32(identification[12:16]))
+		sampleCount = readOggLastGranulePosition(r, serial)
+		if !bytes.HasPrefix(comment, []byte("\x03vorbis")) {
+			return nil, errMalformed
+		}
+		comment = comment[len("\x03vorbis"):]
+	default:
+		// e.g. Theora or FLAC
+		return nil, ErrUnsupportedFormat
+	}
+	if err := f.readVorbisComment(comment); err != nil {
+		return nil, err
+	}
+	if f.SampleRate > 0 && sampleCount > 0 {
+		f.Duration = secondsToDuration(float64(sampleCount) / float64(f.SampleRate))
+	}
+	return f, nil
+}
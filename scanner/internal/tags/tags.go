@@ -0,0 +1,216 @@
+package tags
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Returned for containers the reader does not handle (e.g. video files)
+// Use ffprobe for these
+var ErrUnsupportedFormat = errors.New("unsupported format")
+
+var errMalformed = errors.New("malformed file")
+
+type Container string
+
+const (
+	MPEG Container = "mpeg"
+	FLAC Container = "flac"
+	Ogg  Container = "ogg"
+	MP4  Container = "mp4"
+)
+
+type TagFormat string
+
+const (
+	ID3v1         TagFormat = "ID3v1"
+	ID3v22        TagFormat = "ID3v2.2"
+	ID3v23        TagFormat = "ID3v2.3"
+	ID3v24        TagFormat = "ID3v2.4"
+	VorbisComment TagFormat = "Vorbis"
+	MP4Atoms      TagFormat = "MP4"
+	APEv2         TagFormat = "APEv2"
+)
+
+// Common fields, whatever the tag format
+type Key string
+
+const (
+	Title        Key = "title"
+	Artist       Key = "artist"
+	AlbumArtist  Key = "album_artist"
+	Album        Key = "album"
+	DiscSubtitle Key = "disc_subtitle"
+	Genre        Key = "genre"
+	Compilation  Key = "compilation"
+	TrackNumber  Key = "track"
+	DiscNumber   Key = "disc"
+	Date         Key = "date"
+	OriginalDate Key = "original_date"
+	Lyrics       Key = "lyrics"
+	Bpm          Key = "bpm"
+	// One value per artist, as written by MusicBrainz Picard
+	Artists      Key = "artists"
+	AlbumArtists Key = "album_artists"
+
+	MusicBrainzRecordingId    Key = "musicbrainz_recording_id"
+	MusicBrainzTrackId        Key = "musicbrainz_track_id"
+	MusicBrainzReleaseId      Key = "musicbrainz_release_id"
+	MusicBrainzReleaseGroupId Key = "musicbrainz_release_group_id"
+	MusicBrainzArtistId       Key = "musicbrainz_artist_id"
+	MusicBrainzAlbumArtistId  Key = "musicbrainz_album_artist_id"
+	Isrc                      Key = "isrc"
+	Barcode                   Key = "barcode"
+	CatalogNumber             Key = "catalog_number"
+	Label                     Key = "label"
+
+	ReplayGainTrackGain Key = "replaygain_track_gain"
+	ReplayGainTrackPeak Key = "replaygain_track_peak"
+	ReplayGainAlbumGain Key = "replaygain_album_gain"
+	ReplayGainAlbumPeak Key = "replaygain_album_peak"
+	// Opus gains, in Q7.8 fixed point and relative to -23 LUFS
+	R128TrackGain Key = "r128_track_gain"
+	R128AlbumGain Key = "r128_album_gain"
+)
+
+type Frame struct {
+	// Identifier of the frame, as written in the file (e.g. 'TPE1', 'ARTIST', '©ART')
+	// For user-defined frames, the description is appended (e.g. 'TXXX:MusicBrainz Album Id', '----:com.apple.iTunes:MusicBrainz Album Id')
+	Id  string
+	Tag TagFormat
+	// Empty if the frame is not one of the common fields
+	Key Key
+	// Numbers are formatted as text (e.g. '3/12' for track numbers)
+	Values []string
+}
+
+type Picture struct {
+	// As defined by ID3v2 (e.g. 3 for the front cover)
+	Type     int
+	MimeType string
+	Data     []byte
+}
+
+// Tags and audio properties of a file
+type File struct {
+	Container Container
+	// e.g. 'mp3', 'flac', 'opus', 'vorbis', 'aac' or 'alac'
+	Codec string
+	// Number of audio streams, as seen by ffmpeg
+	StreamCount int
+	Duration    time.Duration
+	// In bits per second
+	Bitrate    int64
+	SampleRate int
+	Channels   int
+	// Zero for lossy codecs
+	BitDepth int
+	// In the order they were read. When a file has several tags, the main one comes first
+	Frames   []Frame
+	Pictures []Picture
+}
+
+// Returns the values of the first frame for the field, nil if there is none
+func (f *File) Get(key Key) []string {
+	frame, found := f.Find(key)
+	if !found {
+		return nil
+	}
+	return frame.Values
+}
+
+// Returns the first frame for the field
+func (f *File) Find(key Key) (Frame, bool) {
+	for _, frame := range f.Frames {
+		if frame.Key == key && len(frame.Values) > 0 {
+			return frame, true
+		}
+	}
+	return Frame{}, false
+}
+
+// Returns the values of the first frame with the identifier, nil if there is none
+// The comparison is case-insensitive, as Vorbis comments and APE keys are
+func (f *File) GetById(id string) []string {
+	for _, frame := range f.Frames {
+		if strings.EqualFold(frame.Id, id) && len(frame.Values) > 0 {
+			return frame.Values
+		}
+	}
+	return nil
+}
+
+// Reads the tags and the audio properties of an MP3, FLAC, Ogg Vorbis, Opus or MP4 audio file
+// Returns ErrUnsupportedFormat for other files, including video files
+func Read(filePath string) (*File, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	r := io.NewSectionReader(file, 0, stat.Size())
+	header := make([]byte, 12)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+	var f *File
+	switch {
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		f, err = readFlac(r, 0)
+	case bytes.HasPrefix(header, []byte("OggS")):
+		f, err = readOgg(r)
+	case bytes.Equal(header[4:8], []byte("ftyp")):
+		f, err = readMp4(r)
+	default:
+		f, err = readMpeg(r)
+	}
+	if f != nil && err == nil && f.Duration > 0 && f.Bitrate == 0 {
+		// That is what ffprobe reports for most containers
+		f.Bitrate = int64(float64(r.Size()*8) / f.Duration.Seconds())
+	}
+	return f, err
+}
+
+// Returns the frame for the identifier, creating it if needed
+// So that repeated fields (e.g. several Vorbis 'ARTIST' comments) end up in the same frame
+func (f *File) addValues(tag TagFormat, id string, key Key, values ...string) {
+	for i := range f.Frames {
+		if f.Frames[i].Tag == tag && strings.EqualFold(f.Frames[i].Id, id) {
+			f.Frames[i].Values = append(f.Frames[i].Values, values...)
+			return
+		}
+	}
+	f.Frames = append(f.Frames, Frame{Id: id, Tag: tag, Key: key, Values: values})
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// Reads the given number of bytes at the offset
+// The size usually comes from a header, so it is checked against the size of the file first,
+// so that a corrupted header does not make us allocate more than the file holds
+func readBytes(r *io.SectionReader, offset int64, size int64) ([]byte, error) {
+	if offset < 0 || size < 0 || size > r.Size()-offset {
+		return nil, errMalformed
+	}
+	data := make([]byte, size)
+	if _, err := r.ReadAt(data, offset); err != nil {
+		return nil, errMalformed
+	}
+	return data, nil
+}
+
+// Splits the data on the first null byte
+func cutNull(data []byte) ([]byte, []byte) {
+	before, after, _ := bytes.Cut(data, []byte{0})
+	return before, after
+}
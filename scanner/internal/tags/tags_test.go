@@ -0,0 +1,164 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadMp4(t *testing.T) {
+	f, err := Read(path.Join("../..", "testdata", "dreams.m4a"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, MP4, f.Container)
+	assert.Equal(t, "aac", f.Codec)
+	assert.Equal(t, 1, f.StreamCount)
+	assert.Equal(t, 210373*time.Millisecond, f.Duration)
+	assert.Equal(t, int64(136), f.Bitrate/1000)
+	assert.Equal(t, []string{"Dreams"}, f.Get(Title))
+	assert.Equal(t, []string{"My Album Artist"}, f.Get(AlbumArtist))
+	assert.Equal(t, []string{"Pop;Rock;Trip-Hop"}, f.Get(Genre))
+	assert.Equal(t, []string{"1"}, f.Get(Compilation))
+	assert.Equal(t, []string{"3"}, f.Get(TrackNumber))
+	assert.Equal(t, []string{"2"}, f.Get(DiscNumber))
+	frame, _ := f.Find(Artist)
+	assert.Equal(t, "©ART", frame.Id)
+	assert.Equal(t, MP4Atoms, frame.Tag)
+}
+
+func TestReadFlac(t *testing.T) {
+	f, err := Read(path.Join("../..", "testdata", "test.flac"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, FLAC, f.Container)
+	assert.Equal(t, 44100, f.SampleRate)
+	assert.Equal(t, 2, f.Channels)
+	assert.Equal(t, 24, f.BitDepth)
+	assert.Equal(t, int64(217), int64(f.Duration.Seconds()))
+	assert.Equal(t, []string{"Title"}, f.Get(Title))
+	assert.Equal(t, []string{"My Disc"}, f.Get(DiscSubtitle))
+	assert.Equal(t, []string{"1999"}, f.Get(Date))
+	assert.Equal(t, []string{"155"}, f.GetById("tbp"))
+}
+
+func TestReadOpus(t *testing.T) {
+	f, err := Read(path.Join("../..", "testdata", "test.opus"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, Ogg, f.Container)
+	assert.Equal(t, "opus", f.Codec)
+	assert.Equal(t, 48000, f.SampleRate)
+	assert.Equal(t, int64(217), int64(f.Duration.Seconds()))
+	assert.Equal(t, []string{"Album Artist"}, f.Get(AlbumArtist))
+	assert.Equal(t, []string{"2"}, f.Get(DiscNumber))
+}
+
+func TestReadMp3(t *testing.T) {
+	filePath := path.Join(t.TempDir(), "test.mp3")
+	os.WriteFile(filePath, buildMp3(), 0644)
+
+	f, err := Read(filePath)
+
+	assert.NoError(t, err)
+	assert.Equal(t, MPEG, f.Container)
+	assert.Equal(t, "mp3", f.Codec)
+	assert.Equal(t, int64(128000), f.Bitrate)
+	assert.Equal(t, 44100, f.SampleRate)
+	// ID3v2 frames come first
+	assert.Equal(t, []string{"Title"}, f.Get(Title))
+	frame, _ := f.Find(Artist)
+	assert.Equal(t, ID3v24, frame.Tag)
+	assert.Equal(t, []string{"Artist A", "Artist B"}, frame.Values)
+	assert.Equal(t, []string{"Rock", "Revival"}, f.Get(Genre))
+	assert.Equal(t, []string{"123"}, f.GetById("TXXX:MusicBrainz Album Id"))
+	assert.Equal(t, []string{"123"}, f.Get(MusicBrainzReleaseId))
+	assert.Equal(t, []string{"456"}, f.Get(MusicBrainzRecordingId))
+	assert.Equal(t, []string{"Artist A", "Artist C"}, f.Get(Artists))
+	assert.Equal(t, []string{"Lyrics"}, f.Get(Lyrics))
+	// Fields that are only in the other tags
+	assert.Equal(t, []string{"Album"}, f.Get(Album))
+	assert.Equal(t, []string{"1999"}, f.Get(Date))
+	assert.Equal(t, []string{"5"}, f.Get(TrackNumber))
+	assert.Len(t, f.Pictures, 1)
+	assert.Equal(t, "image/jpeg", f.Pictures[0].MimeType)
+	assert.Equal(t, []byte{1, 2, 3}, f.Pictures[0].Data)
+}
+
+func TestUnsupportedFormat(t *testing.T) {
+	filePath := path.Join(t.TempDir(), "test.mkv")
+	os.WriteFile(filePath, []byte{0x1a, 0x45, 0xdf, 0xa3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, 0644)
+
+	_, err := Read(filePath)
+
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+}
+
+func TestCorruptedSizeIsNotAllocated(t *testing.T) {
+	for name, content := range map[string][]byte{
+		// ID3v2 tag of about 256MB
+		"test.mp3": append([]byte("ID3\x04\x00\x00\x7f\x7f\x7f\x7f"), make([]byte, 32)...),
+		// Vorbis comment block of about 16MB
+		"test.flac": append([]byte("fLaC\x04\xff\xff\xff"), make([]byte, 32)...),
+	} {
+		filePath := path.Join(t.TempDir(), name)
+		os.WriteFile(filePath, content, 0644)
+
+		_, err := Read(filePath)
+
+		assert.ErrorIs(t, err, errMalformed, name)
+	}
+}
+
+// Builds an MP3 file with an ID3v2.4 tag, 10 silent frames, an APEv2 tag and an ID3v1 tag
+func buildMp3() []byte {
+	frames := bytes.NewBuffer(nil)
+	id3Frame := func(id string, content []byte) {
+		frames.WriteString(id)
+		frames.Write([]byte{0, 0, byte(len(content) >> 7), byte(len(content) & 0x7f), 0, 0})
+		frames.Write(content)
+	}
+	id3Frame("TIT2", []byte("\x03Title"))
+	id3Frame("TPE1", []byte("\x03Artist A\x00Artist B"))
+	id3Frame("TCON", []byte("\x03Rock\x0087"))
+	id3Frame("TXXX", []byte("\x03MusicBrainz Album Id\x00123"))
+	id3Frame("TXXX", []byte("\x03Artists\x00Artist A\x00Artist C"))
+	id3Frame("UFID", []byte("http://musicbrainz.org\x00456"))
+	id3Frame("USLT", []byte("\x03eng\x00Lyrics"))
+	id3Frame("APIC", []byte("\x03image/jpeg\x00\x03\x00\x01\x02\x03"))
+	file := bytes.NewBuffer(nil)
+	file.WriteString("ID3\x04\x00\x00")
+	file.Write([]byte{0, 0, byte(frames.Len() >> 7), byte(frames.Len() & 0x7f)})
+	file.Write(frames.Bytes())
+
+	// MPEG-1 layer III, 128kbps, 44.1kHz, stereo
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xff, 0xfb, 0x90, 0x00})
+	for range 10 {
+		file.Write(frame)
+	}
+
+	item := bytes.NewBuffer(nil)
+	binary.Write(item, binary.LittleEndian, uint32(5))
+	binary.Write(item, binary.LittleEndian, uint32(0))
+	item.WriteString("Album\x00Album")
+	file.Write(item.Bytes())
+	file.WriteString("APETAGEX")
+	binary.Write(file, binary.LittleEndian, uint32(2000))
+	binary.Write(file, binary.LittleEndian, uint32(item.Len()+apeFooterSize))
+	binary.Write(file, binary.LittleEndian, uint32(1))
+	file.Write(make([]byte, 12))
+
+	id3v1 := make([]byte, id3v1Size)
+	copy(id3v1, "TAG")
+	copy(id3v1[3:], "Other Title")
+	copy(id3v1[93:], "1999")
+	id3v1[126] = 5
+	id3v1[127] = 17
+	file.Write(id3v1)
+	return file.Bytes()
+}
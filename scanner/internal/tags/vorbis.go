@@ -0,0 +1,135 @@
+package tags
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+)
+
+// Key is the upper-cased field name
+var vorbisKeys = map[string]Key{
+	"TITLE":          Title,
+	"ARTIST":         Artist,
+	"ALBUMARTIST":    AlbumArtist,
+	"ALBUM ARTIST":   AlbumArtist,
+	"ALBUM_ARTIST":   AlbumArtist,
+	"ARTISTS":        Artists,
+	"ALBUMARTISTS":   AlbumArtists,
+	"ALBUM":          Album,
+	"DISCSUBTITLE":   DiscSubtitle,
+	"GENRE":          Genre,
+	"COMPILATION":    Compilation,
+	"TRACKNUMBER":    TrackNumber,
+	"DISCNUMBER":     DiscNumber,
+	"DATE":           Date,
+	"YEAR":           Date,
+	"ORIGINALDATE":   OriginalDate,
+	"LYRICS":         Lyrics,
+	"UNSYNCEDLYRICS": Lyrics,
+	"BPM":            Bpm,
+	"ISRC":           Isrc,
+	"BARCODE":        Barcode,
+	"CATALOGNUMBER":  CatalogNumber,
+	"LABEL":          Label,
+	"ORGANIZATION":   Label,
+
+	"MUSICBRAINZ_TRACKID":        MusicBrainzRecordingId,
+	"MUSICBRAINZ_RELEASETRACKID": MusicBrainzTrackId,
+	"MUSICBRAINZ_ALBUMID":        MusicBrainzReleaseId,
+	"MUSICBRAINZ_RELEASEGROUPID": MusicBrainzReleaseGroupId,
+	"MUSICBRAINZ_ARTISTID":       MusicBrainzArtistId,
+	"MUSICBRAINZ_ALBUMARTISTID":  MusicBrainzAlbumArtistId,
+
+	"REPLAYGAIN_TRACK_GAIN": ReplayGainTrackGain,
+	"REPLAYGAIN_TRACK_PEAK": ReplayGainTrackPeak,
+	"REPLAYGAIN_ALBUM_GAIN": ReplayGainAlbumGain,
+	"REPLAYGAIN_ALBUM_PEAK": ReplayGainAlbumPeak,
+	"R128_TRACK_GAIN":       R128TrackGain,
+	"R128_ALBUM_GAIN":       R128AlbumGain,
+}
+
+// Reads a Vorbis comment block, as found in FLAC and Ogg files
+// Pictures stored as METADATA_BLOCK_PICTURE comments are added to the pictures of the file
+func (f *File) readVorbisComment(data []byte) error {
+	readLength := func() (int, bool) {
+		if len(data) < 4 {
+			return 0, false
+		}
+		length := int(binary.LittleEndian.Uint32(data))
+		data = data[4:]
+		return length, length <= len(data)
+	}
+	vendorLength, ok := readLength()
+	if !ok {
+		return errMalformed
+	}
+	data = data[vendorLength:]
+	if len(data) < 4 {
+		return errMalformed
+	}
+	count := int(binary.LittleEndian.Uint32(data))
+	data = data[4:]
+	for range count {
+		length, ok := readLength()
+		if !ok {
+			return errMalformed
+		}
+		field, value, found := strings.Cut(string(data[:length]), "=")
+		data = data[length:]
+		if !found {
+			continue
+		}
+		id := strings.ToUpper(field)
+		if id == "METADATA_BLOCK_PICTURE" {
+			if block, err := base64.StdEncoding.DecodeString(value); err == nil {
+				f.readPictureBlock(block)
+			}
+			continue
+		}
+		f.addValues(VorbisComment, id, vorbisKeys[id], value)
+	}
+	return nil
+}
+
+// Reads a FLAC picture block
+// Malformed blocks are ignored
+func (f *File) readPictureBlock(data []byte) {
+	readUint32 := func() (int, bool) {
+		if len(data) < 4 {
+			return 0, false
+		}
+		value := int(binary.BigEndian.Uint32(data))
+		data = data[4:]
+		return value, true
+	}
+	readString := func() (string, bool) {
+		length, ok := readUint32()
+		if !ok || length > len(data) {
+			return "", false
+		}
+		value := string(data[:length])
+		data = data[length:]
+		return value, true
+	}
+	pictureType, ok := readUint32()
+	if !ok {
+		return
+	}
+	mimeType, ok := readString()
+	if !ok {
+		return
+	}
+	if _, ok := readString(); !ok {
+		return
+	}
+	// Width, height, color depth and number of colors
+	if len(data) < 16 {
+		return
+	}
+	data = data[16:]
+	length, ok := readUint32()
+	if !ok || length > len(data) {
+		return
+	}
+	f.Pictures = append(f.Pictures, Picture{Type: pictureType, MimeType: mimeType, Data: data[:length]})
+}
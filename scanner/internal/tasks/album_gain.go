@@ -0,0 +1,199 @@
+package tasks
+
+import (
+	"math"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal"
+)
+
+// Loudness of the files of each release, to compute the album gains that the tags do not have
+type releaseLoudness struct {
+	// Key is the release key
+	releases map[string]*releaseFiles
+}
+
+type releaseFiles struct {
+	// Set if any file of the release has an album gain
+	albumGain *float64
+	albumPeak *float64
+	// Metadata of the files, with only the fields needed for the computation
+	files []internal.Metadata
+}
+
+func newReleaseLoudness() *releaseLoudness {
+	return &releaseLoudness{releases: map[string]*releaseFiles{}}
+}
+
+// Returns an empty string for files that are not part of a release
+func getReleaseKey(m internal.Metadata) string {
+	if len(m.MusicBrainzReleaseId) > 0 {
+		return m.MusicBrainzReleaseId
+	}
+	if len(m.Release) == 0 {
+		return ""
+	}
+	return strings.ToLower(m.AlbumArtist + "\x00" + m.Release)
+}
+
+// True if the album gain of the file is missing, but can be computed from the track gains
+func needsAlbumGain(m internal.Metadata) bool {
+	return m.AlbumGain == nil && m.TrackGain != nil && len(getReleaseKey(m)) > 0
+}
+
+func (r *releaseLoudness) add(m internal.Metadata) {
+	key := getReleaseKey(m)
+	if len(key) == 0 {
+		return
+	}
+	release, found := r.releases[key]
+	if !found {
+		release = &releaseFiles{}
+		r.releases[key] = release
+	}
+	if release.albumGain == nil && m.AlbumGain != nil {
+		release.albumGain = m.AlbumGain
+		release.albumPeak = m.AlbumPeak
+	}
+	release.files = append(release.files, internal.Metadata{
+		Duration:  m.Duration,
+		TrackGain: m.TrackGain,
+		TrackPeak: m.TrackPeak,
+	})
+}
+
+// Sets the album gain and peak of the file, using the other files of its release
+// The album gain of another file is used if there is one
+// Otherwise, it is computed if the release is complete and all its files have a track gain
+func (r *releaseLoudness) setAlbumGain(m *internal.Metadata, isComplete bool) {
+	release, found := r.releases[getReleaseKey(*m)]
+	if !found {
+		return
+	}
+	if release.albumGain != nil {
+		m.AlbumGain = release.albumGain
+		m.AlbumPeak = release.albumPeak
+		return
+	}
+	if !isComplete {
+		return
+	}
+	release.albumGain, release.albumPeak = computeAlbumGain(release.files)
+	m.AlbumGain = release.albumGain
+	m.AlbumPeak = release.albumPeak
+}
+
+// The loudness of the album is the mean of the loudness of the tracks, in the energy domain and weighted by duration
+// The peak is the highest track peak, nil if a track has none
+func computeAlbumGain(files []internal.Metadata) (*float64, *float64) {
+	energySum := 0.0
+	totalDuration := 0.0
+	peak := 0.0
+	hasAllPeaks := true
+	for _, file := range files {
+		if file.TrackGain == nil {
+			return nil, nil
+		}
+		// Files without a duration still count
+		duration := float64(max(file.Duration, 1))
+		loudness := internal.ReplayGainReferenceLoudness - *file.TrackGain
+		energySum += duration * math.Pow(10, loudness/10)
+		totalDuration += duration
+		if file.TrackPeak == nil {
+			hasAllPeaks = false
+		} else {
+			peak = max(peak, *file.TrackPeak)
+		}
+	}
+	if totalDuration == 0 {
+		return nil, nil
+	}
+	gain := internal.ReplayGainReferenceLoudness - 10*math.Log10(energySum/totalDuration)
+	if !hasAllPeaks {
+		return &gain, nil
+	}
+	return &gain, &peak
+}
+
+// Files of a directory, held until all the files of the directory are parsed
+type scannedDirectory struct {
+	// Number of files of the directory in the scan
+	fileCount int
+	// Number of files of the directory that are not parsed yet
+	remaining int
+	// Set if a file could not be parsed, as its release is then unknown
+	hasFailures bool
+	releases    *releaseLoudness
+	heldFiles   []ScanRes
+}
+
+// Forwards the parsed files to the output channel
+// Files without an album gain are held until the other files of their directory are parsed,
+// as it is computed from the other files of their release
+// The album gain is only computed if all the media files of the directory were parsed
+func holdFilesForAlbumGain(filePaths []string, scanResChan <-chan ScanRes, outputChan chan<- ScanRes) {
+	directories := map[string]*scannedDirectory{}
+	for _, filePath := range filePaths {
+		directoryPath := path.Dir(filePath)
+		directory, found := directories[directoryPath]
+		if !found {
+			directory = &scannedDirectory{releases: newReleaseLoudness()}
+			directories[directoryPath] = directory
+		}
+		directory.fileCount++
+		directory.remaining++
+	}
+	flush := func(directoryPath string, directory *scannedDirectory, isComplete bool) {
+		for _, res := range directory.heldFiles {
+			directory.releases.setAlbumGain(&res.metadata, isComplete)
+			outputChan <- res
+		}
+		delete(directories, directoryPath)
+	}
+	for res := range scanResChan {
+		directoryPath := path.Dir(res.filePath)
+		directory, found := directories[directoryPath]
+		if !found {
+			outputChan <- res
+			continue
+		}
+		directory.remaining--
+		if len(res.errors) > 0 {
+			directory.hasFailures = true
+			outputChan <- res
+		} else {
+			directory.releases.add(res.metadata)
+			if needsAlbumGain(res.metadata) {
+				directory.heldFiles = append(directory.heldFiles, res)
+			} else {
+				outputChan <- res
+			}
+		}
+		if directory.remaining == 0 {
+			isComplete := !directory.hasFailures && isWholeDirectory(directoryPath, directory.fileCount)
+			flush(directoryPath, directory, isComplete)
+		}
+	}
+	// Only if parsing was interrupted
+	for directoryPath, directory := range directories {
+		flush(directoryPath, directory, false)
+	}
+}
+
+// True if the directory does not have other media files than the scanned ones
+// Otherwise, the album gain would be computed without the files that are already registered
+func isWholeDirectory(directoryPath string, scannedFileCount int) bool {
+	entries, err := os.ReadDir(directoryPath)
+	if err != nil {
+		return false
+	}
+	mediaFileCount := 0
+	for _, entry := range entries {
+		if !entry.IsDir() && isMediaFile(entry.Name()) {
+			mediaFileCount++
+		}
+	}
+	return mediaFileCount == scannedFileCount
+}
@@ -0,0 +1,136 @@
+package tasks
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+func TestComputeAlbumGain(t *testing.T) {
+	releases := newReleaseLoudness()
+	files := []internal.Metadata{
+		{Release: "Album", Duration: 100, TrackGain: floatPtr(-6), TrackPeak: floatPtr(0.9)},
+		{Release: "Album", Duration: 300, TrackGain: floatPtr(-6), TrackPeak: floatPtr(1.1)},
+		{Release: "Other Album", Duration: 100, TrackGain: floatPtr(2), TrackPeak: floatPtr(0.5)},
+	}
+	for _, file := range files {
+		releases.add(file)
+	}
+
+	releases.setAlbumGain(&files[0], true)
+	releases.setAlbumGain(&files[2], true)
+
+	assert.InDelta(t, -6, *files[0].AlbumGain, 0.001)
+	assert.Equal(t, 1.1, *files[0].AlbumPeak)
+	assert.InDelta(t, 2, *files[2].AlbumGain, 0.001)
+}
+
+func TestComputeAlbumGainIsWeightedByDuration(t *testing.T) {
+	gain, _ := computeAlbumGain([]internal.Metadata{
+		{Duration: 100, TrackGain: floatPtr(0)},
+		{Duration: 300, TrackGain: floatPtr(-10)},
+	})
+
+	// Louder tracks weigh more, as energies are averaged
+	assert.InDelta(t, -8.89, *gain, 0.01)
+}
+
+func TestAlbumGainIsNotComputedIfATrackGainIsMissing(t *testing.T) {
+	releases := newReleaseLoudness()
+	files := []internal.Metadata{
+		{Release: "Album", Duration: 100, TrackGain: floatPtr(-6)},
+		{Release: "Album", Duration: 100},
+	}
+	for _, file := range files {
+		releases.add(file)
+	}
+
+	releases.setAlbumGain(&files[0], true)
+
+	assert.Nil(t, files[0].AlbumGain)
+}
+
+func TestAlbumGainOfTheReleaseIsUsed(t *testing.T) {
+	releases := newReleaseLoudness()
+	files := []internal.Metadata{
+		{Release: "Album", Duration: 100, TrackGain: floatPtr(-6)},
+		{Release: "album", Duration: 100, TrackGain: floatPtr(-4), AlbumGain: floatPtr(-5), AlbumPeak: floatPtr(1)},
+	}
+	for _, file := range files {
+		releases.add(file)
+	}
+
+	assert.True(t, needsAlbumGain(files[0]))
+	assert.False(t, needsAlbumGain(files[1]))
+	releases.setAlbumGain(&files[0], true)
+
+	assert.Equal(t, -5.0, *files[0].AlbumGain)
+	assert.Equal(t, 1.0, *files[0].AlbumPeak)
+}
+
+func TestAlbumGainIsNotComputedForIncompleteRelease(t *testing.T) {
+	releases := newReleaseLoudness()
+	files := []internal.Metadata{
+		{Release: "Album", Duration: 100, TrackGain: floatPtr(-6)},
+		{Release: "Album", Duration: 100, TrackGain: floatPtr(-4)},
+	}
+	for _, file := range files {
+		releases.add(file)
+	}
+
+	releases.setAlbumGain(&files[0], false)
+
+	assert.Nil(t, files[0].AlbumGain)
+}
+
+func TestFilesAreHeldUntilTheirDirectoryIsParsed(t *testing.T) {
+	root := t.TempDir()
+	filePaths := []string{path.Join(root, "A", "1.mp3"), path.Join(root, "A", "2.mp3"), path.Join(root, "B", "1.mp3")}
+	// Already registered, so not scanned
+	registeredFile := path.Join(root, "B", "2.mp3")
+	for _, filePath := range append(filePaths, registeredFile) {
+		assert.NoError(t, os.MkdirAll(path.Dir(filePath), 0755))
+		assert.NoError(t, os.WriteFile(filePath, []byte{}, 0644))
+	}
+	scanResChan := make(chan ScanRes, len(filePaths))
+	outputChan := make(chan ScanRes, len(filePaths))
+	go func() {
+		defer close(outputChan)
+		holdFilesForAlbumGain(filePaths, scanResChan, outputChan)
+	}()
+	receive := func() ScanRes {
+		select {
+		case res := <-outputChan:
+			return res
+		case <-time.After(time.Second):
+			t.Fatal("File was not forwarded")
+			return ScanRes{}
+		}
+	}
+
+	scanResChan <- ScanRes{filePath: filePaths[0], metadata: internal.Metadata{Release: "A", Duration: 100, TrackGain: floatPtr(-6)}}
+	select {
+	case <-outputChan:
+		t.Fatal("File was forwarded before its directory was parsed")
+	case <-time.After(10 * time.Millisecond):
+	}
+	scanResChan <- ScanRes{filePath: filePaths[1], metadata: internal.Metadata{Release: "A", Duration: 100, TrackGain: floatPtr(-6)}}
+	// Forwarded before the next directory is parsed
+	for range 2 {
+		res := receive()
+		assert.InDelta(t, -6, *res.metadata.AlbumGain, 0.001)
+	}
+	scanResChan <- ScanRes{filePath: filePaths[2], metadata: internal.Metadata{Release: "B", Duration: 100, TrackGain: floatPtr(-6)}}
+	close(scanResChan)
+	res := receive()
+	assert.Equal(t, filePaths[2], res.filePath)
+	assert.Nil(t, res.metadata.AlbumGain)
+}
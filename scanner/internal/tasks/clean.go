@@ -1,6 +1,7 @@
 package tasks
 
 import (
+	"context"
 	"fmt"
 	"path"
 	"strconv"
@@ -8,52 +9,91 @@ import (
 	"github.com/Arthi-chaud/Meelo/scanner/internal"
 	"github.com/Arthi-chaud/Meelo/scanner/internal/api"
 	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
-	"github.com/Arthi-chaud/Meelo/scanner/internal/filesystem"
 	"github.com/rs/zerolog/log"
 )
 
 func NewLibraryCleanTask(library api.Library, c config.Config) Task {
 	name := fmt.Sprintf("Clean library '%s'", library.Slug)
-	return createTask(name, func(w *Worker) error { return execClean(library, c, w) })
+	return createTask(name, func(ctx context.Context, w *Worker) error {
+		return execClean(ctx, library, c, w)
+	}).inLane(InteractiveLane).withKey(fmt.Sprintf("clean:%d", library.Id)).forLibrary(library.Slug)
 }
 
-func execClean(library api.Library, c config.Config, w *Worker) error {
-	registeredFiles, err := api.GetAllFiles(api.FileSelectorDto{Library: library.Slug}, c)
+func execClean(ctx context.Context, library api.Library, c config.Config, w *Worker) error {
+	unlock, err := w.lockLibraries(ctx, []int{library.Id})
 	if err != nil {
 		return err
 	}
-	w.SetProgress(25, 100)
-	filesInDir, err := filesystem.GetAllFilesInDirectory(path.Join(c.DataDirectory, library.Path))
+	defer unlock()
+	libraryRoot := path.Join(c.DataDirectory, library.Path)
+	if err := w.index.syncLibrary(ctx, library, c); err != nil {
+		return err
+	}
+	if err := w.checkpoint(ctx); err != nil {
+		return err
+	}
+	w.SetProgress(ctx, 25, 100)
+	filesInDir, err := w.index.walk(libraryRoot)
 	if err != nil {
 		return err
 	}
 
-	w.SetProgress(50, 100)
-	filesToClean := []api.File{}
-	for _, registeredFile := range registeredFiles {
-		fullRegisteredPath := path.Join(c.DataDirectory, library.Path, registeredFile.Path)
-		if !internal.Contains(filesInDir, fullRegisteredPath) {
-			filesToClean = append(filesToClean, registeredFile)
+	w.SetProgress(ctx, 50, 100)
+	filesToClean := getFilesToClean(libraryRoot, filesInDir, w)
+	if len(internal.Filter(filesToClean, func(f api.File) bool { return f.Id == 0 })) > 0 {
+		// Some files were registered since the last sync, we need their IDs
+		if err := w.index.forceSyncLibrary(ctx, library, c); err != nil {
+			return err
 		}
+		filesToClean = getFilesToClean(libraryRoot, filesInDir, w)
 	}
-	w.SetProgress(75, 100)
-	successfulClean := DeleteFilesInApi(filesToClean, c, w)
-	w.SetProgress(100, 100)
-	log.Info().
+	if err := w.checkpoint(ctx); err != nil {
+		return err
+	}
+	w.SetProgress(ctx, 75, 100)
+	successfulClean := DeleteFilesInApi(ctx, filesToClean, c, w)
+	w.SetProgress(ctx, 100, 100)
+	log.Ctx(ctx).Info().
 		Str("cleaned", strconv.Itoa(successfulClean)).
-		Str("library", library.Slug).
 		Msg("Finished cleaning files")
 	return nil
 }
 
-func DeleteFilesInApi(filesToClean []api.File, c config.Config, w *Worker) int {
-	err := api.DeleteFiles(c, internal.Fmap(filesToClean, func(f api.File, _ int) int {
+// Returns the registered files of the library that are not in the given set of full paths
+func getFilesToClean(libraryRoot string, filesInDir map[string]bool, w *Worker) []api.File {
+	return internal.Filter(w.index.getRegisteredFiles(libraryRoot), func(f api.File) bool {
+		return !filesInDir[path.Join(libraryRoot, f.Path)]
+	})
+}
+
+func DeleteFilesInApi(ctx context.Context, filesToClean []api.File, c config.Config, w *Worker) int {
+	if dryRunReport := w.getDryRunReport(ctx); dryRunReport != nil {
+		for _, file := range filesToClean {
+			dryRunReport.addDeletedFile(file.Path)
+			w.reportSuccess(ctx)
+		}
+		return len(filesToClean)
+	}
+	fileIds := internal.Fmap(filesToClean, func(f api.File, _ int) int {
 		return f.Id
-	}))
+	})
+	queued, err := w.sendOrQueue(ctx, OutboxOperation{Type: DeleteFilesOperation, FileIds: fileIds}, func() error {
+		return api.DeleteFiles(ctx, c, fileIds)
+	})
 	if err != nil {
-		log.Error().Msg("Cleaning files failed.")
-		log.Trace().Msg(err.Error())
+		log.Ctx(ctx).Error().Err(err).Msg("Cleaning files failed.")
+		for _, file := range filesToClean {
+			w.reportFailure(ctx, file.Path, err)
+		}
 		return 0
 	}
+	// Queued files are forgotten right away, so that they are not cleaned again
+	w.index.setDeleted(fileIds)
+	if queued {
+		log.Ctx(ctx).Info().Msgf("%d file(s) will be deleted once the API is back", len(fileIds))
+	}
+	for range filesToClean {
+		w.reportSuccess(ctx)
+	}
 	return len(filesToClean)
 }
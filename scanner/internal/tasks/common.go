@@ -1,35 +1,98 @@
 package tasks
 
 import (
-	"path"
+	"context"
+	"errors"
 
 	"github.com/Arthi-chaud/Meelo/scanner/internal"
 	"github.com/Arthi-chaud/Meelo/scanner/internal/api"
 	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/metrics"
 	"github.com/rs/zerolog/log"
 )
 
 // Push parsed metadata and saves related illustration/thumbnail
-func pushMetadata(fileFullPath string, m internal.Metadata, c config.Config, w *Worker, updateMethod api.SaveMetadataMethod) error {
-	created, err := api.SaveMetadata(c, m, updateMethod)
+// If the task is a dry run, the metadata is only added to the report
+// If the API is down, the metadata is queued in the outbox, and is not considered failed
+func pushMetadata(ctx context.Context, fileFullPath string, m internal.Metadata, c config.Config, w *Worker, updateMethod api.SaveMetadataMethod) error {
+	if dryRunReport := w.getDryRunReport(ctx); dryRunReport != nil {
+		dryRunReport.addSavedMetadata(m, updateMethod)
+		return nil
+	}
+	created, queued, err := w.saveMetadataOrQueue(ctx, fileFullPath, m, c, updateMethod)
 	if err != nil {
+		metrics.FilesFailed.WithLabelValues(metrics.RegistrationStage).Inc()
 		return err
 	}
+	if queued {
+		// Saved once the outbox is sent
+		return nil
+	}
+	onMetadataSaved(ctx, fileFullPath, m, created, c, w)
+	return nil
+}
+
+// Pushes the metadata of several files, in a single request if the API supports it
+// Returns the error of each file, nil if its metadata was saved
+func pushMetadataBatch(ctx context.Context, filePaths []string, ms []internal.Metadata, c config.Config, w *Worker, updateMethod api.SaveMetadataMethod) []error {
+	errs := make([]error, len(ms))
+	pushOneByOne := func() []error {
+		for i, m := range ms {
+			errs[i] = pushMetadata(withFileLogger(ctx, filePaths[i], c), filePaths[i], m, c, w, updateMethod)
+		}
+		return errs
+	}
+	// If older operations are still queued, the files are queued behind them
+	if len(ms) == 1 || w.getDryRunReport(ctx) != nil || !w.outbox.IsEmpty() {
+		return pushOneByOne()
+	}
+	results, err := api.SaveMetadataBatch(ctx, c, ms, updateMethod)
+	// If one of the files is invalid, the whole batch is rejected
+	// So the files are sent one by one, for only that file to fail
+	if errors.Is(err, api.ErrBatchNotSupported) || errors.Is(err, api.ErrBadRequest) || api.IsDown(err) {
+		return pushOneByOne()
+	}
+	for i, m := range ms {
+		if err == nil && len(results[i].Error) > 0 {
+			errs[i] = errors.New(results[i].Error)
+		} else {
+			errs[i] = err
+		}
+		if errs[i] != nil {
+			metrics.FilesFailed.WithLabelValues(metrics.RegistrationStage).Inc()
+			continue
+		}
+		onMetadataSaved(withFileLogger(ctx, filePaths[i], c), filePaths[i], m, results[i].MetadataCreated, c, w)
+	}
+	return errs
+}
+
+// Updates the index, and saves the illustration, the lyrics and the thumbnail of the file
+// Errors are only logged, as the file is registered anyway
+func onMetadataSaved(ctx context.Context, fileFullPath string, m internal.Metadata, created api.MetadataCreated, c config.Config, w *Worker) {
+	metrics.FilesRegistered.Inc()
+	contentHash := ""
+	if c.UserSettings.ContentHash {
+		// Not fatal, the file just cannot be detected as moved
+		contentHash, _ = internal.ComputeContentHash(fileFullPath)
+	}
+	w.index.setRegistered(fileFullPath, m.Checksum, contentHash)
 	if len(m.IllustrationLocation) > 0 {
-		err := SaveIllustration(IllustrationTask{
+		illustrationTask := IllustrationTask{
 			IllustrationLocation:    m.IllustrationLocation,
 			IllustrationPath:        m.IllustrationPath,
 			TrackPath:               fileFullPath,
 			TrackId:                 created.TrackId,
 			IllustrationStreamIndex: m.IllustrationStreamIndex,
-		}, c)
+		}
+		err := SaveIllustration(ctx, illustrationTask, c)
+		if api.IsDown(err) {
+			err = w.queue(ctx, OutboxOperation{Type: SaveIllustrationOperation, Illustration: &illustrationTask})
+		}
 		if err != nil {
 			// Illustration POST failure is not fatal
 			// So we do not return an error to the caller
-			log.Error().
-				Str("path", path.Base(fileFullPath)).
-				Msgf("Saving illustration failed")
-			log.Trace().Msg(err.Error())
+			log.Ctx(ctx).Error().Err(err).Msg("Saving illustration failed")
 		}
 	}
 
@@ -37,16 +100,17 @@ func pushMetadata(fileFullPath string, m internal.Metadata, c config.Config, w *
 		return len(s) > 0
 	})) > 0
 	if hasLyrics && created.SongId != 0 {
-		hasPrevLyrics, _ := api.HasLyrics(c, created.SongId)
-		if !hasPrevLyrics {
-			err := api.PostLyrics(c, created.SongId, m.Lyrics)
-			if err != nil {
-				log.Fatal().Msg("Could not POST lyrics")
-				log.Trace().Msg(err.Error())
-			}
+		hasPrevLyrics, err := api.HasLyrics(ctx, c, created.SongId)
+		if err == nil && !hasPrevLyrics {
+			err = api.PostLyrics(ctx, c, created.SongId, m.Lyrics)
+		}
+		if err != nil {
+			// Like illustrations, lyrics are not fatal
+			log.Ctx(ctx).Error().Err(err).Msg("Saving lyrics failed")
 		}
 	}
 	if m.Type == internal.Video {
+		w.thumbnailWg.Add(1)
 		go func() {
 			w.thumbnailQueue <- ThumbnailTask{
 				TrackId:       created.TrackId,
@@ -55,5 +119,4 @@ func pushMetadata(fileFullPath string, m internal.Metadata, c config.Config, w *
 			}
 		}()
 	}
-	return nil
 }
@@ -0,0 +1,114 @@
+package tasks
+
+import (
+	"os"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/api"
+	"github.com/goccy/go-json"
+)
+
+// Name of the directory, in the config directory, where dry-run reports are saved
+const DryRunReportsDirectoryName = "dry_runs"
+
+// Maximum number of dry-run reports to keep on disk
+const MaxDryRunReportCount = 10
+
+type DryRunEntry struct {
+	// Full path of the file for created, updated and moved files
+	// For deleted files, the path is relative to the library
+	Path string `json:"path"`
+	// Only for moved files. Full path of the file before it was moved
+	PreviousPath string `json:"previous_path,omitempty"`
+	// Null for deleted and moved files
+	Metadata *internal.Metadata `json:"metadata"`
+}
+
+// What a task would have done if it had not been a dry run
+type DryRunReport struct {
+	Created []DryRunEntry `json:"created"`
+	Updated []DryRunEntry `json:"updated"`
+	Deleted []DryRunEntry `json:"deleted"`
+	Moved   []DryRunEntry `json:"moved"`
+	mu      sync.Mutex
+}
+
+func NewDryRunReport() *DryRunReport {
+	return &DryRunReport{
+		Created: []DryRunEntry{},
+		Updated: []DryRunEntry{},
+		Deleted: []DryRunEntry{},
+		Moved:   []DryRunEntry{},
+	}
+}
+
+func (r *DryRunReport) addSavedMetadata(m internal.Metadata, saveMethod api.SaveMetadataMethod) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry := DryRunEntry{Path: m.Path, Metadata: &m}
+	if saveMethod == api.Update {
+		r.Updated = append(r.Updated, entry)
+	} else {
+		r.Created = append(r.Created, entry)
+	}
+}
+
+func (r *DryRunReport) addDeletedFile(filePath string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Deleted = append(r.Deleted, DryRunEntry{Path: filePath})
+}
+
+func (r *DryRunReport) addMovedFile(previousPath string, newPath string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Moved = append(r.Moved, DryRunEntry{Path: newPath, PreviousPath: previousPath})
+}
+
+// Returns the path of the file where the report of a task is saved
+func GetDryRunReportPath(configDirectory string, taskId string) string {
+	return path.Join(configDirectory, DryRunReportsDirectoryName, taskId+".json")
+}
+
+// Saves the report, and deletes the oldest ones
+func saveDryRunReport(configDirectory string, taskId string, r *DryRunReport) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reportsDirectory := path.Join(configDirectory, DryRunReportsDirectoryName)
+	if err := os.MkdirAll(reportsDirectory, 0755); err != nil {
+		return err
+	}
+	serialized, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(GetDryRunReportPath(configDirectory, taskId), serialized, 0644); err != nil {
+		return err
+	}
+	return pruneDryRunReports(reportsDirectory)
+}
+
+func pruneDryRunReports(reportsDirectory string) error {
+	entries, err := os.ReadDir(reportsDirectory)
+	if err != nil || len(entries) <= MaxDryRunReportCount {
+		return err
+	}
+	reports := []os.FileInfo{}
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && !info.IsDir() {
+			reports = append(reports, info)
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].ModTime().After(reports[j].ModTime())
+	})
+	for i := MaxDryRunReportCount; i < len(reports); i++ {
+		if err := os.Remove(path.Join(reportsDirectory, reports[i].Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
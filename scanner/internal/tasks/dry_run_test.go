@@ -0,0 +1,44 @@
+package tasks
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/api"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDryRunDoesNotCallApi(t *testing.T) {
+	configDir := t.TempDir()
+	// The API is unreachable, so any request would fail
+	c := config.Config{ApiUrl: "http://localhost:0", ConfigDirectory: configDir}
+	w := NewWorker()
+	w.StartWorker(c)
+	t.Cleanup(w.StopWorker)
+	task := w.AddTask(createTask("Task", func(ctx context.Context, w *Worker) error {
+		m := internal.Metadata{Path: "/data/a.flac", Name: "A"}
+		if err := pushMetadata(ctx, m.Path, m, c, w, api.Create); err != nil {
+			return err
+		}
+		DeleteFilesInApi(ctx, []api.File{{Id: 1, Path: "b.flac"}}, c, w)
+		return nil
+	}).AsDryRun())
+	w.wg.Wait()
+
+	record, _ := w.GetTask(task.Id)
+	assert.Equal(t, Done, record.Status)
+	assert.Equal(t, 1, record.Report.Successful) // The deletion
+	bytes, err := os.ReadFile(GetDryRunReportPath(configDir, task.Id))
+	assert.NoError(t, err)
+	report := NewDryRunReport()
+	assert.NoError(t, json.Unmarshal(bytes, report))
+	assert.Len(t, report.Created, 1)
+	assert.Equal(t, "A", report.Created[0].Metadata.Name)
+	assert.Empty(t, report.Updated)
+	assert.Len(t, report.Deleted, 1)
+	assert.Equal(t, "b.flac", report.Deleted[0].Path)
+}
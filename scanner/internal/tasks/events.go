@@ -0,0 +1,79 @@
+package tasks
+
+import (
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	TaskQueued   EventType = "task-queued"
+	TaskStarted  EventType = "task-started"
+	TaskProgress EventType = "progress"
+	FileParsed   EventType = "file-parsed"
+	FileFailed   EventType = "file-failed"
+	TaskFinished EventType = "task-finished"
+)
+
+// Size of the buffer of each subscriber
+// If a subscriber is too slow, events are dropped for it
+const eventBufferSize = 256
+
+type Event struct {
+	Type     EventType
+	TaskId   string
+	TaskName string
+	Time     time.Time
+	// For progress events. A number between 0 and 100
+	Progress int
+	// For file events. Full path of the file
+	File string
+	// For file-failed events
+	Errors []string
+	// For task-finished events
+	Status TaskStatus
+	// For task-finished events
+	Report TaskReport
+}
+
+// Dispatches the events of the worker to the subscribers
+type EventBroker struct {
+	subscribers map[chan Event]bool
+	mu          sync.Mutex
+}
+
+func NewEventBroker() *EventBroker {
+	return &EventBroker{subscribers: map[chan Event]bool{}}
+}
+
+// Returns a channel of events, and a function to call to unsubscribe
+func (b *EventBroker) Subscribe() (<-chan Event, func()) {
+	events := make(chan Event, eventBufferSize)
+	b.mu.Lock()
+	b.subscribers[events] = true
+	b.mu.Unlock()
+	return events, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.subscribers[events] {
+			delete(b.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// Never blocks. Slow subscribers miss events
+func (b *EventBroker) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for subscriber := range b.subscribers {
+		select {
+		case subscriber <- e:
+		default:
+		}
+	}
+}
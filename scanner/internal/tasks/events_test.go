@@ -0,0 +1,47 @@
+package tasks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerPublishesTaskEvents(t *testing.T) {
+	w := getTestWorker(t)
+	events, unsubscribe := w.Subscribe()
+	defer unsubscribe()
+	task := w.AddTask(createTask("Task", func(ctx context.Context, w *Worker) error {
+		w.SetProgress(ctx, 1, 2)
+		w.reportFileParsed(ctx, "/data/a.flac")
+		w.reportSuccess(ctx)
+		return nil
+	}))
+	w.wg.Wait()
+
+	expectedTypes := []EventType{TaskQueued, TaskStarted, TaskProgress, FileParsed, TaskFinished}
+	for _, expectedType := range expectedTypes {
+		event := <-events
+		assert.Equal(t, expectedType, event.Type)
+		assert.Equal(t, task.Id, event.TaskId)
+		switch event.Type {
+		case TaskProgress:
+			assert.Equal(t, 50, event.Progress)
+		case FileParsed:
+			assert.Equal(t, "/data/a.flac", event.File)
+		case TaskFinished:
+			assert.Equal(t, Done, event.Status)
+			assert.Equal(t, 1, event.Report.Successful)
+		}
+	}
+}
+
+func TestUnsubscribeClosesChannel(t *testing.T) {
+	b := NewEventBroker()
+	events, unsubscribe := b.Subscribe()
+	unsubscribe()
+	b.Publish(Event{Type: TaskQueued})
+
+	_, ok := <-events
+	assert.False(t, ok)
+}
@@ -0,0 +1,370 @@
+package tasks
+
+import (
+	"context"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal/api"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/goccy/go-json"
+	"github.com/rs/zerolog/log"
+)
+
+// Name of the file, in the config directory, where the file index is saved
+const FileIndexFileName = "file_index.json"
+
+// The registered files of a library are fetched from the API again after this delay
+// Delete the index file to force it
+const FileIndexSyncInterval = 24 * time.Hour
+
+// Directories modified more recently than that are read again on the next walk,
+// as files could be added in the same mtime tick without us noticing
+const directoryMtimeSafetyDelay = 2 * time.Second
+
+// A file registered in the API
+type IndexedFile struct {
+	// ID of the file in the API. Zero if unknown, i.e. the file was registered since the last sync
+	Id       int       `json:"id"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mtime"`
+	Checksum string    `json:"checksum"`
+	// Empty if content hashing is disabled
+	ContentHash string `json:"content_hash,omitempty"`
+}
+
+// Content of a directory, as it was during the last walk
+type IndexedDirectory struct {
+	ModTime     time.Time `json:"mtime"`
+	Files       []string  `json:"files"`
+	Directories []string  `json:"directories"`
+}
+
+// Local copy of the files registered in the API and of the content of the libraries
+// Allows scans and cleans to only read the directories that changed
+type FileIndex struct {
+	filePath string
+	// Key is the full path of the file
+	Files map[string]IndexedFile `json:"files"`
+	// Key is the full path of the directory
+	Directories map[string]IndexedDirectory `json:"directories"`
+	// When the registered files of each library were last fetched from the API. Key is the ID of the library
+	SyncedAt map[int]time.Time `json:"synced_at"`
+	mu       sync.Mutex
+}
+
+func newEmptyFileIndex(filePath string) *FileIndex {
+	return &FileIndex{
+		filePath:    filePath,
+		Files:       map[string]IndexedFile{},
+		Directories: map[string]IndexedDirectory{},
+		SyncedAt:    map[int]time.Time{},
+	}
+}
+
+// Loads the index from the given directory
+// If the file does not exist or is malformed, the index is empty
+func NewFileIndex(directory string) *FileIndex {
+	filePath := path.Join(directory, FileIndexFileName)
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return newEmptyFileIndex(filePath)
+	}
+	index := newEmptyFileIndex(filePath)
+	if err := json.Unmarshal(content, index); err != nil {
+		log.Warn().Msg("Ignoring malformed file index")
+		return newEmptyFileIndex(filePath)
+	}
+	return index
+}
+
+func (i *FileIndex) Save() error {
+	// Tasks of different lanes can save the index at the same time
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	serialized, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+	// Write then rename, so that the index is never half-written
+	tmpPath := i.filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, serialized, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, i.filePath)
+}
+
+// Fetches the registered files of the library from the API,
+// unless it was done less than FileIndexSyncInterval ago and the API has as many files as the index
+// If the API is down, the local copy is used, even if it is outdated
+func (i *FileIndex) syncLibrary(ctx context.Context, library api.Library, c config.Config) error {
+	libraryRoot := path.Join(c.DataDirectory, library.Path)
+	i.mu.Lock()
+	syncedAt, isSynced := i.SyncedAt[library.Id]
+	i.mu.Unlock()
+	if isSynced && time.Since(syncedAt) < FileIndexSyncInterval {
+		// Files could have been deleted or registered by something else than the scanner
+		hasFileCount, err := api.HasFileCount(ctx, api.FileSelectorDto{Library: library.Slug}, len(i.getRegisteredFiles(libraryRoot)), c)
+		if hasFileCount || api.IsDown(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		log.Debug().Str("library", library.Slug).Msg("The API does not have the same files as the local file index")
+	}
+	err := i.forceSyncLibrary(ctx, library, c)
+	if isSynced && api.IsDown(err) {
+		log.Warn().Str("library", library.Slug).Msg("API is unavailable. Using the local file index")
+		return nil
+	}
+	return err
+}
+
+func (i *FileIndex) forceSyncLibrary(ctx context.Context, library api.Library, c config.Config) error {
+	registeredFiles, err := api.GetAllFiles(ctx, api.FileSelectorDto{Library: library.Slug}, c)
+	if err != nil {
+		return err
+	}
+	libraryRoot := path.Join(c.DataDirectory, library.Path)
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	previousFiles := map[string]IndexedFile{}
+	for filePath, file := range i.Files {
+		if isInDirectory(filePath, libraryRoot) {
+			previousFiles[filePath] = file
+			delete(i.Files, filePath)
+		}
+	}
+	for _, registeredFile := range registeredFiles {
+		filePath := path.Join(libraryRoot, registeredFile.Path)
+		indexedFile := IndexedFile{Id: registeredFile.Id, Checksum: registeredFile.Checksum}
+		// The local data is still valid if the file did not change
+		if previousFile, found := previousFiles[filePath]; found && previousFile.Checksum == registeredFile.Checksum {
+			indexedFile.Size = previousFile.Size
+			indexedFile.ModTime = previousFile.ModTime
+			indexedFile.ContentHash = previousFile.ContentHash
+		}
+		i.Files[filePath] = indexedFile
+	}
+	i.SyncedAt[library.Id] = time.Now()
+	return nil
+}
+
+func (i *FileIndex) isRegistered(filePath string) bool {
+	_, isRegistered := i.getFile(filePath)
+	return isRegistered
+}
+
+func (i *FileIndex) getFile(filePath string) (IndexedFile, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	file, isRegistered := i.Files[filePath]
+	return file, isRegistered
+}
+
+// Returns the registered files of the directory that are not in the given set of full paths, by content hash
+// Their path is relative to the directory. Files without a content hash are ignored
+func (i *FileIndex) getMissingFilesByContentHash(directory string, filesInDir map[string]bool) map[string]api.File {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	missingFiles := map[string]api.File{}
+	for filePath, file := range i.Files {
+		if len(file.ContentHash) > 0 && isInDirectory(filePath, directory) && !filesInDir[filePath] {
+			missingFiles[file.ContentHash] = api.File{
+				Id:       file.Id,
+				Path:     strings.TrimPrefix(filePath, directory+"/"),
+				Checksum: file.Checksum,
+			}
+		}
+	}
+	return missingFiles
+}
+
+// Returns the registered files in the directory, with their path relative to it
+func (i *FileIndex) getRegisteredFiles(directory string) []api.File {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	files := []api.File{}
+	for filePath, file := range i.Files {
+		if isInDirectory(filePath, directory) {
+			files = append(files, api.File{
+				Id:       file.Id,
+				Path:     strings.TrimPrefix(filePath, directory+"/"),
+				Checksum: file.Checksum,
+			})
+		}
+	}
+	return files
+}
+
+// Returns the registered files of the set whose size or mtime changed since they were registered
+// Files synced from the API have neither, so their current ones are kept for the next walk
+func (i *FileIndex) getModifiedFiles(filesInDir map[string]bool) []string {
+	modifiedFiles := []string{}
+	for filePath := range filesInDir {
+		file, isRegistered := i.getFile(filePath)
+		if !isRegistered {
+			continue
+		}
+		stat, err := os.Stat(filePath)
+		if err != nil {
+			continue
+		}
+		if file.ModTime.IsZero() {
+			i.mu.Lock()
+			file.Size = stat.Size()
+			file.ModTime = stat.ModTime()
+			i.Files[filePath] = file
+			i.mu.Unlock()
+		} else if file.Size != stat.Size() || !file.ModTime.Equal(stat.ModTime()) {
+			modifiedFiles = append(modifiedFiles, filePath)
+		}
+	}
+	sort.Strings(modifiedFiles)
+	return modifiedFiles
+}
+
+// To call once the file is registered or updated in the API
+// The content hash can be empty
+func (i *FileIndex) setRegistered(filePath string, checksum string, contentHash string) {
+	var size int64
+	var modTime time.Time
+	if stat, err := os.Stat(filePath); err == nil {
+		size = stat.Size()
+		modTime = stat.ModTime()
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.Files[filePath] = IndexedFile{
+		// Keep the ID if the file was already registered
+		Id:          i.Files[filePath].Id,
+		Size:        size,
+		ModTime:     modTime,
+		Checksum:    checksum,
+		ContentHash: contentHash,
+	}
+}
+
+// To call if the file could not be registered after all
+func (i *FileIndex) setUnregistered(filePath string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	delete(i.Files, filePath)
+}
+
+// To call once the path of the file is updated in the API
+func (i *FileIndex) setMoved(oldPath string, newPath string, checksum string) {
+	i.mu.Lock()
+	file := i.Files[oldPath]
+	delete(i.Files, oldPath)
+	// So that the ID is kept
+	i.Files[newPath] = file
+	i.mu.Unlock()
+	i.setRegistered(newPath, checksum, file.ContentHash)
+}
+
+func (i *FileIndex) setContentHash(filePath string, contentHash string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if file, isRegistered := i.Files[filePath]; isRegistered {
+		file.ContentHash = contentHash
+		i.Files[filePath] = file
+	}
+}
+
+// To call once the files are deleted from the API
+func (i *FileIndex) setDeleted(fileIds []int) {
+	deletedIds := map[int]bool{}
+	for _, fileId := range fileIds {
+		deletedIds[fileId] = true
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for filePath, file := range i.Files {
+		if deletedIds[file.Id] {
+			delete(i.Files, filePath)
+		}
+	}
+}
+
+// Returns the set of the full paths of the files in the directory
+// Only the directories whose mtime changed since the last walk are read
+func (i *FileIndex) walk(root string) (map[string]bool, error) {
+	files := map[string]bool{}
+	visitedDirectories := map[string]bool{}
+	if err := i.walkDirectory(root, files, visitedDirectories); err != nil {
+		return files, err
+	}
+	// Forget the directories that do not exist anymore
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for directory := range i.Directories {
+		if (directory == root || isInDirectory(directory, root)) && !visitedDirectories[directory] {
+			delete(i.Directories, directory)
+		}
+	}
+	return files, nil
+}
+
+func (i *FileIndex) walkDirectory(directory string, files map[string]bool, visitedDirectories map[string]bool) error {
+	stat, err := os.Stat(directory)
+	if err != nil {
+		return err
+	}
+	visitedDirectories[directory] = true
+	i.mu.Lock()
+	indexedDirectory, isIndexed := i.Directories[directory]
+	i.mu.Unlock()
+	if !isIndexed || !indexedDirectory.ModTime.Equal(stat.ModTime()) {
+		indexedDirectory, err = readDirectory(directory, stat.ModTime())
+		if err != nil {
+			return err
+		}
+		i.mu.Lock()
+		i.Directories[directory] = indexedDirectory
+		i.mu.Unlock()
+	}
+	for _, file := range indexedDirectory.Files {
+		files[path.Join(directory, file)] = true
+	}
+	for _, subdirectory := range indexedDirectory.Directories {
+		if err := i.walkDirectory(path.Join(directory, subdirectory), files, visitedDirectories); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readDirectory(directory string, modTime time.Time) (IndexedDirectory, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return IndexedDirectory{}, err
+	}
+	indexedDirectory := IndexedDirectory{ModTime: modTime, Files: []string{}, Directories: []string{}}
+	if time.Since(modTime) < directoryMtimeSafetyDelay {
+		// Will never match, so the directory will be read again
+		indexedDirectory.ModTime = time.Time{}
+	}
+	for _, entry := range entries {
+		// Follows symlinks
+		stat, err := os.Stat(path.Join(directory, entry.Name()))
+		if err != nil {
+			return IndexedDirectory{}, err
+		}
+		if stat.IsDir() {
+			indexedDirectory.Directories = append(indexedDirectory.Directories, entry.Name())
+		} else {
+			indexedDirectory.Files = append(indexedDirectory.Files, entry.Name())
+		}
+	}
+	return indexedDirectory, nil
+}
+
+func isInDirectory(filePath string, directory string) bool {
+	return strings.HasPrefix(filePath, directory+"/")
+}
@@ -0,0 +1,97 @@
+package tasks
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Creates the file, and sets the mtime of its directory in the past
+func createIndexTestFile(t *testing.T, filePath string) {
+	assert.Nil(t, os.MkdirAll(path.Dir(filePath), 0755))
+	assert.Nil(t, os.WriteFile(filePath, []byte{}, 0644))
+	setMtimeInPast(t, path.Dir(filePath))
+}
+
+func setMtimeInPast(t *testing.T, filePath string) {
+	past := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Nil(t, os.Chtimes(filePath, past, past))
+}
+
+func TestFileIndexWalkOnlyReadsChangedDirectories(t *testing.T) {
+	root := t.TempDir()
+	createIndexTestFile(t, path.Join(root, "a", "1.mp3"))
+	createIndexTestFile(t, path.Join(root, "b", "2.mp3"))
+	setMtimeInPast(t, root)
+	index := NewFileIndex(t.TempDir())
+
+	files, err := index.walk(root)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{
+		path.Join(root, "a", "1.mp3"): true,
+		path.Join(root, "b", "2.mp3"): true,
+	}, files)
+
+	// The mtime of 'a' does not change, so it should not be read again
+	createIndexTestFile(t, path.Join(root, "a", "3.mp3"))
+	// 'b' changed
+	assert.Nil(t, os.WriteFile(path.Join(root, "b", "4.mp3"), []byte{}, 0644))
+	files, err = index.walk(root)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{
+		path.Join(root, "a", "1.mp3"): true,
+		path.Join(root, "b", "2.mp3"): true,
+		path.Join(root, "b", "4.mp3"): true,
+	}, files)
+
+	assert.Nil(t, os.RemoveAll(path.Join(root, "b")))
+	files, err = index.walk(root)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{path.Join(root, "a", "1.mp3"): true}, files)
+	assert.NotContains(t, index.Directories, path.Join(root, "b"))
+}
+
+func TestFileIndexIsPersisted(t *testing.T) {
+	dir := t.TempDir()
+	index := NewFileIndex(dir)
+	index.Files["/data/library/a.mp3"] = IndexedFile{Id: 1, Checksum: "a"}
+	index.Files["/data/library/b/c.mp3"] = IndexedFile{Id: 2, Checksum: "c"}
+	index.Files["/data/other/d.mp3"] = IndexedFile{Id: 3, Checksum: "d"}
+	index.setDeleted([]int{1})
+	assert.Nil(t, index.Save())
+
+	reloaded := NewFileIndex(dir)
+	assert.False(t, reloaded.isRegistered("/data/library/a.mp3"))
+	assert.True(t, reloaded.isRegistered("/data/library/b/c.mp3"))
+	files := reloaded.getRegisteredFiles("/data/library")
+	assert.Len(t, files, 1)
+	assert.Equal(t, "b/c.mp3", files[0].Path)
+	assert.Equal(t, 2, files[0].Id)
+}
+
+func TestFileIndexDetectsModifiedFiles(t *testing.T) {
+	root := t.TempDir()
+	unchanged := path.Join(root, "a.mp3")
+	modified := path.Join(root, "b.mp3")
+	synced := path.Join(root, "c.mp3")
+	for _, filePath := range []string{unchanged, modified, synced} {
+		createIndexTestFile(t, filePath)
+		setMtimeInPast(t, filePath)
+	}
+	index := NewFileIndex(t.TempDir())
+	index.setRegistered(unchanged, "a", "")
+	index.setRegistered(modified, "b", "")
+	// As if the file was synced from the API
+	index.Files[synced] = IndexedFile{Id: 3, Checksum: "c"}
+	filesInDir := map[string]bool{unchanged: true, modified: true, synced: true}
+
+	assert.Nil(t, os.WriteFile(modified, []byte{1}, 0644))
+	assert.Equal(t, []string{modified}, index.getModifiedFiles(filesInDir))
+
+	// The size and mtime of the synced file are now known
+	assert.Nil(t, os.WriteFile(synced, []byte{1}, 0644))
+	assert.Equal(t, []string{modified, synced}, index.getModifiedFiles(filesInDir))
+}
@@ -0,0 +1,170 @@
+package tasks
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/rs/zerolog/log"
+)
+
+// Name of the JSON-lines file, in the config directory
+const TaskHistoryFileName = "tasks_history.jsonl"
+
+// Maximum number of finished tasks to keep
+const MaxTaskHistoryLength = 500
+
+type TaskStatus string
+
+const (
+	Pending TaskStatus = "pending"
+	Running TaskStatus = "running"
+	Done    TaskStatus = "done"
+	Failed  TaskStatus = "failed"
+	// The task was cancelled, be it while pending or running
+	Cancelled TaskStatus = "cancelled"
+)
+
+type FileFailure struct {
+	Path   string   `json:"path"`
+	Errors []string `json:"errors"`
+}
+
+// Outcome of the files processed by a task
+type TaskReport struct {
+	// Number of files successfully registered, updated or cleaned
+	Successful int `json:"successful"`
+	Failed     int `json:"failed"`
+	Skipped    int `json:"skipped"`
+	// Files that could not be processed, with the related errors
+	FailedFiles []FileFailure `json:"failed_files"`
+}
+
+// Snapshot of the state and outcome of a task
+type TaskRecord struct {
+	Id     string     `json:"id"`
+	Name   string     `json:"name"`
+	Lane   TaskLane   `json:"lane"`
+	Status TaskStatus `json:"status"`
+	// Between 0 and 100. For failed and cancelled tasks, the progress when they stopped
+	Progress  int        `json:"progress"`
+	StartedAt *time.Time `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at"`
+	// Error returned by the task, if it failed
+	Error  *string    `json:"error"`
+	Report TaskReport `json:"report"`
+}
+
+// Keeps track of finished tasks, and persists them in a JSON-lines file
+type History struct {
+	filePath string
+	records  []TaskRecord
+	mu       sync.Mutex
+}
+
+// Loads the history from the given directory
+// If the file does not exist, the history is empty
+func NewHistory(directory string) *History {
+	h := &History{filePath: path.Join(directory, TaskHistoryFileName)}
+	file, err := os.Open(h.filePath)
+	if err != nil {
+		return h
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	// The list of failed files can make lines long
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var record TaskRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			log.Warn().Msg("Ignoring malformed entry in task history")
+			continue
+		}
+		h.records = append(h.records, record)
+	}
+	if len(h.records) > MaxTaskHistoryLength {
+		h.records = h.records[len(h.records)-MaxTaskHistoryLength:]
+		if err := h.rewrite(); err != nil {
+			log.Error().Err(err).Msg("Could not rewrite task history file")
+		}
+	}
+	return h
+}
+
+func (h *History) Add(record TaskRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, record)
+	if len(h.records) > 2*MaxTaskHistoryLength {
+		// Compact the file once in a while, instead of everytime
+		h.records = h.records[len(h.records)-MaxTaskHistoryLength:]
+		if err := h.rewrite(); err != nil {
+			log.Error().Err(err).Msg("Could not rewrite task history file")
+		}
+		return
+	}
+	file, err := os.OpenFile(h.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Error().Err(err).Msg("Could not open task history file")
+		return
+	}
+	defer file.Close()
+	if err := writeRecord(file, record); err != nil {
+		log.Error().Err(err).Msg("Could not save task in history")
+	}
+}
+
+// Returns the finished tasks, most recent first
+func (h *History) GetAll() []TaskRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	records := make([]TaskRecord, 0, len(h.records))
+	for i := len(h.records) - 1; i >= 0; i-- {
+		records = append(records, h.records[i])
+	}
+	return records
+}
+
+func (h *History) Get(id string) (TaskRecord, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, record := range h.records {
+		if record.Id == id {
+			return record, true
+		}
+	}
+	return TaskRecord{}, false
+}
+
+// Overwrites the file with the records in memory
+// Should be called while holding the lock
+func (h *History) rewrite() error {
+	// Write then rename, so that the history is never half-written
+	tmpPath := h.filePath + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	for _, record := range h.records {
+		if err := writeRecord(file, record); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, h.filePath)
+}
+
+func writeRecord(file *os.File, record TaskRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
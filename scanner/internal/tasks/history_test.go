@@ -0,0 +1,53 @@
+package tasks
+
+import (
+	"fmt"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistoryIsPersisted(t *testing.T) {
+	dir := t.TempDir()
+	startedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := NewHistory(dir)
+	h.Add(TaskRecord{Id: "1", Name: "First", Status: Done, StartedAt: &startedAt})
+	h.Add(TaskRecord{
+		Id:     "2",
+		Name:   "Second",
+		Status: Failed,
+		Report: TaskReport{
+			Failed:      1,
+			FailedFiles: []FileFailure{{Path: "/data/a.mp3", Errors: []string{"oops"}}},
+		},
+	})
+
+	reloaded := NewHistory(dir)
+	records := reloaded.GetAll()
+	assert.Len(t, records, 2)
+	assert.Equal(t, "2", records[0].Id)
+	assert.Equal(t, "1", records[1].Id)
+	assert.Equal(t, startedAt, *records[1].StartedAt)
+
+	record, found := reloaded.Get("2")
+	assert.True(t, found)
+	assert.Equal(t, Failed, record.Status)
+	assert.Equal(t, []string{"oops"}, record.Report.FailedFiles[0].Errors)
+}
+
+func TestHistoryIsCompacted(t *testing.T) {
+	dir := t.TempDir()
+	h := NewHistory(dir)
+	for i := range 2*MaxTaskHistoryLength + 1 {
+		h.Add(TaskRecord{Id: fmt.Sprint(i), Status: Done})
+	}
+
+	reloaded := NewHistory(dir)
+	records := reloaded.GetAll()
+	assert.Len(t, records, MaxTaskHistoryLength)
+	assert.Equal(t, fmt.Sprint(2*MaxTaskHistoryLength), records[0].Id)
+	// The file is rewritten through a temporary file
+	assert.NoFileExists(t, path.Join(dir, TaskHistoryFileName+".tmp"))
+}
@@ -5,26 +5,35 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/Arthi-chaud/Meelo/scanner/internal"
 	"github.com/Arthi-chaud/Meelo/scanner/internal/api"
 	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
 	"github.com/Arthi-chaud/Meelo/scanner/internal/illustration"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/metrics"
 	"gopkg.in/vansante/go-ffprobe.v2"
 )
 
-func SaveThumbnail(t ThumbnailTask, c config.Config) error {
+func SaveThumbnail(ctx context.Context, t ThumbnailTask, c config.Config) error {
+	thumbnailbytes, err := extractThumbnail(ctx, t, c)
+	if err != nil {
+		return err
+	}
+	return api.PostIllustration(ctx, c, t.TrackId, api.Thumbnail, thumbnailbytes)
+}
+
+func extractThumbnail(ctx context.Context, t ThumbnailTask, c config.Config) ([]byte, error) {
+	defer metrics.ObserveDuration(metrics.ThumbnailExtractionDuration, time.Now())
 	if c.UserSettings.UseEmbeddedThumbnails {
 		// Try to extract the embedded illustration
-		ctx, cancelFn := context.WithCancel(context.Background())
-		defer cancelFn()
 		probeData, err := ffprobe.ProbeURL(ctx, t.FilePath)
 		if err == nil {
 			streamIndex := illustration.GetEmbeddedIllustrationStreamIndex(*probeData)
 			if streamIndex >= 0 {
-				thumbnailbytes, err := illustration.ExtractEmbeddedIllustration(t.FilePath, streamIndex)
+				thumbnailbytes, err := illustration.ExtractEmbeddedIllustration(ctx, t.FilePath, streamIndex)
 				if err == nil {
-					return api.PostIllustration(c, t.TrackId, api.Thumbnail, thumbnailbytes)
+					return thumbnailbytes, nil
 				}
 			}
 		}
@@ -36,20 +45,16 @@ func SaveThumbnail(t ThumbnailTask, c config.Config) error {
 		t.TrackDuration = 5 // this is abitrary. If the scan os path only, we do not get the duration.
 	}
 
-	thumbnailbytes, err := illustration.GetFrame(t.FilePath, thumbnailPosition)
-	if err != nil {
-		return err
-	}
-	return api.PostIllustration(c, t.TrackId, api.Thumbnail, thumbnailbytes)
+	return illustration.GetFrame(ctx, t.FilePath, thumbnailPosition)
 }
 
-func SaveIllustration(t IllustrationTask, c config.Config) error {
+func SaveIllustration(ctx context.Context, t IllustrationTask, c config.Config) error {
 	var bytes []byte
 	var err error
 
 	switch t.IllustrationLocation {
 	case internal.Embedded:
-		bytes, err = illustration.ExtractEmbeddedIllustration(t.TrackPath, t.IllustrationStreamIndex)
+		bytes, err = illustration.ExtractEmbeddedIllustration(ctx, t.TrackPath, t.IllustrationStreamIndex)
 		if err != nil {
 			return errors.Join(fmt.Errorf("an error occured while extracting embedded illustration"), err)
 		}
@@ -61,5 +66,5 @@ func SaveIllustration(t IllustrationTask, c config.Config) error {
 	default:
 		return fmt.Errorf("invalid illustration source: %s", string(t.IllustrationLocation))
 	}
-	return api.PostIllustration(c, t.TrackId, api.Cover, bytes)
+	return api.PostIllustration(ctx, c, t.TrackId, api.Cover, bytes)
 }
@@ -0,0 +1,125 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/api"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/filesystem"
+	"github.com/rs/zerolog/log"
+)
+
+// Creates a task that only handles the given paths of a library
+// New files are registered, modified files are refreshed and missing files are cleaned
+// Paths are expected to be absolute. They can point to directories.
+func NewIncrementalScanTask(library api.Library, changedPaths []string, c config.Config) Task {
+	name := fmt.Sprintf("Scan %d changed path(s) in library '%s'", len(changedPaths), library.Slug)
+	return createTask(name, func(ctx context.Context, w *Worker) error {
+		return execIncrementalScan(ctx, library, changedPaths, c, w)
+	}).inLane(InteractiveLane).forLibrary(library.Slug)
+}
+
+func execIncrementalScan(ctx context.Context, library api.Library, changedPaths []string, c config.Config, w *Worker) error {
+	unlock, err := w.lockLibraries(ctx, []int{library.Id})
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	libraryRoot := path.Join(c.DataDirectory, library.Path)
+	registeredFiles, err := api.GetAllFiles(ctx, api.FileSelectorDto{Library: library.Slug}, c)
+	if api.IsDown(err) {
+		log.Ctx(ctx).Warn().Msg("API is unavailable. Using the local file index")
+		registeredFiles, err = w.index.getRegisteredFiles(libraryRoot), nil
+	}
+	if err != nil {
+		return err
+	}
+	registeredFilesByPath := map[string]api.File{}
+	for _, registeredFile := range registeredFiles {
+		registeredFilesByPath[path.Join(libraryRoot, registeredFile.Path)] = registeredFile
+	}
+
+	filesToRegister := []string{}
+	filesToRefresh := []string{}
+	// Changed paths can overlap
+	seenFiles := map[string]bool{}
+	filesToClean := []api.File{}
+	for _, changedPath := range changedPaths {
+		stat, err := os.Stat(changedPath)
+		if err != nil {
+			// The path does not exist anymore
+			// It could have been a file or a directory
+			for registeredPath, registeredFile := range registeredFilesByPath {
+				if registeredPath == changedPath || strings.HasPrefix(registeredPath, changedPath+"/") {
+					filesToClean = append(filesToClean, registeredFile)
+					delete(registeredFilesByPath, registeredPath)
+				}
+			}
+			continue
+		}
+		filesInPath := []string{changedPath}
+		if stat.IsDir() {
+			filesInPath, err = filesystem.GetAllFilesInDirectory(changedPath)
+			if err != nil {
+				log.Ctx(ctx).Error().Str("path", changedPath).Err(err).Msg("Could not read directory")
+				continue
+			}
+		}
+		for _, fileInPath := range filterMediaFiles(ctx, filesInPath) {
+			if seenFiles[fileInPath] {
+				continue
+			}
+			seenFiles[fileInPath] = true
+			if _, isRegistered := registeredFilesByPath[fileInPath]; isRegistered {
+				filesToRefresh = append(filesToRefresh, fileInPath)
+			} else {
+				filesToRegister = append(filesToRegister, fileInPath)
+			}
+		}
+	}
+
+	if c.UserSettings.ContentHash && len(filesToClean) > 0 && len(filesToRegister) > 0 {
+		// Renaming a file gives an event for both paths
+		missingFiles := map[string]api.File{}
+		for _, fileToClean := range filesToClean {
+			if indexedFile, _ := w.index.getFile(path.Join(libraryRoot, fileToClean.Path)); len(indexedFile.ContentHash) > 0 {
+				missingFiles[indexedFile.ContentHash] = fileToClean
+			}
+		}
+		var movedFiles []api.File
+		filesToRegister, movedFiles, err = moveMatchingFiles(ctx, libraryRoot, missingFiles, filesToRegister, c, w)
+		if err != nil {
+			return err
+		}
+		filesToClean = internal.Filter(filesToClean, func(f api.File) bool {
+			return !internal.Contains(movedFiles, f)
+		})
+	}
+	successfulClean := 0
+	if len(filesToClean) > 0 {
+		successfulClean = DeleteFilesInApi(ctx, filesToClean, c, w)
+	}
+	successfulUpdates := 0
+	for i, fileToRefresh := range filesToRefresh {
+		if err := w.checkpoint(ctx); err != nil {
+			return err
+		}
+		w.SetProgress(ctx, i, len(filesToRefresh)+len(filesToRegister))
+		if refreshFile(ctx, fileToRefresh, registeredFilesByPath[fileToRefresh], false, c, w) == refreshSucceeded {
+			successfulUpdates++
+		}
+	}
+	successfulRegistrations, err := scanAndPostFiles(ctx, filesToRegister, c, w)
+	log.Ctx(ctx).Info().
+		Str("registered", strconv.Itoa(successfulRegistrations)).
+		Str("updated", strconv.Itoa(successfulUpdates)).
+		Str("cleaned", strconv.Itoa(successfulClean)).
+		Msg("Finished processing changed files")
+	return err
+}
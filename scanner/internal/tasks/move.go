@@ -0,0 +1,132 @@
+package tasks
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/api"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/parser"
+	"github.com/rs/zerolog/log"
+)
+
+// Looks for new files that have the same content as registered files that are missing,
+// and updates the path of the latter in the API instead of registering the new files
+// The metadata of moved files is parsed again, as it may come from their path
+// Returns the new files that are not moved files
+func moveRenamedFiles(ctx context.Context, library api.Library, filesInDir map[string]bool, newFiles []string, c config.Config, w *Worker) ([]string, error) {
+	libraryRoot := path.Join(c.DataDirectory, library.Path)
+	missingFiles := w.index.getMissingFilesByContentHash(libraryRoot, filesInDir)
+	if len(missingFiles) == 0 || len(newFiles) == 0 {
+		return newFiles, nil
+	}
+	for _, missingFile := range missingFiles {
+		if missingFile.Id == 0 {
+			// The file was registered since the last sync, we need its ID
+			if err := w.index.forceSyncLibrary(ctx, library, c); err != nil {
+				return newFiles, err
+			}
+			missingFiles = w.index.getMissingFilesByContentHash(libraryRoot, filesInDir)
+			break
+		}
+	}
+	filesToRegister, _, err := moveMatchingFiles(ctx, libraryRoot, missingFiles, newFiles, c, w)
+	return filesToRegister, err
+}
+
+// Updates the path of the missing files that have the same content as new files
+// Key of missingFiles is the content hash. Their paths are relative to the library
+// Returns the new files that are not moved files, and the missing files that were moved
+func moveMatchingFiles(ctx context.Context, libraryRoot string, missingFiles map[string]api.File, newFiles []string, c config.Config, w *Worker) ([]string, []api.File, error) {
+	filesToRegister := []string{}
+	movedFiles := []api.File{}
+	if len(missingFiles) == 0 {
+		return newFiles, movedFiles, nil
+	}
+	for _, newFile := range newFiles {
+		if err := w.checkpoint(ctx); err != nil {
+			return newFiles, movedFiles, err
+		}
+		contentHash, err := internal.ComputeContentHash(newFile)
+		missingFile, isMoved := missingFiles[contentHash]
+		if err != nil || !isMoved {
+			filesToRegister = append(filesToRegister, newFile)
+			continue
+		}
+		// Two new files could have the same content
+		delete(missingFiles, contentHash)
+		if err := moveFile(ctx, libraryRoot, missingFile, newFile, c, w); err != nil {
+			log.Ctx(withFileLogger(ctx, newFile, c)).Warn().
+				Err(err).
+				Msg("Could not move file. It will be registered again")
+			filesToRegister = append(filesToRegister, newFile)
+			continue
+		}
+		movedFiles = append(movedFiles, missingFile)
+	}
+	return filesToRegister, movedFiles, nil
+}
+
+func moveFile(ctx context.Context, libraryRoot string, file api.File, newPath string, c config.Config, w *Worker) error {
+	previousPath := path.Join(libraryRoot, file.Path)
+	if dryRunReport := w.getDryRunReport(ctx); dryRunReport != nil {
+		dryRunReport.addMovedFile(previousPath, newPath)
+		w.reportSuccess(ctx)
+		return nil
+	}
+	checksum, err := internal.ComputeChecksum(newPath)
+	if err != nil {
+		return err
+	}
+	// The API expects the path relative to the library
+	if err := api.MoveFile(ctx, c, file.Id, strings.TrimPrefix(newPath, libraryRoot+"/"), checksum); err != nil {
+		return err
+	}
+	w.index.setMoved(previousPath, newPath, checksum)
+	log.Ctx(ctx).Info().
+		Str("from", path.Base(previousPath)).
+		Str("to", path.Base(newPath)).
+		Msg("File moved")
+	// The file is moved in the API anyway, so it is not registered again
+	if errs := updateMovedFileMetadata(withFileLogger(ctx, newPath, c), newPath, c, w); len(errs) > 0 {
+		w.reportFailure(ctx, newPath, errs...)
+		return nil
+	}
+	w.reportSuccess(ctx)
+	return nil
+}
+
+// e.g. if the file moved to another album directory, its album may have changed
+func updateMovedFileMetadata(ctx context.Context, filePath string, c config.Config, w *Worker) []error {
+	logger := log.Ctx(ctx)
+	m, errs := parser.ParseMetadata(ctx, c.UserSettings, filePath)
+	if len(errs) > 0 {
+		logger.Error().Errs("errors", errs).Msg("Parsing failed")
+		return errs
+	}
+	w.reportFileParsed(ctx, filePath)
+	if err := pushMetadata(ctx, filePath, m, c, w, api.Update); err != nil {
+		logger.Error().Err(err).Msg("Could not update metadata")
+		return []error{err}
+	}
+	return nil
+}
+
+// Computes the content hash of the registered files that do not have one
+func computeMissingContentHashes(ctx context.Context, filesInDir map[string]bool, w *Worker) error {
+	for filePath := range filesInDir {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		file, isRegistered := w.index.getFile(filePath)
+		if !isRegistered || len(file.ContentHash) > 0 {
+			continue
+		}
+		if contentHash, err := internal.ComputeContentHash(filePath); err == nil {
+			w.index.setContentHash(filePath, contentHash)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package tasks
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/api"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMovedFilesAreDetected(t *testing.T) {
+	dataDirectory := t.TempDir()
+	library := api.Library{Id: 1, Slug: "library", Path: "library"}
+	libraryRoot := path.Join(dataDirectory, library.Path)
+	movedFile := path.Join(libraryRoot, "new.mp3")
+	newFile := path.Join(libraryRoot, "other.mp3")
+	assert.Nil(t, os.MkdirAll(libraryRoot, 0755))
+	assert.Nil(t, os.WriteFile(movedFile, []byte("moved"), 0644))
+	assert.Nil(t, os.WriteFile(newFile, []byte("new"), 0644))
+	contentHash, err := internal.ComputeContentHash(movedFile)
+	assert.Nil(t, err)
+
+	w := getTestWorker(t)
+	ctx, run := startTestRun(w, createTask("Task", nil).AsDryRun())
+	w.index.Files[path.Join(libraryRoot, "old.mp3")] = IndexedFile{Id: 1, Checksum: "a", ContentHash: contentHash}
+	filesInDir := map[string]bool{movedFile: true, newFile: true}
+	c := config.Config{DataDirectory: dataDirectory}
+
+	filesToRegister, err := moveRenamedFiles(ctx, library, filesInDir, []string{movedFile, newFile}, c, w)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{newFile}, filesToRegister)
+	assert.Equal(t, []DryRunEntry{{Path: movedFile, PreviousPath: path.Join(libraryRoot, "old.mp3")}}, run.dryRunReport.Moved)
+}
+
+func TestMovedFilesAreUpdatedInApi(t *testing.T) {
+	requests := make(chan string, 10)
+	var body api.FileMoveDto
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.Method + " " + r.URL.Path
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+		w.Write([]byte(`{"id":1}`))
+	}))
+	defer server.Close()
+	dataDirectory := t.TempDir()
+	library := api.Library{Id: 1, Slug: "library", Path: "library"}
+	libraryRoot := path.Join(dataDirectory, library.Path)
+	movedFile := path.Join(libraryRoot, "Artist", "new.mp3")
+	assert.Nil(t, os.MkdirAll(path.Dir(movedFile), 0755))
+	assert.Nil(t, os.WriteFile(movedFile, []byte("moved"), 0644))
+	contentHash, err := internal.ComputeContentHash(movedFile)
+	assert.Nil(t, err)
+	checksum, err := internal.ComputeChecksum(movedFile)
+	assert.Nil(t, err)
+
+	w := getTestWorker(t)
+	ctx, _ := startTestRun(w, createTask("Task", nil))
+	w.index.Files[path.Join(libraryRoot, "old.mp3")] = IndexedFile{Id: 1, Checksum: "a", ContentHash: contentHash}
+	c := config.Config{ApiUrl: server.URL, DataDirectory: dataDirectory}
+
+	filesToRegister, err := moveRenamedFiles(ctx, library, map[string]bool{movedFile: true}, []string{movedFile}, c, w)
+	assert.Nil(t, err)
+	assert.Empty(t, filesToRegister)
+	assert.Equal(t, "PUT /files/1", <-requests)
+	assert.Equal(t, api.FileMoveDto{Path: "Artist/new.mp3", Checksum: checksum}, body)
+	assert.False(t, w.index.isRegistered(path.Join(libraryRoot, "old.mp3")))
+	file, isRegistered := w.index.getFile(movedFile)
+	assert.True(t, isRegistered)
+	assert.Equal(t, 1, file.Id)
+	assert.Equal(t, contentHash, file.ContentHash)
+}
+
+func TestIncrementalScanDetectsMovedFiles(t *testing.T) {
+	requests := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.Method + " " + r.URL.Path
+		if r.Method == "GET" {
+			w.Write([]byte(`{"items":[{"id":1,"path":"old.mp3","checksum":"a","libraryId":1}],"metadata":{}}`))
+			return
+		}
+		w.Write([]byte(`{"id":1}`))
+	}))
+	defer server.Close()
+	dataDirectory := t.TempDir()
+	library := api.Library{Id: 1, Slug: "library", Path: "library"}
+	libraryRoot := path.Join(dataDirectory, library.Path)
+	movedFile := path.Join(libraryRoot, "new.mp3")
+	assert.Nil(t, os.MkdirAll(libraryRoot, 0755))
+	assert.Nil(t, os.WriteFile(movedFile, []byte("moved"), 0644))
+	contentHash, err := internal.ComputeContentHash(movedFile)
+	assert.Nil(t, err)
+
+	w := getTestWorker(t)
+	ctx, _ := startTestRun(w, createTask("Task", nil))
+	w.index.Files[path.Join(libraryRoot, "old.mp3")] = IndexedFile{Id: 1, Checksum: "a", ContentHash: contentHash}
+	c := config.Config{ApiUrl: server.URL, DataDirectory: dataDirectory}
+	c.UserSettings.ContentHash = true
+
+	err = execIncrementalScan(ctx, library, []string{path.Join(libraryRoot, "old.mp3"), movedFile}, c, w)
+	assert.Nil(t, err)
+	assert.Equal(t, "GET /files", <-requests)
+	// The file is neither deleted nor registered again
+	assert.Equal(t, "PUT /files/1", <-requests)
+	assert.Empty(t, requests)
+	assert.True(t, w.index.isRegistered(movedFile))
+}
+
+func TestMovedFileMetadataIsUpdated(t *testing.T) {
+	requests := make(chan string, 10)
+	albums := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.Method + " " + r.URL.Path
+		if r.URL.Path == "/metadata" {
+			albums <- r.FormValue("album")
+			w.Write([]byte(`{"trackId":1,"songId":2}`))
+			return
+		}
+		w.Write([]byte(`{"id":1}`))
+	}))
+	defer server.Close()
+	dataDirectory := t.TempDir()
+	library := api.Library{Id: 1, Slug: "library", Path: "library"}
+	libraryRoot := path.Join(dataDirectory, library.Path)
+	previousPath := path.Join(libraryRoot, "Artist", "Old Album (2000)", "01 Track.mp3")
+	movedFile := path.Join(libraryRoot, "Artist", "New Album (2001)", "01 Track.mp3")
+	assert.Nil(t, os.MkdirAll(path.Dir(movedFile), 0755))
+	assert.Nil(t, os.WriteFile(movedFile, []byte("moved"), 0644))
+	contentHash, err := internal.ComputeContentHash(movedFile)
+	assert.Nil(t, err)
+
+	w := getTestWorker(t)
+	ctx, run := startTestRun(w, createTask("Task", nil))
+	w.index.Files[previousPath] = IndexedFile{Id: 1, Checksum: "a", ContentHash: contentHash}
+	c := config.Config{ApiUrl: server.URL, DataDirectory: dataDirectory}
+	c.UserSettings.Metadata = config.MetadataSettings{Source: config.Path, Order: config.Only}
+	c.UserSettings.TrackRegex = []string{
+		"^([\\/\\\\]+.*)*[\\/\\\\]+(?P<AlbumArtist>.+)[\\/\\\\]+(?P<Album>.+)(\\s+\\((?P<Year>\\d{4})\\))[\\/\\\\]+(?P<Index>[0-9]+)\\s+(?P<Track>.*)\\..*$",
+	}
+
+	filesToRegister, err := moveRenamedFiles(ctx, library, map[string]bool{movedFile: true}, []string{movedFile}, c, w)
+	assert.Nil(t, err)
+	assert.Empty(t, filesToRegister)
+	assert.Equal(t, "PUT /files/1", <-requests)
+	// The album comes from the new path
+	assert.Equal(t, "PUT /metadata", <-requests)
+	assert.Equal(t, "New Album", <-albums)
+	assert.Equal(t, 1, run.report.Successful)
+	assert.Equal(t, 0, run.report.Failed)
+}
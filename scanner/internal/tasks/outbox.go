@@ -0,0 +1,325 @@
+package tasks
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/api"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/metrics"
+	"github.com/goccy/go-json"
+	"github.com/rs/zerolog/log"
+)
+
+// Name of the JSON-lines file, in the config directory, where the operations are queued while the API is down
+const OutboxFileName = "outbox.jsonl"
+
+// The outbox file is rewritten after this many operations are sent,
+// so that it does not grow forever during a long replay
+const outboxCompactionInterval = 100
+
+type OutboxOperationType string
+
+const (
+	SaveMetadataOperation     OutboxOperationType = "save-metadata"
+	SaveIllustrationOperation OutboxOperationType = "save-illustration"
+	SaveThumbnailOperation    OutboxOperationType = "save-thumbnail"
+	DeleteFilesOperation      OutboxOperationType = "delete-files"
+)
+
+// A write to the API that could not be sent
+type OutboxOperation struct {
+	Type     OutboxOperationType `json:"type"`
+	QueuedAt time.Time           `json:"queued_at"`
+	// For save-metadata operations. Full path of the file
+	Path string `json:"path,omitempty"`
+	// For save-metadata operations
+	Metadata *internal.Metadata `json:"metadata,omitempty"`
+	// For save-metadata operations
+	Method api.SaveMetadataMethod `json:"method,omitempty"`
+	// For save-illustration operations
+	Illustration *IllustrationTask `json:"illustration,omitempty"`
+	// For save-thumbnail operations
+	Thumbnail *ThumbnailTask `json:"thumbnail,omitempty"`
+	// For delete-files operations
+	FileIds []int `json:"file_ids,omitempty"`
+}
+
+// Operations waiting for the API to be reachable, persisted in a JSON-lines file
+// They are sent in the order they were queued
+type Outbox struct {
+	filePath   string
+	operations []OutboxOperation
+	// Number of operations sent since the file was last rewritten
+	sentCount int
+	mu        sync.Mutex
+}
+
+// Loads the outbox from the given directory
+// If the file does not exist, the outbox is empty
+func NewOutbox(directory string) *Outbox {
+	o := &Outbox{filePath: path.Join(directory, OutboxFileName)}
+	file, err := os.Open(o.filePath)
+	if err != nil {
+		return o
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	// Metadata with lyrics can make lines long
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var operation OutboxOperation
+		if err := json.Unmarshal(scanner.Bytes(), &operation); err != nil {
+			log.Warn().Msg("Ignoring malformed entry in outbox")
+			continue
+		}
+		o.operations = append(o.operations, operation)
+	}
+	metrics.OutboxSize.Set(float64(len(o.operations)))
+	return o
+}
+
+func (o *Outbox) IsEmpty() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.operations) == 0
+}
+
+func (o *Outbox) add(operation OutboxOperation) error {
+	if operation.QueuedAt.IsZero() {
+		operation.QueuedAt = time.Now()
+	}
+	line, err := json.Marshal(operation)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	file, err := os.OpenFile(o.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	// The operation must not be lost if the machine stops
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	o.operations = append(o.operations, operation)
+	metrics.OutboxSize.Set(float64(len(o.operations)))
+	return nil
+}
+
+// Returns the oldest operation
+func (o *Outbox) peek() (OutboxOperation, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.operations) == 0 {
+		return OutboxOperation{}, false
+	}
+	return o.operations[0], true
+}
+
+// Removes the oldest operation, once it was sent
+// If the scanner stops before the file is rewritten, the operation is sent again on the next start
+func (o *Outbox) pop() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.operations) == 0 {
+		return nil
+	}
+	o.operations = o.operations[1:]
+	o.sentCount++
+	metrics.OutboxSize.Set(float64(len(o.operations)))
+	if len(o.operations) == 0 {
+		o.sentCount = 0
+		if err := os.Remove(o.filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if o.sentCount >= outboxCompactionInterval {
+		o.sentCount = 0
+		return o.rewrite()
+	}
+	return nil
+}
+
+// Overwrites the file with the operations in memory
+// Should be called while holding the lock
+func (o *Outbox) rewrite() error {
+	tmpPath := o.filePath + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	for _, operation := range o.operations {
+		line, err := json.Marshal(operation)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		writer.Write(append(line, '\n'))
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	// Otherwise, the file could be renamed before its content is written
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, o.filePath)
+}
+
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.operations)
+}
+
+// Sends the request, or queues the operation if the API is down
+// If older operations are still queued, the request is not sent, so that the order is kept
+// Returns true if the operation was queued
+func (w *Worker) sendOrQueue(ctx context.Context, operation OutboxOperation, send func() error) (bool, error) {
+	if w.outbox.IsEmpty() {
+		err := send()
+		if !api.IsDown(err) {
+			return false, err
+		}
+	}
+	return true, w.queue(ctx, operation)
+}
+
+func (w *Worker) queue(ctx context.Context, operation OutboxOperation) error {
+	if err := w.outbox.add(operation); err != nil {
+		return err
+	}
+	log.Ctx(ctx).Warn().Str("operation", string(operation.Type)).Msg("API is unavailable. Operation queued")
+	// The API could be back already
+	if api.IsAvailable() {
+		go w.replayOutbox()
+	}
+	return nil
+}
+
+// Saves the metadata of the file, or queues it if the API is down
+// Queued files are considered registered, so that they are not parsed again
+func (w *Worker) saveMetadataOrQueue(ctx context.Context, filePath string, m internal.Metadata, c config.Config, method api.SaveMetadataMethod) (api.MetadataCreated, bool, error) {
+	var created api.MetadataCreated
+	operation := OutboxOperation{Type: SaveMetadataOperation, Path: filePath, Metadata: &m, Method: method}
+	queued, err := w.sendOrQueue(ctx, operation, func() error {
+		var err error
+		created, err = api.SaveMetadata(ctx, c, m, method)
+		return err
+	})
+	if queued && err == nil {
+		indexedFile, _ := w.index.getFile(filePath)
+		w.index.setRegistered(filePath, m.Checksum, indexedFile.ContentHash)
+	}
+	return created, queued, err
+}
+
+// Returns the number of operations waiting for the API
+func (w *Worker) GetOutboxSize() int {
+	return w.outbox.Len()
+}
+
+// Sends the queued operations in order
+// Stops at the first operation that fails because the API is down. It is resumed once the API is back
+func (w *Worker) replayOutbox() {
+	if !w.replayingOutbox.TryLock() {
+		// Already replaying
+		return
+	}
+	defer w.replayingOutbox.Unlock()
+	logger := log.With().Str("component", "outbox").Logger()
+	ctx := logger.WithContext(context.Background())
+	replayedCount := 0
+	defer func() {
+		if replayedCount == 0 {
+			return
+		}
+		logger.Info().Msgf("Sent %d queued operation(s)", replayedCount)
+		if err := w.index.Save(); err != nil {
+			logger.Error().Err(err).Msg("Could not save file index")
+		}
+	}()
+	for {
+		operation, found := w.outbox.peek()
+		if !found {
+			return
+		}
+		err := w.replay(ctx, operation)
+		if api.IsDown(err) {
+			logger.Warn().Err(err).Msg("API is still unavailable. Replay paused")
+			return
+		}
+		if err != nil {
+			// Sending it again would fail the same way
+			logger.Error().Str("operation", string(operation.Type)).Err(err).Msg("Dropping queued operation")
+		}
+		if err := w.outbox.pop(); err != nil {
+			logger.Error().Err(err).Msg("Could not update outbox")
+			return
+		}
+		replayedCount++
+	}
+}
+
+func (w *Worker) replay(ctx context.Context, operation OutboxOperation) error {
+	switch operation.Type {
+	case SaveMetadataOperation:
+		if operation.Metadata == nil {
+			return errors.New("missing metadata")
+		}
+		ctx = withFileLogger(ctx, operation.Path, w.config)
+		created, err := api.SaveMetadata(ctx, w.config, *operation.Metadata, operation.Method)
+		if errors.Is(err, api.ErrConflict) && operation.Method == api.Create {
+			// Sent before the scanner stopped, but the response was lost
+			// The file is updated instead, to get the track its illustration, lyrics and thumbnail belong to
+			created, err = api.SaveMetadata(ctx, w.config, *operation.Metadata, api.Update)
+		}
+		if err != nil {
+			if !api.IsDown(err) {
+				// So that the next scan tries again
+				w.index.setUnregistered(operation.Path)
+			}
+			return err
+		}
+		onMetadataSaved(ctx, operation.Path, *operation.Metadata, created, w.config, w)
+		return nil
+	case SaveIllustrationOperation:
+		if operation.Illustration == nil {
+			return errors.New("missing illustration")
+		}
+		return SaveIllustration(ctx, *operation.Illustration, w.config)
+	case SaveThumbnailOperation:
+		if operation.Thumbnail == nil {
+			return errors.New("missing thumbnail")
+		}
+		return SaveThumbnail(ctx, *operation.Thumbnail, w.config)
+	case DeleteFilesOperation:
+		err := api.DeleteFiles(ctx, w.config, operation.FileIds)
+		if errors.Is(err, api.ErrNotFound) {
+			// Sent before the scanner stopped
+			return nil
+		}
+		return err
+	}
+	return fmt.Errorf("unknown operation type: %s", operation.Type)
+}
@@ -0,0 +1,139 @@
+package tasks
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/api"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutboxIsPersisted(t *testing.T) {
+	dir := t.TempDir()
+	o := NewOutbox(dir)
+	assert.NoError(t, o.add(OutboxOperation{Type: DeleteFilesOperation, FileIds: []int{1, 2}}))
+	assert.NoError(t, o.add(OutboxOperation{
+		Type:     SaveMetadataOperation,
+		Path:     "/data/a.mp3",
+		Metadata: &internal.Metadata{Name: "A", Checksum: "a"},
+		Method:   api.Update,
+	}))
+
+	reloaded := NewOutbox(dir)
+	assert.Equal(t, 2, reloaded.Len())
+	operation, _ := reloaded.peek()
+	assert.Equal(t, DeleteFilesOperation, operation.Type)
+	assert.Equal(t, []int{1, 2}, operation.FileIds)
+	assert.NoError(t, reloaded.pop())
+	operation, _ = reloaded.peek()
+	assert.Equal(t, "A", operation.Metadata.Name)
+	assert.Equal(t, api.Update, operation.Method)
+
+	assert.NoError(t, reloaded.pop())
+	assert.True(t, reloaded.IsEmpty())
+	_, err := os.Stat(path.Join(dir, OutboxFileName))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestWritesAreQueuedWhileApiIsDown(t *testing.T) {
+	requests := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.Method + " " + r.URL.Path
+		w.Write([]byte(`{"trackId":1,"songId":2}`))
+	}))
+	defer server.Close()
+	c := config.Config{ApiUrl: server.URL, ConfigDirectory: t.TempDir()}
+	w := NewWorker()
+	w.StartWorker(c)
+	t.Cleanup(w.StopWorker)
+	ctx, _ := startTestRun(w, createTask("Task", nil))
+	// Prevents the outbox from being sent until we are done
+	w.replayingOutbox.Lock()
+
+	queued, err := w.sendOrQueue(ctx, OutboxOperation{Type: DeleteFilesOperation, FileIds: []int{1}}, func() error {
+		return api.ErrUnavailable
+	})
+	assert.True(t, queued)
+	assert.NoError(t, err)
+	// Older operations are queued, so the metadata is not sent right away
+	m := internal.Metadata{Name: "A", Checksum: "a", Type: internal.Audio}
+	assert.NoError(t, pushMetadata(ctx, "/data/a.mp3", m, c, w, api.Create))
+	assert.Equal(t, 2, w.GetOutboxSize())
+	assert.True(t, w.index.isRegistered("/data/a.mp3"))
+	assert.Empty(t, requests)
+
+	w.replayingOutbox.Unlock()
+	w.replayOutbox()
+
+	assert.Equal(t, "DELETE /files", <-requests)
+	assert.Equal(t, "POST /metadata", <-requests)
+	assert.Equal(t, 0, w.GetOutboxSize())
+	assert.True(t, NewOutbox(c.ConfigDirectory).IsEmpty())
+}
+
+func TestFailedQueuedMetadataIsUnregistered(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+	c := config.Config{ApiUrl: server.URL, ConfigDirectory: t.TempDir()}
+	w := NewWorker()
+	w.StartWorker(c)
+	t.Cleanup(w.StopWorker)
+	w.replayingOutbox.Lock()
+	m := internal.Metadata{Name: "A", Checksum: "a", Type: internal.Audio}
+	_, queued, err := w.saveMetadataOrQueue(context.Background(), "/data/a.mp3", m, c, api.Create)
+	assert.False(t, queued)
+	assert.Error(t, err)
+	assert.NoError(t, w.queue(context.Background(), OutboxOperation{
+		Type: SaveMetadataOperation, Path: "/data/a.mp3", Metadata: &m, Method: api.Create,
+	}))
+	w.index.setRegistered("/data/a.mp3", "a", "")
+
+	w.replayingOutbox.Unlock()
+	w.replayOutbox()
+
+	assert.Equal(t, 0, w.GetOutboxSize())
+	assert.False(t, w.index.isRegistered("/data/a.mp3"))
+}
+
+func TestConflictingQueuedMetadataIsUpdated(t *testing.T) {
+	requests := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.Method + " " + r.URL.Path
+		switch r.Method + " " + r.URL.Path {
+		case "POST /metadata":
+			w.WriteHeader(http.StatusConflict)
+		case "GET /songs/2/lyrics":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Write([]byte(`{"trackId":1,"songId":2}`))
+		}
+	}))
+	defer server.Close()
+	c := config.Config{ApiUrl: server.URL, ConfigDirectory: t.TempDir()}
+	w := NewWorker()
+	w.StartWorker(c)
+	t.Cleanup(w.StopWorker)
+	w.replayingOutbox.Lock()
+	m := internal.Metadata{Name: "A", Checksum: "a", Type: internal.Audio, Lyrics: []string{"Lyrics"}}
+	assert.NoError(t, w.queue(context.Background(), OutboxOperation{
+		Type: SaveMetadataOperation, Path: "/data/a.mp3", Metadata: &m, Method: api.Create,
+	}))
+
+	w.replayingOutbox.Unlock()
+	w.replayOutbox()
+
+	assert.Equal(t, "POST /metadata", <-requests)
+	assert.Equal(t, "PUT /metadata", <-requests)
+	assert.Equal(t, "GET /songs/2/lyrics", <-requests)
+	assert.Equal(t, "POST /songs/2/lyrics", <-requests)
+	assert.Equal(t, 0, w.GetOutboxSize())
+	assert.True(t, w.index.isRegistered("/data/a.mp3"))
+}
@@ -1,6 +1,7 @@
 package tasks
 
 import (
+	"context"
 	"fmt"
 	"path"
 	"reflect"
@@ -15,77 +16,110 @@ import (
 
 func NewMetadataRefreshTask(refreshSelector api.FileSelectorDto, force bool, c config.Config) Task {
 	name := generateTaskName(refreshSelector)
-	return createTask(name, func(w *Worker) error {
-		return execRefresh(refreshSelector, force, c, w)
-	})
+	return createTask(name, func(ctx context.Context, w *Worker) error {
+		return execRefresh(ctx, refreshSelector, force, c, w)
+	}).inLane(InteractiveLane).withKey(fmt.Sprintf("refresh:%+v:%t", refreshSelector, force)).forLibrary(refreshSelector.Library)
 }
 
-func execRefresh(refreshSelector api.FileSelectorDto, force bool, c config.Config, w *Worker) error {
+func execRefresh(ctx context.Context, refreshSelector api.FileSelectorDto, force bool, c config.Config, w *Worker) error {
 	successfulUpdates := 0
 	skippedUpdates := 0
 	failedUpdates := 0
-	libraries, err := api.GetAllLibraries(c)
+	libraries, err := api.GetAllLibraries(ctx, c)
 	if err != nil {
 		return err
 	}
-	selectedFiles, err := api.GetAllFiles(refreshSelector, c)
+	selectedFiles, err := api.GetAllFiles(ctx, refreshSelector, c)
 	if err != nil {
 		return err
 	}
+	unlock, err := w.lockLibraries(ctx, internal.Fmap(selectedFiles, func(f api.File, _ int) int {
+		return f.LibraryId
+	}))
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	selectedFilesCount := len(selectedFiles)
 	for _, selectedFile := range selectedFiles {
-		w.SetProgress(skippedUpdates+failedUpdates+successfulUpdates, selectedFilesCount)
+		if err := w.checkpoint(ctx); err != nil {
+			return err
+		}
+		w.SetProgress(ctx, skippedUpdates+failedUpdates+successfulUpdates, selectedFilesCount)
 		selectedFilePath, err := buildFullFileEntryPath(selectedFile, libraries, c)
 		if err != nil {
-			log.Error().Msg(err.Error())
+			log.Ctx(ctx).Error().Str("file", selectedFile.Path).Err(err).Msg("Could not find library of file")
+			w.reportFailure(ctx, selectedFile.Path, err)
 			failedUpdates++
 			continue
 		}
-		// If force is false, compute checksum,
-		// And then choose if when skip the file or not
-		// If force is true, avoid computing checksum
-		if force == false {
-			newChecksum, err := internal.ComputeChecksum(selectedFilePath)
-			if err != nil {
-				log.Error().Msg(err.Error())
-				failedUpdates++
-				continue
-			}
-			if newChecksum == selectedFile.Checksum {
-				skippedUpdates++
-				continue
-			}
-		}
-		log.Info().
-			Str("file", path.Base(selectedFile.Path)).
-			Msgf("Refreshing metadata")
-		// Note unlike for scan, we dont use a chan here.
-		m, errs := parser.ParseMetadata(c.UserSettings, selectedFilePath)
-		if len(errs) > 0 {
-			log.Error().
-				Str("file", path.Base(selectedFile.Path)).
-				Msgf("Parsing failed")
-			for _, err := range errs {
-				log.Trace().Msg(err.Error())
-			}
+		switch refreshFile(ctx, selectedFilePath, selectedFile, force, c, w) {
+		case refreshSkipped:
+			skippedUpdates++
+		case refreshFailed:
 			failedUpdates++
-			continue
-		}
-		err = pushMetadata(selectedFilePath, m, c, w, api.Update)
-		if err != nil {
-			log.Error().Msg(err.Error())
-			failedUpdates++
-		} else {
+		default:
 			successfulUpdates++
 		}
 	}
-	log.Info().
+	log.Ctx(ctx).Info().
 		Str("sucess", strconv.Itoa(successfulUpdates)).
 		Str("failed", strconv.Itoa(failedUpdates)).
 		Msg("Finished updating metadata")
 	return nil
 }
 
+type refreshOutcome int
+
+const (
+	refreshSucceeded refreshOutcome = iota
+	refreshSkipped
+	refreshFailed
+)
+
+// Parses the file again and pushes its metadata to the API
+// If force is false, the file is skipped if its checksum did not change
+func refreshFile(ctx context.Context, filePath string, registeredFile api.File, force bool, c config.Config, w *Worker) refreshOutcome {
+	ctx = withFileLogger(ctx, filePath, c)
+	logger := log.Ctx(ctx)
+	// If force is false, compute checksum,
+	// And then choose if when skip the file or not
+	// If force is true, avoid computing checksum
+	if force == false {
+		newChecksum, err := internal.ComputeChecksum(filePath)
+		if err != nil {
+			logger.Error().Err(err).Msg("Could not compute checksum")
+			w.reportFailure(ctx, filePath, err)
+			return refreshFailed
+		}
+		if newChecksum == registeredFile.Checksum {
+			w.reportSkip(ctx)
+			return refreshSkipped
+		}
+	}
+	logger.Info().Msg("Refreshing metadata")
+	// Note unlike for scan, we dont use a chan here.
+	m, errs := parser.ParseMetadata(ctx, c.UserSettings, filePath)
+	if ctx.Err() != nil {
+		// Parsing was interrupted, the errors are not relevant
+		return refreshFailed
+	}
+	if len(errs) > 0 {
+		logger.Error().Errs("errors", errs).Msg("Parsing failed")
+		w.reportFailure(ctx, filePath, errs...)
+		return refreshFailed
+	}
+	w.reportFileParsed(ctx, filePath)
+	err := pushMetadata(ctx, filePath, m, c, w, api.Update)
+	if err != nil {
+		logger.Error().Err(err).Msg("Could not update metadata")
+		w.reportFailure(ctx, filePath, err)
+		return refreshFailed
+	}
+	w.reportSuccess(ctx)
+	return refreshSucceeded
+}
+
 func generateTaskName(refreshSelector api.FileSelectorDto) string {
 	formattedSelector := ""
 	v := reflect.ValueOf(refreshSelector)
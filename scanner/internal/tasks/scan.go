@@ -1,98 +1,248 @@
 package tasks
 
 import (
+	"context"
 	"fmt"
-	"math"
 	"mime"
 	"path"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/Arthi-chaud/Meelo/scanner/internal"
 	"github.com/Arthi-chaud/Meelo/scanner/internal/api"
 	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
-	"github.com/Arthi-chaud/Meelo/scanner/internal/filesystem"
 	"github.com/Arthi-chaud/Meelo/scanner/internal/parser"
 	"github.com/rs/zerolog/log"
 )
 
+// Maximum number of files registered in a single request
+const MetadataBatchSize = 50
+
 func NewLibraryScanTask(library api.Library, c config.Config) Task {
 	name := fmt.Sprintf("Scan library '%s'", library.Slug)
-	return createTask(name, func(w *Worker) error { return execScan(library, c, w) })
+	return createTask(name, func(ctx context.Context, w *Worker) error {
+		return execScan(ctx, library, c, w)
+	}).withKey(fmt.Sprintf("scan:%d", library.Id)).forLibrary(library.Slug)
 }
 
-func execScan(library api.Library, c config.Config, w *Worker) error {
-	registeredFiles, err := api.GetAllFiles(api.FileSelectorDto{Library: library.Slug}, c)
+func execScan(ctx context.Context, library api.Library, c config.Config, w *Worker) error {
+	unlock, err := w.lockLibraries(ctx, []int{library.Id})
 	if err != nil {
 		return err
 	}
-	registeredPaths := internal.Fmap(registeredFiles, func(f api.File, _ int) string {
-		return path.Join(c.DataDirectory, library.Path, f.Path)
-	})
-	filesInDir, err := filesystem.GetAllFilesInDirectory(path.Join(c.DataDirectory, library.Path))
+	defer unlock()
+	if err := w.index.syncLibrary(ctx, library, c); err != nil {
+		return err
+	}
+	libraryRoot := path.Join(c.DataDirectory, library.Path)
+	filesInDir, err := w.index.walk(libraryRoot)
 	if err != nil {
 		return err
 	}
 	pathsNotRegistered := []string{}
-	for _, fileInDir := range filesInDir {
-		if internal.Contains(registeredPaths, fileInDir) {
+	for fileInDir := range filesInDir {
+		if w.index.isRegistered(fileInDir) {
 			// File is already in library
 			continue
 		}
-		stringMime := mime.TypeByExtension(path.Ext(fileInDir))
-		if strings.HasPrefix(stringMime, "video/") || strings.HasPrefix(stringMime, "audio/") {
-			pathsNotRegistered = append(pathsNotRegistered, fileInDir)
-		} else if !strings.HasPrefix(stringMime, "image/") {
-			log.Warn().
-				Str("file", path.Base(fileInDir)).
+		pathsNotRegistered = append(pathsNotRegistered, fileInDir)
+	}
+	sort.Strings(pathsNotRegistered)
+	pathsNotRegistered = filterMediaFiles(ctx, pathsNotRegistered)
+	if c.UserSettings.ContentHash {
+		pathsNotRegistered, err = moveRenamedFiles(ctx, library, filesInDir, pathsNotRegistered, c, w)
+		if err != nil {
+			return err
+		}
+	}
+	modifiedFiles := filterMediaFiles(ctx, w.index.getModifiedFiles(filesInDir))
+	log.Ctx(ctx).Debug().Msgf("Library has %d new files and %d modified files", len(pathsNotRegistered), len(modifiedFiles))
+	successfulRegistrations, err := scanAndPostFiles(ctx, pathsNotRegistered, c, w)
+	log.Ctx(ctx).Info().Msgf("Library has registered %d new files", successfulRegistrations)
+	if err == nil && len(modifiedFiles) > 0 {
+		var successfulUpdates int
+		successfulUpdates, err = refreshModifiedFiles(ctx, libraryRoot, modifiedFiles, c, w)
+		log.Ctx(ctx).Info().Msgf("Library has updated %d modified files", successfulUpdates)
+	}
+	if err == nil && c.UserSettings.ContentHash {
+		// For files registered before content hashing was enabled
+		err = computeMissingContentHashes(ctx, filesInDir, w)
+	}
+	return err
+}
+
+// Parses the files again, and updates their metadata if their checksum changed
+// Returns the number of files that were updated
+func refreshModifiedFiles(ctx context.Context, libraryRoot string, filePaths []string, c config.Config, w *Worker) (int, error) {
+	successfulUpdates := 0
+	for _, filePath := range filePaths {
+		if err := w.checkpoint(ctx); err != nil {
+			return successfulUpdates, err
+		}
+		file, isRegistered := w.index.getFile(filePath)
+		if !isRegistered {
+			continue
+		}
+		registeredFile := api.File{Id: file.Id, Path: strings.TrimPrefix(filePath, libraryRoot+"/"), Checksum: file.Checksum}
+		switch refreshFile(ctx, filePath, registeredFile, false, c, w) {
+		case refreshSucceeded:
+			successfulUpdates++
+		case refreshSkipped:
+			// Only the mtime changed, so that the file is not parsed again on the next scan
+			w.index.setRegistered(filePath, file.Checksum, file.ContentHash)
+		}
+	}
+	return successfulUpdates, nil
+}
+
+// Keeps the audio and video files, based on their extension
+// Logs a warning for files that are neither media files nor images
+func filterMediaFiles(ctx context.Context, filePaths []string) []string {
+	mediaFiles := []string{}
+	for _, filePath := range filePaths {
+		if isMediaFile(filePath) {
+			mediaFiles = append(mediaFiles, filePath)
+		} else if !strings.HasPrefix(mime.TypeByExtension(path.Ext(filePath)), "image/") {
+			log.Ctx(ctx).Warn().
+				Str("file", path.Base(filePath)).
 				Msg("File does not seem to be an audio or video file. Ignored.")
 		}
 	}
-	log.Debug().
-		Str("library", library.Slug).
-		Msgf("Library has %d new files", len(pathsNotRegistered))
-	successfulRegistrations := scanAndPostFiles(pathsNotRegistered, c, w)
-	log.Info().
-		Str("library", library.Slug).
-		Msgf("Library has registered %d new files", successfulRegistrations)
-	return nil
+	return mediaFiles
 }
 
-func scanAndPostFiles(filePaths []string, c config.Config, w *Worker) int {
-	const chunkSize = 5
-	successfulRegistrations := 0
-	failedRegistration := 0
-	scanResChan := make(chan ScanRes, chunkSize)
-	defer close(scanResChan)
+func isMediaFile(filePath string) bool {
+	stringMime := mime.TypeByExtension(path.Ext(filePath))
+	return strings.HasPrefix(stringMime, "video/") || strings.HasPrefix(stringMime, "audio/")
+}
+
+// Returns the number of successful registrations
+// Returns an error if the context was cancelled
+// Files are parsed and sent to the API by separate pools of goroutines,
+// so that a slow file does not hold back the others
+func scanAndPostFiles(ctx context.Context, filePaths []string, c config.Config, w *Worker) (int, error) {
+	parsingWorkerCount := c.UserSettings.Concurrency.GetParsingWorkerCount()
+	pushingWorkerCount := c.UserSettings.Concurrency.GetPushingWorkerCount()
+	pathChan := make(chan string)
+	// Large enough to fill a batch while the previous one is being sent
+	scanResChan := make(chan ScanRes, max(parsingWorkerCount, MetadataBatchSize))
 	fileCount := len(filePaths)
-	for i := 0; i < fileCount; i += chunkSize {
-		filesChunk := filePaths[i:(int)(math.Min(float64(i+chunkSize), float64(fileCount)))]
-		for _, file := range filesChunk {
-			go scanAndPushResToChan(file, c.UserSettings, scanResChan)
+
+	go func() {
+		defer close(pathChan)
+		for _, filePath := range filePaths {
+			if err := w.checkpoint(ctx); err != nil {
+				return
+			}
+			select {
+			case pathChan <- filePath:
+			case <-ctx.Done():
+				return
+			}
 		}
-		for range len(filesChunk) {
-			res := <-scanResChan
-			baseFile := path.Base(res.filePath)
-			if len(res.errors) != 0 {
-				log.Error().Str("file", baseFile).Msg("Parsing failed")
-				for _, err := range res.errors {
-					log.Trace().Msg(err.Error())
-				}
-				failedRegistration = failedRegistration + 1
-			} else {
-				log.Info().Str("file", baseFile).Msg("Parsing successful")
-				err := pushMetadata(res.filePath, res.metadata, c, w, api.Create)
-				if err != nil {
-					log.Error().Str("file", baseFile).Msg("Could not POST metadata")
-					log.Trace().Msg(err.Error())
-					failedRegistration = failedRegistration + 1
-				} else {
-					successfulRegistrations = successfulRegistrations + 1
+	}()
+
+	var parsingWg sync.WaitGroup
+	for range parsingWorkerCount {
+		parsingWg.Add(1)
+		go func() {
+			defer parsingWg.Done()
+			for filePath := range pathChan {
+				scanAndPushResToChan(withFileLogger(ctx, filePath, c), filePath, c.UserSettings, scanResChan)
+			}
+		}()
+	}
+	go func() {
+		parsingWg.Wait()
+		close(scanResChan)
+	}()
+
+	pushChan := make(chan ScanRes, cap(scanResChan))
+	go func() {
+		defer close(pushChan)
+		holdFilesForAlbumGain(filePaths, scanResChan, pushChan)
+	}()
+
+	var mu sync.Mutex
+	successfulRegistrations := 0
+	failedRegistration := 0
+	var pushingWg sync.WaitGroup
+	for range pushingWorkerCount {
+		pushingWg.Add(1)
+		go func() {
+			defer pushingWg.Done()
+			for res := range pushChan {
+				batch := receiveBatch(res, pushChan)
+				// Holds the files while the worker is paused
+				if w.checkpoint(ctx) != nil {
+					// Parsing was interrupted, the errors are not relevant
+					continue
 				}
+				successful := postScanResBatch(ctx, batch, c, w)
+				mu.Lock()
+				successfulRegistrations = successfulRegistrations + successful
+				failedRegistration = failedRegistration + len(batch) - successful
+				processedFileCount := successfulRegistrations + failedRegistration
+				mu.Unlock()
+				w.SetProgress(ctx, processedFileCount, fileCount)
+			}
+		}()
+	}
+	pushingWg.Wait()
+	return successfulRegistrations, ctx.Err()
+}
+
+// Completes the batch with the files that are already parsed, without waiting for the others
+func receiveBatch(first ScanRes, scanResChan <-chan ScanRes) []ScanRes {
+	batch := []ScanRes{first}
+	for len(batch) < MetadataBatchSize {
+		select {
+		case res, isOpen := <-scanResChan:
+			if !isOpen {
+				return batch
 			}
+			batch = append(batch, res)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// Registers the files that were parsed successfully, and reports the others as failed
+// Returns the number of files that were registered
+func postScanResBatch(ctx context.Context, batch []ScanRes, c config.Config, w *Worker) int {
+	parsedFiles := []ScanRes{}
+	for _, res := range batch {
+		logger := log.Ctx(withFileLogger(ctx, res.filePath, c))
+		if len(res.errors) != 0 {
+			logger.Error().Errs("errors", res.errors).Msg("Parsing failed")
+			w.reportFailure(ctx, res.filePath, res.errors...)
+			continue
+		}
+		logger.Info().Msg("Parsing successful")
+		w.reportFileParsed(ctx, res.filePath)
+		parsedFiles = append(parsedFiles, res)
+	}
+	if len(parsedFiles) == 0 {
+		return 0
+	}
+	errs := pushMetadataBatch(ctx,
+		internal.Fmap(parsedFiles, func(res ScanRes, _ int) string { return res.filePath }),
+		internal.Fmap(parsedFiles, func(res ScanRes, _ int) internal.Metadata { return res.metadata }),
+		c, w, api.Create)
+	successful := 0
+	for i, err := range errs {
+		if err != nil {
+			log.Ctx(withFileLogger(ctx, parsedFiles[i].filePath, c)).Error().Err(err).Msg("Could not POST metadata")
+			w.reportFailure(ctx, parsedFiles[i].filePath, err)
+			continue
 		}
-		w.SetProgress(successfulRegistrations+failedRegistration, fileCount)
+		w.reportSuccess(ctx)
+		successful++
 	}
-	return successfulRegistrations
+	return successful
 }
 
 type ScanRes struct {
@@ -101,8 +251,8 @@ type ScanRes struct {
 	errors   []error
 }
 
-func scanAndPushResToChan(filePath string, c config.UserSettings, outputChan chan ScanRes) {
-	metadata, errors := parser.ParseMetadata(c, filePath)
+func scanAndPushResToChan(ctx context.Context, filePath string, c config.UserSettings, outputChan chan ScanRes) {
+	metadata, errors := parser.ParseMetadata(ctx, c, filePath)
 	outputChan <- ScanRes{
 		filePath: filePath,
 		metadata: metadata,
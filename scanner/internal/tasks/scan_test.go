@@ -0,0 +1,90 @@
+package tasks
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/api"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanProcessesEveryFile(t *testing.T) {
+	w := getTestWorker(t)
+	c := config.Config{UserSettings: config.UserSettings{
+		Metadata:    config.MetadataSettings{Source: config.Path, Order: config.Only},
+		Concurrency: config.ConcurrencySettings{Parsing: 3, Pushing: 2},
+	}}
+	filePaths := []string{}
+	for i := range 10 {
+		// These files do not exist, so parsing fails
+		filePaths = append(filePaths, fmt.Sprintf("/data/%d.mp3", i))
+	}
+
+	ctx, run := startTestRun(w, createTask("Task", nil))
+	successful, err := scanAndPostFiles(ctx, filePaths, c, w)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, successful)
+	assert.Equal(t, 10, run.report.Failed)
+	assert.Equal(t, 100, run.progress)
+}
+
+func TestBatchResultsAreMappedToFiles(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/metadata/batch", r.URL.Path)
+		w.Write([]byte(`{"items":[{"error":"Conflict"},{"trackId":2,"songId":3}]}`))
+	}))
+	defer server.Close()
+	w := getTestWorker(t)
+	c := config.Config{ApiUrl: server.URL}
+	ctx, _ := startTestRun(w, createTask("Task", nil))
+	filePaths := []string{"/data/a.mp3", "/data/b.mp3"}
+	ms := []internal.Metadata{
+		{Name: "A", Checksum: "a", Type: internal.Audio},
+		{Name: "B", Checksum: "b", Type: internal.Audio},
+	}
+
+	errs := pushMetadataBatch(ctx, filePaths, ms, c, w, api.Create)
+
+	assert.Len(t, errs, 2)
+	assert.EqualError(t, errs[0], "Conflict")
+	assert.Nil(t, errs[1])
+	assert.False(t, w.index.isRegistered("/data/a.mp3"))
+	file, isRegistered := w.index.getFile("/data/b.mp3")
+	assert.True(t, isRegistered)
+	assert.Equal(t, "b", file.Checksum)
+}
+
+func TestInvalidBatchIsSentOneByOne(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metadata/batch":
+			w.WriteHeader(http.StatusBadRequest)
+		case "/metadata":
+			if r.FormValue("name") == "A" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"trackId":2,"songId":3}`))
+		}
+	}))
+	defer server.Close()
+	w := getTestWorker(t)
+	c := config.Config{ApiUrl: server.URL}
+	ctx, _ := startTestRun(w, createTask("Task", nil))
+	filePaths := []string{"/data/a.mp3", "/data/b.mp3"}
+	ms := []internal.Metadata{
+		{Name: "A", Checksum: "a", Type: internal.Audio},
+		{Name: "B", Checksum: "b", Type: internal.Audio},
+	}
+
+	errs := pushMetadataBatch(ctx, filePaths, ms, c, w, api.Create)
+
+	assert.Len(t, errs, 2)
+	assert.ErrorIs(t, errs[0], api.ErrBadRequest)
+	assert.Nil(t, errs[1])
+	assert.True(t, w.index.isRegistered("/data/b.mp3"))
+}
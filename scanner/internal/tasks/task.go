@@ -1,29 +1,92 @@
 package tasks
 
 import (
+	"context"
+	"time"
+
 	"github.com/Arthi-chaud/Meelo/scanner/internal"
 	"github.com/google/uuid"
 )
 
+type TaskLane string
+
+const (
+	// For tasks that are usually short, like refreshes and cleans
+	InteractiveLane TaskLane = "interactive"
+	// For tasks that process whole libraries, like scans
+	BulkLane TaskLane = "bulk"
+)
+
+// Each lane processes its tasks one after the other, independently of the other lanes
+// So interactive tasks do not wait for bulk tasks to finish
+var TaskLanes = []TaskLane{InteractiveLane, BulkLane}
+
 type Task struct {
 	Id   string
 	Name string
-	Exec func(w *Worker) error
+	Lane TaskLane
+	// Pending tasks with the same key do the same thing, so they are not queued twice
+	// Empty if the task should never be deduplicated
+	Key string
+	// Slug of the library the task processes, added to its logs
+	// Empty if the task is not about a single library
+	Library string
+	// The context is cancelled when the task is
+	Exec func(ctx context.Context, w *Worker) error
+	// If true, nothing is written to the API.
+	// Instead, the task produces a report of what it would have done
+	DryRun bool
 }
 
 type TaskInfo struct {
 	Id   string
 	Name string
+	Lane TaskLane
+}
+
+type RunningTaskInfo struct {
+	TaskInfo
+	Progress  int // A number between 0 and 100
+	StartedAt time.Time
 }
 
 func (t Task) GetInfo() TaskInfo {
-	return TaskInfo{Id: t.Id, Name: t.Name}
+	return TaskInfo{Id: t.Id, Name: t.Name, Lane: t.Lane}
+}
+
+// Returns a copy of the task with the given deduplication key
+func (t Task) withKey(key string) Task {
+	t.Key = key
+	return t
+}
+
+// Returns a copy of the task that processes the given library
+func (t Task) forLibrary(slug string) Task {
+	t.Library = slug
+	return t
+}
+
+// Returns a copy of the task that runs in the given lane
+func (t Task) inLane(lane TaskLane) Task {
+	t.Lane = lane
+	return t
+}
+
+// Returns a copy of the task that will not write anything to the API
+func (t Task) AsDryRun() Task {
+	t.DryRun = true
+	t.Name = t.Name + " (dry run)"
+	if len(t.Key) > 0 {
+		t.Key = t.Key + ":dry-run"
+	}
+	return t
 }
 
-func createTask(name string, exec func(w *Worker) error) Task {
+func createTask(name string, exec func(ctx context.Context, w *Worker) error) Task {
 	return Task{
 		Id:   uuid.New().String(),
 		Name: name,
+		Lane: BulkLane,
 		Exec: exec,
 	}
 }
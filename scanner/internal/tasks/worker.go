@@ -1,99 +1,415 @@
 package tasks
 
 import (
+	"context"
+	"errors"
+	"path"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/Arthi-chaud/Meelo/scanner/internal"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/api"
 	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/metrics"
 	"github.com/rs/zerolog/log"
 )
 
 type Worker struct {
-	taskQueue      chan Task
+	// Held by the task that runs in each lane
+	// Tasks that wait for a library release it, so that the next tasks of the lane can start
+	laneSlots      map[TaskLane]chan struct{}
 	thumbnailQueue chan ThumbnailTask
-	currentTask    Task
-	queuedTasks    []Task
-	progress       int // A number between 0 and 100
-	mu             sync.Mutex
-	wg             sync.WaitGroup
+	// Tasks that are waiting to be started, in the order they were added
+	queuedTasks []Task
+	// Signaled when a task is queued or the worker is resumed
+	queueChanged *sync.Cond
+	// Key is the ID of the task
+	runningTasks map[string]*taskRun
+	// Held by the task that processes the library. Key is the ID of the library
+	libraryLocks map[int]chan struct{}
+	history      *History
+	index        *FileIndex
+	events       *EventBroker
+	config       config.Config
+	// API writes that could not be sent
+	outbox *Outbox
+	// Held while the outbox is being sent
+	replayingOutbox sync.Mutex
+	// Unregisters the replay of the outbox when the API is back
+	unsubscribeFromApi func()
+	// Closed when the worker is not paused
+	resumed chan struct{}
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	// Counts the thumbnails that are queued or being extracted
+	thumbnailWg sync.WaitGroup
 }
 
+// State of a running task
+type taskRun struct {
+	task      Task
+	progress  int // A number between 0 and 100
+	startedAt time.Time
+	report    TaskReport
+	// Set if the task is a dry run
+	dryRunReport *DryRunReport
+	// Cancels the context of the task
+	cancel context.CancelFunc
+	// True if the task holds the slot of its lane
+	// Only accessed by the goroutine that runs the task
+	holdsLane bool
+}
+
+type taskRunContextKey struct{}
+
 func NewWorker() *Worker {
-	return &Worker{
-		taskQueue:      make(chan Task),
+	resumed := make(chan struct{})
+	close(resumed)
+	laneSlots := map[TaskLane]chan struct{}{}
+	for _, lane := range TaskLanes {
+		laneSlots[lane] = make(chan struct{}, 1)
+	}
+	w := &Worker{
+		laneSlots:      laneSlots,
 		thumbnailQueue: make(chan ThumbnailTask),
+		runningTasks:   map[string]*taskRun{},
+		libraryLocks:   map[int]chan struct{}{},
+		events:         NewEventBroker(),
+		resumed:        resumed,
+	}
+	w.queueChanged = sync.NewCond(&w.mu)
+	return w
+}
+
+// Returns a channel of the events of the worker, and a function to call to unsubscribe
+func (w *Worker) Subscribe() (<-chan Event, func()) {
+	return w.events.Subscribe()
+}
+
+// Registers the task as running, and returns the context to run it with
+// The logger of the context carries the ID of the task and the slug of its library
+// Should be called while holding the lock
+func (w *Worker) startRun(task Task) (context.Context, *taskRun) {
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &taskRun{task: task, startedAt: time.Now(), cancel: cancel}
+	if task.DryRun {
+		run.dryRunReport = NewDryRunReport()
 	}
+	w.runningTasks[task.Id] = run
+	logContext := log.With().Str("task_id", task.Id).Str("task", task.Name)
+	if len(task.Library) > 0 {
+		logContext = logContext.Str("library", task.Library)
+	}
+	ctx = logContext.Logger().WithContext(ctx)
+	return context.WithValue(ctx, taskRunContextKey{}, run), run
+}
+
+// Returns nil if the context is not the one of a task
+func getRun(ctx context.Context) *taskRun {
+	run, _ := ctx.Value(taskRunContextKey{}).(*taskRun)
+	return run
+}
+
+// Returns a context whose logger also carries the path of the file, relative to the data directory
+func withFileLogger(ctx context.Context, filePath string, c config.Config) context.Context {
+	relativePath := strings.TrimPrefix(filePath, path.Clean(c.DataDirectory)+"/")
+	return log.Ctx(ctx).With().Str("file", relativePath).Logger().WithContext(ctx)
+}
+
+// Publishes an event about the task of the context
+func (w *Worker) publish(ctx context.Context, e Event) {
+	if run := getRun(ctx); run != nil {
+		e.TaskId = run.task.Id
+		e.TaskName = run.task.Name
+	}
+	w.events.Publish(e)
 }
 
 func (w *Worker) StartWorker(c config.Config) {
-	go func() {
-		for task := range w.taskQueue {
-			w.process(task)
-		}
-	}()
-	go func() {
-		for task := range w.thumbnailQueue {
-			if err := SaveThumbnail(task, c); err != nil {
-				log.Error().Msg("Extracting thumbnail failed:")
-				log.Trace().Msg(err.Error())
-			}
+	w.history = NewHistory(c.ConfigDirectory)
+	w.index = NewFileIndex(c.ConfigDirectory)
+	w.outbox = NewOutbox(c.ConfigDirectory)
+	w.config = c
+	w.StopWorker()
+	w.unsubscribeFromApi = api.OnAvailabilityChange(func(isAvailable bool) {
+		if isAvailable {
+			go w.replayOutbox()
 		}
-	}()
+	})
+	w.mu.Lock()
+	w.updateQueueMetrics()
+	w.mu.Unlock()
+	for _, lane := range TaskLanes {
+		go w.runLane(lane)
+	}
+	for range c.UserSettings.Concurrency.GetThumbnailWorkerCount() {
+		go func() {
+			for task := range w.thumbnailQueue {
+				ctx := withFileLogger(context.Background(), task.FilePath, c)
+				err := SaveThumbnail(ctx, task, c)
+				if api.IsDown(err) {
+					err = w.queue(ctx, OutboxOperation{Type: SaveThumbnailOperation, Thumbnail: &task})
+				}
+				if err != nil {
+					log.Ctx(ctx).Error().Err(err).Msg("Extracting thumbnail failed")
+				}
+				w.thumbnailWg.Done()
+			}
+		}()
+	}
+	if !w.outbox.IsEmpty() {
+		// Operations queued before the scanner stopped
+		go w.replayOutbox()
+	}
+}
+
+// Stops sending the outbox when the API is back
+// Tasks that are queued or running are not stopped
+func (w *Worker) StopWorker() {
+	if w.unsubscribeFromApi != nil {
+		w.unsubscribeFromApi()
+		w.unsubscribeFromApi = nil
+	}
 }
 
-func (w *Worker) SetProgress(stepsFinished int, stepsCount int) {
+func (w *Worker) SetProgress(ctx context.Context, stepsFinished int, stepsCount int) {
 	if stepsCount == 0 {
-		log.Error().Msg("Could not set progress for task. Step count is zero.")
+		log.Ctx(ctx).Error().Msg("Could not set progress for task. Step count is zero.")
+		return
 	}
 	newProgress := int(float64(100*stepsFinished) / float64(stepsCount))
 	if newProgress < 0 || newProgress > 100 {
-		log.Warn().
+		log.Ctx(ctx).Warn().
 			Str("input", strconv.Itoa(newProgress)).
 			Msg("Attempt to set a progress value out of bound")
 		return
 	}
+	run := getRun(ctx)
+	if run == nil {
+		return
+	}
 	w.mu.Lock()
-	w.progress = newProgress
+	previousProgress := run.progress
+	run.progress = newProgress
 	w.mu.Unlock()
+	metrics.TaskProgress.WithLabelValues(string(run.task.Lane)).Set(float64(newProgress))
+	if previousProgress != newProgress {
+		w.publish(ctx, Event{Type: TaskProgress, Progress: newProgress})
+	}
 }
 
-func (w *Worker) process(task Task) {
-	defer w.wg.Done() // Decrement the WaitGroup counter when the task is done
+// Returns nil if the task is not a dry run
+func (w *Worker) getDryRunReport(ctx context.Context) *DryRunReport {
+	if run := getRun(ctx); run != nil {
+		return run.dryRunReport
+	}
+	return nil
+}
+
+func (w *Worker) reportFileParsed(ctx context.Context, filePath string) {
+	w.publish(ctx, Event{Type: FileParsed, File: filePath})
+}
 
+// Applies the change to the report of the task of the context
+func (w *Worker) updateReport(ctx context.Context, update func(r *TaskReport)) {
+	run := getRun(ctx)
+	if run == nil {
+		return
+	}
 	w.mu.Lock()
-	w.queuedTasks = removeTask(w.queuedTasks, task.Id)
-	w.currentTask = task
-	w.progress = 0
-	w.mu.Unlock()
+	defer w.mu.Unlock()
+	update(&run.report)
+}
+
+func (w *Worker) reportSuccess(ctx context.Context) {
+	w.updateReport(ctx, func(r *TaskReport) { r.Successful++ })
+}
+
+func (w *Worker) reportSkip(ctx context.Context) {
+	w.updateReport(ctx, func(r *TaskReport) { r.Skipped++ })
+}
+
+func (w *Worker) reportFailure(ctx context.Context, filePath string, errs ...error) {
+	failure := FileFailure{
+		Path: filePath,
+		Errors: internal.Fmap(errs, func(err error, _ int) string {
+			return err.Error()
+		}),
+	}
+	w.updateReport(ctx, func(r *TaskReport) {
+		r.Failed++
+		r.FailedFiles = append(r.FailedFiles, failure)
+	})
+	w.publish(ctx, Event{Type: FileFailed, File: failure.Path, Errors: failure.Errors})
+}
+
+// Starts the tasks of the lane one at a time, in the order they were queued
+// The next task is started once the slot of the lane is released
+func (w *Worker) runLane(lane TaskLane) {
+	for {
+		w.mu.Lock()
+		for !w.canStartTask(lane) {
+			w.queueChanged.Wait()
+		}
+		w.mu.Unlock()
+		// The slot is only taken when there is a task to start,
+		// so that a task that waited for a library can take it back
+		w.laneSlots[lane] <- struct{}{}
+		w.mu.Lock()
+		if !w.canStartTask(lane) {
+			// The task was cancelled or the worker paused in the meantime
+			<-w.laneSlots[lane]
+			w.mu.Unlock()
+			continue
+		}
+		task, _ := w.getNextTask(lane)
+		w.queuedTasks = removeTask(w.queuedTasks, task.Id)
+		w.updateQueueMetrics()
+		ctx, run := w.startRun(task)
+		run.holdsLane = true
+		w.mu.Unlock()
+		go w.process(ctx, run)
+	}
+}
+
+// Returns the first queued task of the lane
+// Should be called while holding the lock
+func (w *Worker) getNextTask(lane TaskLane) (Task, bool) {
+	for _, task := range w.queuedTasks {
+		if task.Lane == lane {
+			return task, true
+		}
+	}
+	return Task{}, false
+}
+
+// Should be called while holding the lock
+func (w *Worker) canStartTask(lane TaskLane) bool {
+	_, found := w.getNextTask(lane)
+	return found && !w.isPausedLocked()
+}
+
+// Lets the next task of the lane start
+func (w *Worker) releaseLane(run *taskRun) {
+	if run.holdsLane {
+		run.holdsLane = false
+		<-w.laneSlots[run.task.Lane]
+	}
+}
+
+func (w *Worker) process(ctx context.Context, run *taskRun) {
+	defer w.wg.Done() // Decrement the WaitGroup counter when the task is done
+	defer run.cancel()
+	task := run.task
+	w.events.Publish(Event{Type: TaskStarted, TaskId: task.Id, TaskName: task.Name})
 
-	log.Info().Str("task", task.Name).Msgf("Processing task")
-	err := task.Exec(w)
-	if err != nil {
-		log.Error().Str("task", task.Name).Msgf("Task returned an error")
-		log.Trace().Msg(err.Error())
+	logger := log.Ctx(ctx)
+	logger.Info().Msg("Processing task")
+	err := task.Exec(ctx, w)
+	if errors.Is(err, context.Canceled) {
+		logger.Warn().Msg("Task was cancelled")
+	} else if err != nil {
+		logger.Error().Err(err).Msg("Task returned an error")
 	} else {
-		log.Info().Str("task", task.Name).Msgf("Task finished successfully")
+		logger.Info().Msg("Task finished successfully")
+	}
+	// Saved before the task is finished, so that the report exists once the task is
+	if run.dryRunReport != nil {
+		if err := saveDryRunReport(w.config.ConfigDirectory, task.Id, run.dryRunReport); err != nil {
+			logger.Error().Err(err).Msg("Could not save dry-run report")
+		}
 	}
 	w.mu.Lock()
-	w.currentTask = Task{}
-	w.progress = 0
+	record := run.record()
+	endedAt := time.Now()
+	record.EndedAt = &endedAt
+	record.Status = Done
+	record.Progress = 100
+	if errors.Is(err, context.Canceled) {
+		record.Progress = run.progress
+		record.Status = Cancelled
+	} else if err != nil {
+		errMsg := err.Error()
+		record.Progress = run.progress
+		record.Status = Failed
+		record.Error = &errMsg
+	}
+	// In the same critical section, so that the task can always be found
+	delete(w.runningTasks, task.Id)
+	w.history.Add(record)
+	// Other tasks of the lane may still be running, e.g. if they were waiting for a library
+	if !w.hasRunningTaskLocked(task.Lane) {
+		metrics.TaskProgress.WithLabelValues(string(task.Lane)).Set(0)
+	}
 	w.mu.Unlock()
+	w.releaseLane(run)
+	if err := w.index.Save(); err != nil {
+		logger.Error().Err(err).Msg("Could not save file index")
+	}
+	w.events.Publish(Event{
+		Type:     TaskFinished,
+		TaskId:   record.Id,
+		TaskName: record.Name,
+		Status:   record.Status,
+		Report:   record.Report,
+	})
+}
+
+// Should be called while holding the lock
+func (r *taskRun) record() TaskRecord {
+	startedAt := r.startedAt
+	return TaskRecord{
+		Id:        r.task.Id,
+		Name:      r.task.Name,
+		Lane:      r.task.Lane,
+		Status:    Running,
+		Progress:  r.progress,
+		StartedAt: &startedAt,
+		Report:    r.report,
+	}
+}
+
+// Blocks until all the queued tasks and thumbnails are processed
+func (w *Worker) Wait() {
+	w.wg.Wait()
+	w.thumbnailWg.Wait()
 }
 
 // AddTask adds a task to the queue and tracks it
+// If an identical task is already pending, the task is not queued, and the pending one is returned
 func (w *Worker) AddTask(task Task) Task {
 	w.mu.Lock()
+	for _, queuedTask := range w.queuedTasks {
+		if len(task.Key) > 0 && queuedTask.Key == task.Key {
+			w.mu.Unlock()
+			log.Info().Str("name", task.Name).Msg("Identical task is already pending")
+			return queuedTask
+		}
+	}
 	w.queuedTasks = append(w.queuedTasks, task)
+	w.updateQueueMetrics()
+	w.wg.Add(1)
+	w.queueChanged.Broadcast()
 	w.mu.Unlock()
 
-	w.wg.Add(1)
-	go func() {
-		w.taskQueue <- task
-	}()
+	w.events.Publish(Event{Type: TaskQueued, TaskId: task.Id, TaskName: task.Name})
 	return task
 }
 
+// Should be called while holding the lock
+func (w *Worker) updateQueueMetrics() {
+	for _, lane := range TaskLanes {
+		depth := len(internal.Filter(w.queuedTasks, func(task Task) bool {
+			return task.Lane == lane
+		}))
+		metrics.QueueDepth.WithLabelValues(string(lane)).Set(float64(depth))
+	}
+}
+
 func removeTask(tasks []Task, id string) []Task {
 	for i, task := range tasks {
 		if task.Id == id {
@@ -104,11 +420,201 @@ func removeTask(tasks []Task, id string) []Task {
 	return tasks
 }
 
-func (w *Worker) GetCurrentTasks() (TaskInfo, int, []TaskInfo) {
+// Returns the running tasks, sorted by start date, and the pending tasks
+func (w *Worker) GetCurrentTasks() ([]RunningTaskInfo, []TaskInfo) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	return w.currentTask.GetInfo(), w.progress, internal.Fmap(w.queuedTasks, func(task Task, i int) TaskInfo {
+	runningTasks := []RunningTaskInfo{}
+	for _, run := range w.runningTasks {
+		runningTasks = append(runningTasks, RunningTaskInfo{
+			TaskInfo:  run.task.GetInfo(),
+			Progress:  run.progress,
+			StartedAt: run.startedAt,
+		})
+	}
+	sort.Slice(runningTasks, func(i, j int) bool {
+		return runningTasks[i].StartedAt.Before(runningTasks[j].StartedAt)
+	})
+	return runningTasks, internal.Fmap(w.queuedTasks, func(task Task, i int) TaskInfo {
 		return task.GetInfo()
 	})
 }
+
+// Returns the finished tasks, most recent first
+func (w *Worker) GetHistory() []TaskRecord {
+	return w.history.GetAll()
+}
+
+// Looks for a task by id, be it pending, running or finished
+func (w *Worker) GetTask(id string) (TaskRecord, bool) {
+	w.mu.Lock()
+	if run, isRunning := w.runningTasks[id]; isRunning {
+		record := run.record()
+		w.mu.Unlock()
+		return record, true
+	}
+	for _, task := range w.queuedTasks {
+		if task.Id == id {
+			w.mu.Unlock()
+			return TaskRecord{Id: task.Id, Name: task.Name, Lane: task.Lane, Status: Pending}, true
+		}
+	}
+	w.mu.Unlock()
+	return w.history.Get(id)
+}
+
+// Drops the task if it is pending, or cancels it if it is running
+// Returns false if the task is neither pending nor running
+func (w *Worker) CancelTask(id string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if run, isRunning := w.runningTasks[id]; isRunning {
+		run.cancel()
+		return true
+	}
+	for _, task := range w.queuedTasks {
+		if task.Id == id {
+			w.queuedTasks = removeTask(w.queuedTasks, id)
+			w.updateQueueMetrics()
+			w.wg.Done()
+			endedAt := time.Now()
+			w.history.Add(TaskRecord{Id: task.Id, Name: task.Name, Lane: task.Lane, Status: Cancelled, EndedAt: &endedAt})
+			w.events.Publish(Event{Type: TaskFinished, TaskId: task.Id, TaskName: task.Name, Status: Cancelled})
+			return true
+		}
+	}
+	return false
+}
+
+// Prevents new tasks from being started
+// The running tasks are paused before they process their next file
+func (w *Worker) Pause() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	select {
+	case <-w.resumed:
+		w.resumed = make(chan struct{})
+		log.Info().Msg("Worker paused")
+	default:
+		// Already paused
+	}
+}
+
+func (w *Worker) Resume() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	select {
+	case <-w.resumed:
+		// Not paused
+	default:
+		close(w.resumed)
+		w.queueChanged.Broadcast()
+		log.Info().Msg("Worker resumed")
+	}
+}
+
+func (w *Worker) IsPaused() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.isPausedLocked()
+}
+
+// Should be called while holding the lock
+func (w *Worker) hasRunningTaskLocked(lane TaskLane) bool {
+	for _, run := range w.runningTasks {
+		if run.task.Lane == lane {
+			return true
+		}
+	}
+	return false
+}
+
+// Should be called while holding the lock
+func (w *Worker) isPausedLocked() bool {
+	select {
+	case <-w.resumed:
+		return false
+	default:
+		return true
+	}
+}
+
+// Blocks while the worker is paused
+// Returns an error if the context is cancelled in the meantime
+func (w *Worker) waitIfPaused(ctx context.Context) error {
+	w.mu.Lock()
+	resumed := w.resumed
+	w.mu.Unlock()
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Should be called by tasks between two steps
+// Returns an error if the task was cancelled
+func (w *Worker) checkpoint(ctx context.Context) error {
+	if err := w.waitIfPaused(ctx); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// Blocks until no other task processes any of the libraries
+// While it waits, the task lets the next tasks of its lane start
+// Returns a function to call to release the libraries, or an error if the context is cancelled in the meantime
+func (w *Worker) lockLibraries(ctx context.Context, libraryIds []int) (func(), error) {
+	libraryIds = slices.Clone(libraryIds)
+	// Always locking in the same order prevents deadlocks
+	slices.Sort(libraryIds)
+	libraryIds = slices.Compact(libraryIds)
+	locks := []chan struct{}{}
+	unlock := func() {
+		for _, lock := range locks {
+			<-lock
+		}
+	}
+	run := getRun(ctx)
+	releasedLane := false
+	for _, libraryId := range libraryIds {
+		w.mu.Lock()
+		lock, found := w.libraryLocks[libraryId]
+		if !found {
+			lock = make(chan struct{}, 1)
+			w.libraryLocks[libraryId] = lock
+		}
+		w.mu.Unlock()
+		select {
+		case lock <- struct{}{}:
+			locks = append(locks, lock)
+			continue
+		default:
+		}
+		if run != nil && run.holdsLane {
+			log.Ctx(ctx).Debug().Msg("Waiting for another task to release the library")
+			w.releaseLane(run)
+			releasedLane = true
+		}
+		select {
+		case lock <- struct{}{}:
+			locks = append(locks, lock)
+		case <-ctx.Done():
+			unlock()
+			return nil, ctx.Err()
+		}
+	}
+	if releasedLane {
+		// Waits for the task that took the slot in the meantime
+		select {
+		case w.laneSlots[run.task.Lane] <- struct{}{}:
+			run.holdsLane = true
+		case <-ctx.Done():
+			unlock()
+			return nil, ctx.Err()
+		}
+	}
+	return unlock, nil
+}
@@ -0,0 +1,318 @@
+package tasks
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/metrics"
+	"github.com/goccy/go-json"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestWorker(t *testing.T) *Worker {
+	w := NewWorker()
+	w.StartWorker(config.Config{ConfigDirectory: t.TempDir()})
+	t.Cleanup(w.StopWorker)
+	return w
+}
+
+// Returns the context of a task, as if the worker was running it
+func startTestRun(w *Worker, task Task) (context.Context, *taskRun) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.startRun(task)
+}
+
+func TestCancelPendingTask(t *testing.T) {
+	w := getTestWorker(t)
+	w.Pause()
+	executed := false
+	task := w.AddTask(createTask("Task", func(ctx context.Context, w *Worker) error {
+		executed = true
+		return nil
+	}))
+
+	assert.True(t, w.CancelTask(task.Id))
+	w.Resume()
+	w.wg.Wait()
+	assert.False(t, executed)
+	record, found := w.GetTask(task.Id)
+	assert.True(t, found)
+	assert.Equal(t, Cancelled, record.Status)
+	assert.False(t, w.CancelTask(task.Id))
+}
+
+func TestCancelRunningTask(t *testing.T) {
+	w := getTestWorker(t)
+	started := make(chan struct{})
+	task := w.AddTask(createTask("Task", func(ctx context.Context, w *Worker) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+
+	<-started
+	assert.True(t, w.CancelTask(task.Id))
+	w.wg.Wait()
+	record, _ := w.GetTask(task.Id)
+	assert.Equal(t, Cancelled, record.Status)
+	assert.NotNil(t, record.EndedAt)
+}
+
+func TestPausedWorkerHoldsRunningTask(t *testing.T) {
+	w := getTestWorker(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	w.Pause()
+	assert.True(t, w.IsPaused())
+	cancel()
+	assert.ErrorIs(t, w.checkpoint(ctx), context.Canceled)
+
+	w.Resume()
+	assert.False(t, w.IsPaused())
+	assert.NoError(t, w.checkpoint(context.Background()))
+}
+
+func TestInteractiveTaskDoesNotWaitForBulkTask(t *testing.T) {
+	w := getTestWorker(t)
+	bulkStarted := make(chan struct{})
+	releaseBulk := make(chan struct{})
+	w.AddTask(createTask("Bulk", func(ctx context.Context, w *Worker) error {
+		w.SetProgress(ctx, 1, 4)
+		close(bulkStarted)
+		<-releaseBulk
+		return nil
+	}))
+	<-bulkStarted
+	interactiveDone := make(chan struct{})
+	w.AddTask(createTask("Interactive", func(ctx context.Context, w *Worker) error {
+		runningTasks, _ := w.GetCurrentTasks()
+		assert.Len(t, runningTasks, 2)
+		assert.Equal(t, "Bulk", runningTasks[0].Name)
+		assert.Equal(t, 25, runningTasks[0].Progress)
+		assert.Equal(t, "Interactive", runningTasks[1].Name)
+		assert.Equal(t, InteractiveLane, runningTasks[1].Lane)
+		close(interactiveDone)
+		return nil
+	}).inLane(InteractiveLane))
+
+	<-interactiveDone
+	close(releaseBulk)
+	w.wg.Wait()
+	runningTasks, pendingTasks := w.GetCurrentTasks()
+	assert.Empty(t, runningTasks)
+	assert.Empty(t, pendingTasks)
+}
+
+func TestLibraryIsLockedByOneTaskAtATime(t *testing.T) {
+	w := getTestWorker(t)
+	unlock, err := w.lockLibraries(context.Background(), []int{1, 2})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = w.lockLibraries(ctx, []int{2})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	// Other libraries are not locked
+	unlockOther, err := w.lockLibraries(context.Background(), []int{3})
+	assert.NoError(t, err)
+	unlockOther()
+
+	unlock()
+	unlock, err = w.lockLibraries(context.Background(), []int{2, 1})
+	assert.NoError(t, err)
+	unlock()
+}
+
+func TestTasksOfALaneStartInOrder(t *testing.T) {
+	w := getTestWorker(t)
+	w.Pause()
+	order := []int{}
+	for i := range 10 {
+		w.AddTask(createTask("Task", func(ctx context.Context, w *Worker) error {
+			order = append(order, i)
+			return nil
+		}))
+	}
+
+	w.Resume()
+	w.wg.Wait()
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, order)
+}
+
+func TestTaskWaitingForLibraryDoesNotHoldLane(t *testing.T) {
+	w := getTestWorker(t)
+	// As if a bulk task was processing the library
+	unlock, err := w.lockLibraries(context.Background(), []int{1})
+	assert.NoError(t, err)
+	lockedTaskDone := make(chan struct{})
+	w.AddTask(createTask("Locked library", func(ctx context.Context, w *Worker) error {
+		unlock, err := w.lockLibraries(ctx, []int{1})
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		close(lockedTaskDone)
+		return nil
+	}).inLane(InteractiveLane))
+	otherTaskDone := make(chan struct{})
+	w.AddTask(createTask("Other library", func(ctx context.Context, w *Worker) error {
+		unlock, err := w.lockLibraries(ctx, []int{2})
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		close(otherTaskDone)
+		return nil
+	}).inLane(InteractiveLane))
+
+	<-otherTaskDone
+	select {
+	case <-lockedTaskDone:
+		t.Fatal("Task ran while its library was locked")
+	default:
+	}
+	unlock()
+	<-lockedTaskDone
+	w.wg.Wait()
+}
+
+func TestIdenticalPendingTaskIsNotQueued(t *testing.T) {
+	w := getTestWorker(t)
+	w.Pause()
+	task := w.AddTask(createTask("Task", func(ctx context.Context, w *Worker) error {
+		return nil
+	}).withKey("key"))
+
+	duplicate := w.AddTask(createTask("Task", nil).withKey("key"))
+	assert.Equal(t, task.Id, duplicate.Id)
+	dryRun := w.AddTask(createTask("Task", nil).withKey("key").AsDryRun())
+	assert.NotEqual(t, task.Id, dryRun.Id)
+	assert.True(t, w.CancelTask(dryRun.Id))
+	_, pendingTasks := w.GetCurrentTasks()
+	assert.Len(t, pendingTasks, 1)
+	w.Resume()
+	w.wg.Wait()
+}
+
+func TestGetTaskReportsProgress(t *testing.T) {
+	w := getTestWorker(t)
+	progressSet := make(chan struct{})
+	release := make(chan struct{})
+	task := w.AddTask(createTask("Task", func(ctx context.Context, w *Worker) error {
+		w.SetProgress(ctx, 1, 2)
+		w.reportSuccess(ctx)
+		close(progressSet)
+		<-release
+		return nil
+	}))
+
+	<-progressSet
+	record, found := w.GetTask(task.Id)
+	assert.True(t, found)
+	assert.Equal(t, Running, record.Status)
+	assert.Equal(t, BulkLane, record.Lane)
+	assert.Equal(t, 50, record.Progress)
+	assert.Equal(t, 1, record.Report.Successful)
+	close(release)
+	w.wg.Wait()
+	record, _ = w.GetTask(task.Id)
+	assert.Equal(t, Done, record.Status)
+	assert.Equal(t, 100, record.Progress)
+}
+
+func TestProgressIsNotSetWithoutSteps(t *testing.T) {
+	w := getTestWorker(t)
+	ctx, run := startTestRun(w, createTask("Task", nil))
+	w.SetProgress(ctx, 1, 2)
+
+	w.SetProgress(ctx, 0, 0)
+	assert.Equal(t, 50, run.progress)
+}
+
+func TestQueueAndProgressMetrics(t *testing.T) {
+	w := getTestWorker(t)
+	w.Pause()
+	progressSet := make(chan struct{})
+	release := make(chan struct{})
+	w.AddTask(createTask("Task", func(ctx context.Context, w *Worker) error {
+		w.SetProgress(ctx, 1, 4)
+		close(progressSet)
+		<-release
+		return nil
+	}))
+	w.AddTask(createTask("Task", func(ctx context.Context, w *Worker) error {
+		return nil
+	}))
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.QueueDepth.WithLabelValues(string(BulkLane))))
+	w.Resume()
+	<-progressSet
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.QueueDepth.WithLabelValues(string(BulkLane))))
+	assert.Equal(t, float64(25), testutil.ToFloat64(metrics.TaskProgress.WithLabelValues(string(BulkLane))))
+	close(release)
+	w.wg.Wait()
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.QueueDepth.WithLabelValues(string(BulkLane))))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.TaskProgress.WithLabelValues(string(BulkLane))))
+}
+
+func TestProgressMetricIsKeptWhileLaneHasRunningTask(t *testing.T) {
+	w := getTestWorker(t)
+	// As if a task of the other lane was processing the library
+	unlock, err := w.lockLibraries(context.Background(), []int{1})
+	assert.NoError(t, err)
+	waitingDone := make(chan struct{})
+	w.AddTask(createTask("Waiting for library", func(ctx context.Context, w *Worker) error {
+		w.SetProgress(ctx, 1, 2)
+		unlock, err := w.lockLibraries(ctx, []int{1})
+		if err != nil {
+			return err
+		}
+		unlock()
+		close(waitingDone)
+		return nil
+	}))
+	otherDone := make(chan struct{})
+	w.AddTask(createTask("Other task", func(ctx context.Context, w *Worker) error {
+		close(otherDone)
+		return nil
+	}))
+
+	<-otherDone
+	// Wait for the other task to finish, while the first one is still waiting
+	for {
+		if runningTasks, _ := w.GetCurrentTasks(); len(runningTasks) == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, float64(50), testutil.ToFloat64(metrics.TaskProgress.WithLabelValues(string(BulkLane))))
+	unlock()
+	<-waitingDone
+	w.wg.Wait()
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.TaskProgress.WithLabelValues(string(BulkLane))))
+}
+
+func TestTaskLoggerCarriesTaskAndFile(t *testing.T) {
+	output := bytes.NewBuffer(nil)
+	defaultLogger := log.Logger
+	log.Logger = zerolog.New(output)
+	defer func() { log.Logger = defaultLogger }()
+	w := getTestWorker(t)
+	task := createTask("Task", nil).forLibrary("my-library")
+	ctx, _ := startTestRun(w, task)
+
+	log.Ctx(withFileLogger(ctx, "/data/my-library/a.mp3", config.Config{DataDirectory: "/data"})).Error().Msg("Parsing failed")
+
+	line := map[string]string{}
+	assert.Nil(t, json.Unmarshal(output.Bytes(), &line))
+	assert.Equal(t, task.Id, line["task_id"])
+	assert.Equal(t, "my-library", line["library"])
+	assert.Equal(t, "my-library/a.mp3", line["file"])
+	assert.Equal(t, "Parsing failed", line["message"])
+}
@@ -0,0 +1,224 @@
+package watcher
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal/api"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/tasks"
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+)
+
+const DefaultDebounceDelay = 10 * time.Second
+
+// Changes are processed after this delay, even if the events keep coming (e.g. during a long copy)
+// Unless the debounce delay is longer
+const MaxDebounceDelay = time.Minute
+
+// The libraries are fetched this often, so that new libraries are watched
+const LibraryRefreshInterval = 5 * time.Minute
+
+// Watches the directories of the libraries and queues scans for the paths that changed
+type Watcher struct {
+	config       config.Config
+	worker       *tasks.Worker
+	fsWatcher    *fsnotify.Watcher
+	debounce     time.Duration
+	maxDebounce  time.Duration
+	changedPaths map[string]bool
+	// Time of the first event that is not processed yet
+	firstEventAt time.Time
+	timer        *time.Timer
+	// Last libraries fetched from the API, used while it is down
+	libraries []api.Library
+	// Directories of the libraries that are watched
+	watchedRoots map[string]bool
+	mu           sync.Mutex
+}
+
+func NewWatcher(c config.Config, w *tasks.Worker) (*Watcher, error) {
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	debounce := time.Duration(c.UserSettings.Watcher.Debounce) * time.Second
+	if debounce == 0 {
+		debounce = DefaultDebounceDelay
+	}
+	return &Watcher{
+		config:       c,
+		worker:       w,
+		fsWatcher:    fsWatcher,
+		debounce:     debounce,
+		maxDebounce:  max(debounce, MaxDebounceDelay),
+		changedPaths: map[string]bool{},
+		watchedRoots: map[string]bool{},
+	}, nil
+}
+
+// Watches the directories of the libraries recursively and starts processing events
+func (w *Watcher) Start() error {
+	if _, err := w.getLibraries(); err != nil {
+		return err
+	}
+	go w.handleEvents()
+	log.Info().Msg("Watching libraries for changes")
+	return nil
+}
+
+func (w *Watcher) Close() error {
+	w.mu.Lock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.mu.Unlock()
+	return w.fsWatcher.Close()
+}
+
+// inotify does not watch subdirectories, so we have to add them one by one
+func (w *Watcher) watchRecursively(dir string) error {
+	return filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if err := w.fsWatcher.Add(p); err != nil {
+			// Not fatal, the directory will just not be watched
+			log.Error().Str("directory", p).Err(err).Msg("Could not watch directory")
+		}
+		return nil
+	})
+}
+
+// Fetches the libraries from the API, or returns the last known ones if it is down
+// Directories of new libraries are watched
+func (w *Watcher) getLibraries() ([]api.Library, error) {
+	libraries, err := api.GetAllLibraries(context.Background(), w.config)
+	w.mu.Lock()
+	if api.IsDown(err) && w.libraries != nil {
+		log.Warn().Msg("API is unavailable. Using the last known libraries")
+		libraries, err = w.libraries, nil
+	}
+	if err == nil {
+		w.libraries = libraries
+	}
+	newRoots := []string{}
+	for _, library := range libraries {
+		if libraryRoot := path.Join(w.config.DataDirectory, library.Path); !w.watchedRoots[libraryRoot] {
+			newRoots = append(newRoots, libraryRoot)
+		}
+	}
+	w.mu.Unlock()
+	for _, libraryRoot := range newRoots {
+		if err := w.watchRecursively(libraryRoot); err != nil {
+			// e.g. if the directory does not exist yet. We will try again with the next refresh
+			log.Error().Str("directory", libraryRoot).Err(err).Msg("Could not watch library")
+			continue
+		}
+		w.mu.Lock()
+		w.watchedRoots[libraryRoot] = true
+		w.mu.Unlock()
+	}
+	return libraries, err
+}
+
+func (w *Watcher) handleEvents() {
+	refreshLibraries := time.NewTicker(LibraryRefreshInterval)
+	defer refreshLibraries.Stop()
+	for {
+		select {
+		case <-refreshLibraries.C:
+			if _, err := w.getLibraries(); err != nil {
+				log.Error().Err(err).Msg("Could not get libraries. New libraries will not be watched")
+			}
+		case event, ok := <-w.fsWatcher.Events:
+			if !ok {
+				return
+			}
+			w.handleEvent(event)
+		case err, ok := <-w.fsWatcher.Errors:
+			if !ok {
+				return
+			}
+			log.Error().Err(err).Msg("Filesystem watcher error")
+		}
+	}
+}
+
+func (w *Watcher) handleEvent(event fsnotify.Event) {
+	if event.Has(fsnotify.Chmod) && !event.Has(fsnotify.Write) {
+		return
+	}
+	if event.Has(fsnotify.Create) {
+		if stat, err := os.Stat(event.Name); err == nil && stat.IsDir() {
+			// The directory may have been moved in with files already in it
+			// Those files will not trigger any event, so the task will look for them
+			if err := w.watchRecursively(event.Name); err != nil {
+				log.Warn().Str("directory", event.Name).Err(err).Msg("Could not watch new directory")
+			}
+		}
+	}
+	log.Trace().Str("path", event.Name).Str("event", event.Op.String()).Msg("Filesystem event")
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.changedPaths) == 0 {
+		w.firstEventAt = time.Now()
+	}
+	w.changedPaths[event.Name] = true
+	// Debounce: wait for the burst of events to end before queuing tasks,
+	// but not longer than the maximum delay since the first event
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	delay := min(w.debounce, w.maxDebounce-time.Since(w.firstEventAt))
+	w.timer = time.AfterFunc(max(delay, 0), w.flush)
+}
+
+// Queues a scan task for each library that has changed paths
+func (w *Watcher) flush() {
+	w.mu.Lock()
+	changedPaths := make([]string, 0, len(w.changedPaths))
+	for changedPath := range w.changedPaths {
+		changedPaths = append(changedPaths, changedPath)
+	}
+	w.changedPaths = map[string]bool{}
+	w.timer = nil
+	w.mu.Unlock()
+
+	if len(changedPaths) == 0 {
+		return
+	}
+	// We fetch the libraries everytime, so that we do not miss the new ones
+	libraries, err := w.getLibraries()
+	if err != nil {
+		log.Error().Err(err).Msg("Could not get libraries. Changes will be ignored")
+		return
+	}
+	for library, libraryPaths := range groupPathsByLibrary(changedPaths, libraries, w.config.DataDirectory) {
+		task := w.worker.AddTask(tasks.NewIncrementalScanTask(library, libraryPaths, w.config))
+		log.Info().Str("name", task.Name).Msg("Task added to queue")
+	}
+}
+
+func groupPathsByLibrary(changedPaths []string, libraries []api.Library, dataDirectory string) map[api.Library][]string {
+	pathsByLibrary := map[api.Library][]string{}
+	for _, changedPath := range changedPaths {
+		for _, library := range libraries {
+			libraryRoot := path.Join(dataDirectory, library.Path)
+			if changedPath == libraryRoot || strings.HasPrefix(changedPath, libraryRoot+"/") {
+				pathsByLibrary[library] = append(pathsByLibrary[library], changedPath)
+				break
+			}
+		}
+	}
+	return pathsByLibrary
+}
@@ -0,0 +1,110 @@
+package watcher
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal/api"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/tasks"
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns a watcher of a 'Music' library, whose worker is paused so that the tasks stay pending
+func getTestWatcher(t *testing.T, debounce time.Duration, maxDebounce time.Duration) (*Watcher, *tasks.Worker) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"items":[{"id":1,"name":"Music","slug":"music","path":"Music"}],"metadata":{}}`))
+	}))
+	t.Cleanup(server.Close)
+	dataDirectory := t.TempDir()
+	assert.Nil(t, os.MkdirAll(path.Join(dataDirectory, "Music", "Artist"), 0755))
+	assert.Nil(t, os.MkdirAll(path.Join(dataDirectory, "Other"), 0755))
+	c := config.Config{ApiUrl: server.URL, DataDirectory: dataDirectory, ConfigDirectory: t.TempDir()}
+	worker := tasks.NewWorker()
+	worker.StartWorker(c)
+	t.Cleanup(worker.StopWorker)
+	worker.Pause()
+	w, err := NewWatcher(c, worker)
+	assert.Nil(t, err)
+	t.Cleanup(func() { w.Close() })
+	w.debounce = debounce
+	w.maxDebounce = maxDebounce
+	return w, worker
+}
+
+func getPendingTaskCount(worker *tasks.Worker) int {
+	_, pendingTasks := worker.GetCurrentTasks()
+	return len(pendingTasks)
+}
+
+func TestOnlyLibrariesAreWatched(t *testing.T) {
+	w, _ := getTestWatcher(t, DefaultDebounceDelay, MaxDebounceDelay)
+
+	assert.Nil(t, w.Start())
+
+	libraryRoot := path.Join(w.config.DataDirectory, "Music")
+	assert.ElementsMatch(t, []string{libraryRoot, path.Join(libraryRoot, "Artist")}, w.fsWatcher.WatchList())
+}
+
+func TestEventsAreDebounced(t *testing.T) {
+	w, worker := getTestWatcher(t, 50*time.Millisecond, time.Minute)
+	filePath := path.Join(w.config.DataDirectory, "Music", "Artist", "01 Track.mp3")
+
+	for range 3 {
+		w.handleEvent(fsnotify.Event{Name: filePath, Op: fsnotify.Write})
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 0, getPendingTaskCount(worker))
+	// A single task for the whole burst
+	assert.Eventually(t, func() bool { return getPendingTaskCount(worker) == 1 }, time.Second, 5*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 1, getPendingTaskCount(worker))
+}
+
+func TestEventsAreFlushedAfterMaxDelay(t *testing.T) {
+	w, worker := getTestWatcher(t, 50*time.Millisecond, 200*time.Millisecond)
+	filePath := path.Join(w.config.DataDirectory, "Music", "Artist", "01 Track.mp3")
+	start := time.Now()
+
+	// Events keep coming, so the debounce delay never ends
+	for getPendingTaskCount(worker) == 0 && time.Since(start) < time.Second {
+		w.handleEvent(fsnotify.Event{Name: filePath, Op: fsnotify.Write})
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 1, getPendingTaskCount(worker))
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestEventsOutsideLibrariesAreIgnored(t *testing.T) {
+	w, worker := getTestWatcher(t, 10*time.Millisecond, time.Minute)
+
+	w.handleEvent(fsnotify.Event{Name: path.Join(w.config.DataDirectory, "Other", "file.mp3"), Op: fsnotify.Create})
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 0, getPendingTaskCount(worker))
+}
+
+func TestGroupPathsByLibrary(t *testing.T) {
+	libraries := []api.Library{
+		{Id: 1, Slug: "music", Path: "Music"},
+		{Id: 2, Slug: "music-videos", Path: "Music Videos"},
+	}
+	changedPaths := []string{
+		"/data/Music/Artist/Album/01 Track.flac",
+		"/data/Music Videos/Artist/Video.mp4",
+		"/data/Music/Artist/Other Album",
+		"/data/Other/file.mp3",
+	}
+	groups := groupPathsByLibrary(changedPaths, libraries, "/data")
+
+	assert.Len(t, groups, 2)
+	assert.Equal(t, []string{
+		"/data/Music/Artist/Album/01 Track.flac",
+		"/data/Music/Artist/Other Album",
+	}, groups[libraries[0]])
+	assert.Equal(t, []string{"/data/Music Videos/Artist/Video.mp4"}, groups[libraries[1]])
+}
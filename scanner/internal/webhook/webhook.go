@@ -0,0 +1,212 @@
+package webhook
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/tasks"
+	"github.com/goccy/go-json"
+	"github.com/rs/zerolog/log"
+)
+
+// Number of times a payload is sent before giving up
+const MaxDeliveryAttempts = 5
+
+// Delay before the first retry. Doubled after each attempt
+const DefaultRetryDelay = time.Second
+
+// Number of payloads waiting to be sent to a webhook
+// If the webhook is too slow, payloads are dropped
+const deliveryQueueSize = 256
+
+// Maximum number of errors sent for a failed file
+const maxErrorCount = 10
+
+// JSON body POSTed to the webhooks
+// 'text' and 'content' hold the same human-readable message,
+// so that Slack, Discord and Matrix (hookshot) webhooks can use the payload as is
+type Payload struct {
+	Type     tasks.EventType `json:"type"`
+	TaskId   string          `json:"task_id"`
+	TaskName string          `json:"task_name"`
+	Time     time.Time       `json:"time"`
+	// For task-finished events
+	Status tasks.TaskStatus `json:"status,omitempty"`
+	// For task-finished events
+	Successful *int `json:"successful,omitempty"`
+	// For task-finished events
+	Failed *int `json:"failed,omitempty"`
+	// For task-finished events
+	Skipped *int `json:"skipped,omitempty"`
+	// For file-failed events. Full path of the file
+	File string `json:"file,omitempty"`
+	// For file-failed events
+	Errors  []string `json:"errors,omitempty"`
+	Text    string   `json:"text"`
+	Content string   `json:"content"`
+}
+
+type endpoint struct {
+	settings config.WebhookSettings
+	queue    chan Payload
+}
+
+// Forwards the events of the worker to the webhooks of the settings
+type Notifier struct {
+	endpoints   []endpoint
+	client      *http.Client
+	retryDelay  time.Duration
+	unsubscribe func()
+	wg          sync.WaitGroup
+}
+
+func NewNotifier(settings []config.WebhookSettings) *Notifier {
+	n := &Notifier{
+		client:     &http.Client{Timeout: 10 * time.Second},
+		retryDelay: DefaultRetryDelay,
+	}
+	for _, s := range settings {
+		n.endpoints = append(n.endpoints, endpoint{settings: s, queue: make(chan Payload, deliveryQueueSize)})
+	}
+	return n
+}
+
+// Subscribes to the events of the worker and starts sending them
+func (n *Notifier) Start(w *tasks.Worker) {
+	events, unsubscribe := w.Subscribe()
+	n.unsubscribe = unsubscribe
+	n.listen(events)
+}
+
+// Sends the events until the channel is closed
+func (n *Notifier) listen(events <-chan tasks.Event) {
+	for _, e := range n.endpoints {
+		n.wg.Add(1)
+		go n.deliver(e)
+	}
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		for event := range events {
+			n.dispatch(event)
+		}
+		for _, e := range n.endpoints {
+			close(e.queue)
+		}
+	}()
+}
+
+// Stops listening to events, and waits for the pending payloads to be sent
+func (n *Notifier) Stop() {
+	if n.unsubscribe != nil {
+		n.unsubscribe()
+	}
+	n.wg.Wait()
+}
+
+func (n *Notifier) dispatch(event tasks.Event) {
+	payload, ok := newPayload(event)
+	if !ok {
+		return
+	}
+	for _, e := range n.endpoints {
+		if len(e.settings.Events) > 0 && !slices.Contains(e.settings.Events, config.WebhookEvent(event.Type)) {
+			continue
+		}
+		select {
+		case e.queue <- payload:
+		default:
+			log.Warn().Str("url", e.settings.Url).Msg("Webhook is too slow, dropping event")
+		}
+	}
+}
+
+func (n *Notifier) deliver(e endpoint) {
+	defer n.wg.Done()
+	for payload := range e.queue {
+		if err := n.send(e.settings, payload); err != nil {
+			log.Error().Str("url", e.settings.Url).Err(err).Msg("Could not send webhook")
+		}
+	}
+}
+
+// Sends the payload, retrying with an exponential backoff on network errors, 429 and 5xx
+func (n *Notifier) send(settings config.WebhookSettings, payload Payload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	delay := n.retryDelay
+	for attempt := 1; ; attempt++ {
+		retryable, err := n.post(settings, body)
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempt == MaxDeliveryAttempts {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// Returns whether the request should be retried if it failed
+func (n *Notifier) post(settings config.WebhookSettings, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, settings.Url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range settings.Headers {
+		req.Header.Set(key, value)
+	}
+	res, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	res.Body.Close()
+	if res.StatusCode < 300 {
+		return false, nil
+	}
+	retryable := res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+	return retryable, fmt.Errorf("webhook responded with status %d", res.StatusCode)
+}
+
+// Returns false if the event should not be sent to webhooks
+func newPayload(event tasks.Event) (Payload, bool) {
+	payload := Payload{
+		Type:     event.Type,
+		TaskId:   event.TaskId,
+		TaskName: event.TaskName,
+		Time:     event.Time,
+	}
+	switch event.Type {
+	case tasks.TaskFinished:
+		payload.Status = event.Status
+		payload.Successful = &event.Report.Successful
+		payload.Failed = &event.Report.Failed
+		payload.Skipped = &event.Report.Skipped
+		payload.Text = fmt.Sprintf("%s: %s (successful: %d, failed: %d, skipped: %d)",
+			event.TaskName, event.Status, event.Report.Successful, event.Report.Failed, event.Report.Skipped)
+	case tasks.FileFailed:
+		payload.File = event.File
+		payload.Errors = event.Errors
+		if len(payload.Errors) > maxErrorCount {
+			payload.Errors = payload.Errors[:maxErrorCount]
+		}
+		payload.Text = fmt.Sprintf("%s: could not process '%s'", event.TaskName, event.File)
+		if len(payload.Errors) > 0 {
+			payload.Text += "\n" + strings.Join(payload.Errors, "\n")
+		}
+	default:
+		return Payload{}, false
+	}
+	payload.Content = payload.Text
+	return payload, true
+}
@@ -0,0 +1,136 @@
+package webhook
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Arthi-chaud/Meelo/scanner/internal/config"
+	"github.com/Arthi-chaud/Meelo/scanner/internal/tasks"
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+)
+
+type receivedRequest struct {
+	payload map[string]any
+	header  http.Header
+}
+
+// Returns a server that responds with the given statuses, then 200
+func newTestServer(statuses ...int) (*httptest.Server, func() []receivedRequest) {
+	var mu sync.Mutex
+	received := []receivedRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		payload := map[string]any{}
+		json.Unmarshal(body, &payload)
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, receivedRequest{payload: payload, header: r.Header})
+		if len(received) <= len(statuses) {
+			w.WriteHeader(statuses[len(received)-1])
+		}
+	}))
+	return server, func() []receivedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return received
+	}
+}
+
+// Sends the events through the notifier, and waits for them to be delivered
+func notify(n *Notifier, events ...tasks.Event) {
+	n.retryDelay = time.Millisecond
+	channel := make(chan tasks.Event, len(events))
+	for _, event := range events {
+		channel <- event
+	}
+	close(channel)
+	n.listen(channel)
+	n.wg.Wait()
+}
+
+func TestTaskFinishedPayload(t *testing.T) {
+	server, received := newTestServer()
+	defer server.Close()
+	n := NewNotifier([]config.WebhookSettings{{Url: server.URL, Headers: map[string]string{"Authorization": "Bearer token"}}})
+
+	notify(n, tasks.Event{
+		Type:     tasks.TaskFinished,
+		TaskId:   "abc",
+		TaskName: "Scan library 'music'",
+		Status:   tasks.Done,
+		Report:   tasks.TaskReport{Successful: 3, Failed: 1, Skipped: 0},
+	})
+
+	requests := received()
+	assert.Len(t, requests, 1)
+	assert.Equal(t, "Bearer token", requests[0].header.Get("Authorization"))
+	assert.Equal(t, "application/json", requests[0].header.Get("Content-Type"))
+	payload := requests[0].payload
+	assert.Equal(t, "task-finished", payload["type"])
+	assert.Equal(t, "abc", payload["task_id"])
+	assert.Equal(t, "done", payload["status"])
+	assert.Equal(t, float64(3), payload["successful"])
+	assert.Equal(t, float64(1), payload["failed"])
+	assert.Equal(t, float64(0), payload["skipped"])
+	assert.Equal(t, "Scan library 'music': done (successful: 3, failed: 1, skipped: 0)", payload["content"])
+	assert.Equal(t, payload["content"], payload["text"])
+}
+
+func TestOnlySelectedEventsAreSent(t *testing.T) {
+	server, received := newTestServer()
+	defer server.Close()
+	n := NewNotifier([]config.WebhookSettings{{Url: server.URL, Events: []config.WebhookEvent{config.FileFailedWebhook}}})
+
+	notify(n,
+		tasks.Event{Type: tasks.TaskStarted, TaskId: "abc"},
+		tasks.Event{Type: tasks.FileParsed, TaskId: "abc", File: "/data/a.mp3"},
+		tasks.Event{Type: tasks.FileFailed, TaskId: "abc", File: "/data/b.mp3", Errors: []string{"no album"}},
+		tasks.Event{Type: tasks.TaskFinished, TaskId: "abc", Status: tasks.Done},
+	)
+
+	requests := received()
+	assert.Len(t, requests, 1)
+	assert.Equal(t, "file-failed", requests[0].payload["type"])
+	assert.Equal(t, "/data/b.mp3", requests[0].payload["file"])
+	assert.Equal(t, []any{"no album"}, requests[0].payload["errors"])
+	assert.Nil(t, requests[0].payload["successful"])
+}
+
+func TestDeliveryIsRetriedOnServerError(t *testing.T) {
+	server, received := newTestServer(http.StatusInternalServerError, http.StatusBadGateway)
+	defer server.Close()
+	n := NewNotifier([]config.WebhookSettings{{Url: server.URL}})
+
+	notify(n, tasks.Event{Type: tasks.TaskFinished, TaskId: "abc", Status: tasks.Failed})
+
+	assert.Len(t, received(), 3)
+}
+
+func TestDeliveryIsNotRetriedOnClientError(t *testing.T) {
+	server, received := newTestServer(http.StatusNotFound)
+	defer server.Close()
+	n := NewNotifier([]config.WebhookSettings{{Url: server.URL}})
+
+	notify(n, tasks.Event{Type: tasks.TaskFinished, TaskId: "abc", Status: tasks.Done})
+
+	assert.Len(t, received(), 1)
+}
+
+func TestDeliveryIsAbandonedAfterMaxAttempts(t *testing.T) {
+	statuses := make([]int, MaxDeliveryAttempts+1)
+	for i := range statuses {
+		statuses[i] = http.StatusServiceUnavailable
+	}
+	server, received := newTestServer(statuses...)
+	defer server.Close()
+	n := NewNotifier([]config.WebhookSettings{{Url: server.URL}})
+
+	notify(n, tasks.Event{Type: tasks.TaskFinished, TaskId: "abc", Status: tasks.Done})
+
+	assert.Len(t, received(), MaxDeliveryAttempts)
+}
@@ -0,0 +1,13 @@
+-- AlterTable
+ALTER TABLE "releases" ADD COLUMN     "barcode" TEXT,
+ADD COLUMN     "catalogNumber" TEXT,
+ADD COLUMN     "label" TEXT,
+ADD COLUMN     "musicbrainzAlbumArtistIds" TEXT[],
+ADD COLUMN     "musicbrainzReleaseGroupId" TEXT,
+ADD COLUMN     "musicbrainzReleaseId" TEXT;
+
+-- AlterTable
+ALTER TABLE "tracks" ADD COLUMN     "isrc" TEXT,
+ADD COLUMN     "musicbrainzArtistIds" TEXT[],
+ADD COLUMN     "musicbrainzRecordingId" TEXT,
+ADD COLUMN     "musicbrainzTrackId" TEXT;
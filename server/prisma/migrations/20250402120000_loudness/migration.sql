@@ -0,0 +1,5 @@
+-- AlterTable
+ALTER TABLE "tracks" ADD COLUMN     "albumGain" DOUBLE PRECISION,
+ADD COLUMN     "albumPeak" DOUBLE PRECISION,
+ADD COLUMN     "trackGain" DOUBLE PRECISION,
+ADD COLUMN     "trackPeak" DOUBLE PRECISION;
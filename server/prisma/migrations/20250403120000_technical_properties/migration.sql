@@ -0,0 +1,13 @@
+-- AlterTable
+ALTER TABLE "tracks" ADD COLUMN     "audioCodec" TEXT,
+ADD COLUMN     "bitDepth" INTEGER,
+ADD COLUMN     "channelLayout" TEXT,
+ADD COLUMN     "channels" INTEGER,
+ADD COLUMN     "container" TEXT,
+ADD COLUMN     "frameRate" DOUBLE PRECISION,
+ADD COLUMN     "hdrFormat" TEXT,
+ADD COLUMN     "height" INTEGER,
+ADD COLUMN     "lossless" BOOLEAN,
+ADD COLUMN     "sampleRate" INTEGER,
+ADD COLUMN     "videoCodec" TEXT,
+ADD COLUMN     "width" INTEGER;
@@ -203,4 +203,53 @@ describe("File Controller", () => {
 			});
 		});
 	});
+
+	describe("Move File", () => {
+		it("should update the path and the checksum of the file", async () => {
+			return request(app.getHttpServer())
+				.put(`/files/${dummyRepository.fileA2_1.id}`)
+				.send({ path: "Artist A/Album B/Moved.m4a", checksum: "moved" })
+				.expect(200)
+				.expect((res) => {
+					const file: File = res.body;
+					expect(file.id).toBe(dummyRepository.fileA2_1.id);
+					expect(file.path).toBe("Artist A/Album B/Moved.m4a");
+					expect(file.checksum).toBe("moved");
+					expect(file.libraryId).toBe(
+						dummyRepository.fileA2_1.libraryId,
+					);
+				});
+		});
+		it("should keep the track of the file", async () => {
+			return request(app.getHttpServer())
+				.get(`/files?track=${dummyRepository.trackA2_1.id}`)
+				.expect(200)
+				.expect((res) => {
+					const files: File[] = res.body.items;
+					expect(files.length).toBe(1);
+					expect(files[0].path).toBe("Artist A/Album B/Moved.m4a");
+				});
+		});
+		it("should return an error, as another file has the path", async () => {
+			return request(app.getHttpServer())
+				.put(`/files/${dummyRepository.fileA2_1.id}`)
+				.send({
+					path: dummyRepository.fileB1_1.path,
+					checksum: "moved",
+				})
+				.expect(409);
+		});
+		it("should return an error, as the file does not exist", async () => {
+			return request(app.getHttpServer())
+				.put(`/files/${-1}`)
+				.send({ path: "a.m4a", checksum: "a" })
+				.expect(404);
+		});
+		it("should return an error, as the path is missing", async () => {
+			return request(app.getHttpServer())
+				.put(`/files/${dummyRepository.fileA2_1.id}`)
+				.send({ checksum: "a" })
+				.expect(400);
+		});
+	});
 });
@@ -16,7 +16,7 @@
  * along with this program.  If not, see <http://www.gnu.org/licenses/>.
  */
 
-import { Body, Controller, Delete, Get, Query } from "@nestjs/common";
+import { Body, Controller, Delete, Get, Put, Query } from "@nestjs/common";
 import { ApiOperation, ApiPropertyOptional, ApiTags } from "@nestjs/swagger";
 import { IsOptional } from "class-validator";
 import AlbumService from "src/album/album.service";
@@ -41,6 +41,7 @@ import type TrackQueryParameters from "src/track/models/track.query-parameters";
 import TrackService from "src/track/track.service";
 import FileService from "./file.service";
 import FileDeletionDto from "./models/file-deletion.dto";
+import FileMoveDto from "./models/file-move.dto";
 import FileQueryParameters from "./models/file.query-parameters";
 
 class Selector {
@@ -127,6 +128,24 @@ export default class FileController {
 		return this.fileService.getMany(selector, paginationParameters);
 	}
 
+	@ApiOperation({
+		summary: "Move a file entry",
+		description:
+			"Updates the path and the checksum of the file, keeping its track",
+	})
+	@Role(Roles.Admin, Roles.Microservice)
+	@Put(":idOrSlug")
+	move(
+		@IdentifierParam(FileService)
+		where: FileQueryParameters.WhereInput,
+		@Body() dto: FileMoveDto,
+	): Promise<File> {
+		return this.fileService.update(where, {
+			path: dto.path,
+			checksum: dto.checksum,
+		});
+	}
+
 	@ApiOperation({
 		summary: "Delete multiple file entries",
 	})
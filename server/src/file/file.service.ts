@@ -74,14 +74,25 @@ export default class FileService {
 	}
 	async update(
 		where: FileQueryParameters.WhereInput,
-		what: Pick<File, "checksum" | "registerDate">,
+		what: Partial<Pick<File, "checksum" | "registerDate" | "path">>,
 	) {
 		return this.prismaService.file
 			.update({
 				where: FileService.formatWhereInput(where),
 				data: what,
 			})
-			.catch((error) => {
+			.catch(async (error) => {
+				if (
+					what.path !== undefined &&
+					error instanceof Prisma.PrismaClientKnownRequestError &&
+					error.code === PrismaError.UniqueConstraintViolation
+				) {
+					const file = await this.get(where);
+					throw new FileAlreadyExistsException(
+						what.path,
+						file.libraryId,
+					);
+				}
 				throw this.onNotFound(error, where);
 			});
 	}
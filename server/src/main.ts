@@ -17,6 +17,7 @@
  */
 
 import { NestFactory } from "@nestjs/core";
+import type { NestExpressApplication } from "@nestjs/platform-express";
 import AppModule from "./app.module";
 import * as Plugins from "./app.plugins";
 import Logger from "./logger/logger";
@@ -24,7 +25,7 @@ import bootstrapSwagger from "./swagger/bootstrap";
 
 async function bootstrap() {
 	Plugins.presetup();
-	const app = await NestFactory.create(AppModule, {
+	const app = await NestFactory.create<NestExpressApplication>(AppModule, {
 		cors: process.env.NODE_ENV === "development",
 		logger: new Logger(),
 	});
@@ -33,6 +34,8 @@ async function bootstrap() {
 		.useGlobalPipes(...Plugins.buildPipes(app))
 		.useGlobalInterceptors(...Plugins.buildInterceptors(app))
 		.use(...Plugins.buildHttpPlugs(app));
+	// Batches of metadata from the scanner are larger than the default limit
+	app.useBodyParser("json", { limit: "10mb" });
 	await bootstrapSwagger(app);
 	await app.listen(4000);
 }
@@ -22,6 +22,7 @@ import AlbumService from "src/album/album.service";
 import ArtistService from "src/artist/artist.service";
 import GenreService from "src/genre/genre.service";
 import type { File } from "src/prisma/models";
+import type ReleaseQueryParameters from "src/release/models/release.query-parameters";
 import ReleaseService from "src/release/release.service";
 import Slug from "src/slug/slug";
 import SongService from "src/song/song.service";
@@ -66,7 +67,11 @@ export default class MetadataService {
 		);
 		const albumArtist = !metadata.compilation
 			? await this.artistService.getOrCreate({
-					name: metadata.albumArtist ?? metadata.artist!,
+					// Albums only have one artist, so the name is kept as is
+					name:
+						metadata.albumArtist ??
+						metadata.albumArtists?.at(0) ??
+						metadata.artist!,
 					registeredAt: file.registerDate,
 				})
 			: undefined;
@@ -74,10 +79,19 @@ export default class MetadataService {
 			await this.parserService.extractFeaturedArtistsFromSongName(
 				metadata.name,
 			);
-		parsedFeaturingArtists.push(...(metadata.featuring ?? []));
-		let parsedArtistName = metadata.artist;
+		// Songs only have one main artist, so the other ones are featured
+		const [mainArtistName, ...otherMainArtists] = metadata.artists ?? [];
+		parsedFeaturingArtists.push(
+			...otherMainArtists,
+			...(metadata.featuring ?? []),
+		);
+		let parsedArtistName = mainArtistName ?? metadata.artist;
 
-		if (metadata.artist !== albumArtist?.name) {
+		// If the artists are listed, their names are already split
+		if (
+			!metadata.artists?.length &&
+			metadata.artist !== albumArtist?.name
+		) {
 			const { artist, featuring } =
 				await this.parserService.extractFeaturedArtistsFromArtistName(
 					metadata.artist,
@@ -196,6 +210,14 @@ export default class MetadataService {
 				)
 			: undefined;
 		//TODO Link to album
+		const releaseIdentifiers: ReleaseQueryParameters.Identifiers = {
+			musicbrainzReleaseId: metadata.musicbrainzReleaseId,
+			musicbrainzReleaseGroupId: metadata.musicbrainzReleaseGroupId,
+			musicbrainzAlbumArtistIds: metadata.musicbrainzAlbumArtistIds,
+			barcode: metadata.barcode,
+			catalogNumber: metadata.catalogNumber,
+			label: metadata.label,
+		};
 		const parsedReleaseName = metadata.release
 			? this.parserService.parseReleaseExtension(metadata.release)
 			: undefined;
@@ -209,6 +231,7 @@ export default class MetadataService {
 							album: { id: album.id },
 							registeredAt: file.registerDate,
 							discogsId: metadata.discogsId,
+							...releaseIdentifiers,
 						},
 						{ album: true },
 					)
@@ -237,6 +260,26 @@ export default class MetadataService {
 					? { id: song.id }
 					: undefined,
 			video: video ? { id: video.id } : undefined,
+			musicbrainzRecordingId: metadata.musicbrainzRecordingId,
+			musicbrainzTrackId: metadata.musicbrainzTrackId,
+			musicbrainzArtistIds: metadata.musicbrainzArtistIds,
+			isrc: metadata.isrc,
+			trackGain: metadata.trackGain,
+			trackPeak: metadata.trackPeak,
+			albumGain: metadata.albumGain,
+			albumPeak: metadata.albumPeak,
+			container: metadata.container,
+			audioCodec: metadata.audioCodec,
+			lossless: metadata.lossless,
+			sampleRate: metadata.sampleRate,
+			bitDepth: metadata.bitDepth,
+			channels: metadata.channels,
+			channelLayout: metadata.channelLayout,
+			videoCodec: metadata.videoCodec,
+			width: metadata.width,
+			height: metadata.height,
+			frameRate: metadata.frameRate,
+			hdrFormat: metadata.hdrFormat,
 		};
 		if (release && album) {
 			if (
@@ -264,6 +307,21 @@ export default class MetadataService {
 					{ id: release.id },
 				);
 			}
+			// The release may have been created from a file without these identifiers
+			const missingIdentifiers: ReleaseQueryParameters.Identifiers =
+				Object.fromEntries(
+					Object.entries(releaseIdentifiers).filter(
+						([key, value]) =>
+							value?.length &&
+							!release[key as keyof typeof releaseIdentifiers]
+								?.length,
+					),
+				);
+			if (Object.keys(missingIdentifiers).length) {
+				await this.releaseService.update(missingIdentifiers, {
+					id: release.id,
+				});
+			}
 		}
 		if (overwrite) {
 			await this.trackService.delete([{ sourceFileId: file.id }]);
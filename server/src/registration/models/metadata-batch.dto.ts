@@ -0,0 +1,63 @@
+/*
+ * Meelo is a music server and application to enjoy your personal music files anywhere, anytime you want.
+ * Copyright (C) 2023
+ *
+ * Meelo is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Meelo is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import { ApiProperty, ApiPropertyOptional } from "@nestjs/swagger";
+import { Type } from "class-transformer";
+import {
+	ArrayMaxSize,
+	ArrayNotEmpty,
+	IsArray,
+	IsDefined,
+	ValidateNested,
+} from "class-validator";
+import MetadataSavedResponse from "./metadata-saved.dto";
+import MetadataDto from "./metadata.dto";
+
+export default class MetadataBatchDto {
+	@ApiProperty({
+		description:
+			"The metadata of the files. If one of them is invalid, none of them is saved",
+		type: MetadataDto,
+		isArray: true,
+	})
+	@IsDefined()
+	@IsArray()
+	@ArrayNotEmpty()
+	@ArrayMaxSize(50)
+	@Type(() => MetadataDto)
+	@ValidateNested({ each: true })
+	items: MetadataDto[];
+}
+
+export class MetadataBatchResult extends MetadataSavedResponse {
+	@ApiPropertyOptional({
+		description:
+			"Set if the file could not be saved. The other fields are then not set",
+	})
+	error?: string;
+}
+
+export class MetadataBatchResponse {
+	@ApiProperty({
+		description:
+			"The outcome of each file, in the same order as the request",
+		type: MetadataBatchResult,
+		isArray: true,
+	})
+	items: Partial<MetadataBatchResult>[];
+}
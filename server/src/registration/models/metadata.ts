@@ -25,12 +25,15 @@ import {
 	IsDate,
 	IsDefined,
 	IsEnum,
+	IsInt,
 	IsNotEmpty,
 	IsNumber,
 	IsOptional,
 	IsPositive,
 	IsString,
+	IsUUID,
 	Matches,
+	Min,
 	MinLength,
 } from "class-validator";
 
@@ -43,7 +46,8 @@ export default class Metadata {
 	 */
 	@ApiProperty()
 	@IsBoolean()
-	@Transform(({ obj, key }) => obj[key] === "true")
+	// Booleans are strings in forms, but not in JSON bodies
+	@Transform(({ obj, key }) => obj[key] === true || obj[key] === "true")
 	@IsDefined()
 	compilation: boolean;
 
@@ -56,6 +60,23 @@ export default class Metadata {
 	@ApiProperty()
 	artist: string;
 
+	/**
+	 * Names of the main artists of the track, in order
+	 * The first one is the artist of the track
+	 */
+	@ApiPropertyOptional({
+		description:
+			"Main artists of the track, in order. If set, they are not split again. The first one is the artist of the track, the other ones are featured",
+	})
+	@IsArray()
+	@IsOptional()
+	@IsString({ each: true })
+	@IsNotEmpty({ each: true })
+	artists?: string[];
+
+	/**
+	 * Names of the featured artists of the track
+	 */
 	@ApiPropertyOptional()
 	@IsArray()
 	@IsOptional()
@@ -71,6 +92,31 @@ export default class Metadata {
 	@IsOptional()
 	albumArtist?: string;
 
+	/**
+	 * Names of the main artists of the parent album, in order
+	 * The first one is the artist of the album
+	 */
+	@ApiPropertyOptional({
+		description:
+			"Main artists of the parent album, in order. Only used if 'albumArtist' is not set, in which case the first one is the artist of the album",
+	})
+	@IsArray()
+	@IsOptional()
+	@IsString({ each: true })
+	@IsNotEmpty({ each: true })
+	albumArtists?: string[];
+
+	/**
+	 * Names of the featured artists of the parent album
+	 */
+	@ApiPropertyOptional({
+		description: "Featured artists of the parent album. Not stored",
+	})
+	@IsArray()
+	@IsOptional()
+	@IsString({ each: true })
+	featuredAlbumArtists?: string[];
+
 	/**
 	 * Name of the album of the track
 	 */
@@ -194,4 +240,232 @@ export default class Metadata {
 	@IsOptional()
 	@ApiProperty()
 	bpm?: number;
+
+	/**
+	 * MusicBrainz ID of the recording
+	 */
+	@ApiPropertyOptional()
+	@IsUUID("all")
+	@IsOptional()
+	musicbrainzRecordingId?: string;
+
+	/**
+	 * MusicBrainz ID of the track, on its release
+	 */
+	@ApiPropertyOptional()
+	@IsUUID("all")
+	@IsOptional()
+	musicbrainzTrackId?: string;
+
+	/**
+	 * MusicBrainz ID of the parent release
+	 */
+	@ApiPropertyOptional()
+	@IsUUID("all")
+	@IsOptional()
+	musicbrainzReleaseId?: string;
+
+	/**
+	 * MusicBrainz ID of the release group of the parent release
+	 */
+	@ApiPropertyOptional()
+	@IsUUID("all")
+	@IsOptional()
+	musicbrainzReleaseGroupId?: string;
+
+	/**
+	 * MusicBrainz IDs of the artists of the track, in order
+	 */
+	@ApiPropertyOptional()
+	@IsArray()
+	@IsUUID("all", { each: true })
+	@IsOptional()
+	musicbrainzArtistIds?: string[];
+
+	/**
+	 * MusicBrainz IDs of the artists of the parent release, in order
+	 */
+	@ApiPropertyOptional()
+	@IsArray()
+	@IsUUID("all", { each: true })
+	@IsOptional()
+	musicbrainzAlbumArtistIds?: string[];
+
+	/**
+	 * International Standard Recording Code of the track
+	 */
+	@ApiPropertyOptional()
+	@IsString()
+	@IsNotEmpty()
+	@IsOptional()
+	isrc?: string;
+
+	/**
+	 * Barcode of the parent release
+	 */
+	@ApiPropertyOptional()
+	@IsString()
+	@IsNotEmpty()
+	@IsOptional()
+	barcode?: string;
+
+	/**
+	 * Catalog number of the parent release
+	 */
+	@ApiPropertyOptional()
+	@IsString()
+	@IsNotEmpty()
+	@IsOptional()
+	catalogNumber?: string;
+
+	/**
+	 * Label of the parent release
+	 */
+	@ApiPropertyOptional()
+	@IsString()
+	@IsNotEmpty()
+	@IsOptional()
+	label?: string;
+
+	/**
+	 * ReplayGain gain of the track, in dB
+	 */
+	@ApiPropertyOptional()
+	@IsNumber()
+	@IsOptional()
+	trackGain?: number;
+
+	/**
+	 * ReplayGain peak of the track (linear)
+	 */
+	@ApiPropertyOptional()
+	@IsNumber()
+	@Min(0)
+	@IsOptional()
+	trackPeak?: number;
+
+	/**
+	 * ReplayGain gain of the parent release, in dB
+	 */
+	@ApiPropertyOptional()
+	@IsNumber()
+	@IsOptional()
+	albumGain?: number;
+
+	/**
+	 * ReplayGain peak of the parent release (linear)
+	 */
+	@ApiPropertyOptional()
+	@IsNumber()
+	@Min(0)
+	@IsOptional()
+	albumPeak?: number;
+
+	/**
+	 * Container of the file, as named by ffmpeg (e.g. 'flac')
+	 */
+	@ApiPropertyOptional()
+	@IsString()
+	@IsNotEmpty()
+	@IsOptional()
+	container?: string;
+
+	/**
+	 * Codec of the audio stream, as named by ffmpeg (e.g. 'aac')
+	 */
+	@ApiPropertyOptional()
+	@IsString()
+	@IsNotEmpty()
+	@IsOptional()
+	audioCodec?: string;
+
+	/**
+	 * If the audio codec is lossless
+	 */
+	@ApiPropertyOptional()
+	@IsBoolean()
+	@Transform(({ obj, key }) => obj[key] === true || obj[key] === "true")
+	@IsOptional()
+	lossless?: boolean;
+
+	/**
+	 * Sample rate of the audio stream, in Hz
+	 */
+	@ApiPropertyOptional()
+	@IsPositive()
+	@IsInt()
+	@IsOptional()
+	sampleRate?: number;
+
+	/**
+	 * Bit depth of the audio stream, for lossless codecs
+	 */
+	@ApiPropertyOptional()
+	@IsPositive()
+	@IsInt()
+	@IsOptional()
+	bitDepth?: number;
+
+	/**
+	 * Number of channels of the audio stream
+	 */
+	@ApiPropertyOptional()
+	@IsPositive()
+	@IsInt()
+	@IsOptional()
+	channels?: number;
+
+	/**
+	 * Channel layout of the audio stream, as named by ffmpeg (e.g. 'stereo')
+	 */
+	@ApiPropertyOptional()
+	@IsString()
+	@IsNotEmpty()
+	@IsOptional()
+	channelLayout?: string;
+
+	/**
+	 * Codec of the video stream, as named by ffmpeg (e.g. 'h264')
+	 */
+	@ApiPropertyOptional()
+	@IsString()
+	@IsNotEmpty()
+	@IsOptional()
+	videoCodec?: string;
+
+	/**
+	 * Width of the video stream, in pixels
+	 */
+	@ApiPropertyOptional()
+	@IsPositive()
+	@IsInt()
+	@IsOptional()
+	width?: number;
+
+	/**
+	 * Height of the video stream, in pixels
+	 */
+	@ApiPropertyOptional()
+	@IsPositive()
+	@IsInt()
+	@IsOptional()
+	height?: number;
+
+	/**
+	 * Frame rate of the video stream
+	 */
+	@ApiPropertyOptional()
+	@IsPositive()
+	@IsNumber()
+	@IsOptional()
+	frameRate?: number;
+
+	/**
+	 * HDR format of the video stream (e.g. 'HDR10' or 'Dolby Vision')
+	 */
+	@ApiPropertyOptional()
+	@IsString()
+	@IsNotEmpty()
+	@IsOptional()
+	hdrFormat?: string;
 }
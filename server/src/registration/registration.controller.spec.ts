@@ -20,6 +20,7 @@ import SetupApp from "test/setup-app";
 import { createTestingModule } from "test/test-module";
 import TestPrismaService from "test/test-prisma.service";
 import LibraryModule from "../library/library.module";
+import type { MetadataBatchResponse } from "./models/metadata-batch.dto";
 import type MetadataSavedResponse from "./models/metadata-saved.dto";
 import type MetadataDto from "./models/metadata.dto";
 import { MetadataController } from "./registration.controller";
@@ -228,6 +229,114 @@ describe("Registration Controller", () => {
 			});
 			expect(track.releaseId).toBeNull();
 		});
+
+		it("Should not split listed artists", async () => {
+			const res = await applyFormFields(
+				request(app.getHttpServer()).post("/metadata"),
+				{
+					...validMetadata,
+					artist: "Simon & Garfunkel",
+					artists: ["Simon & Garfunkel"],
+					// Otherwise, the artist is not split anyway
+					albumArtist: "Paul Simon",
+					featuring: undefined,
+					name: "The Boxer",
+					album: undefined,
+					release: undefined,
+					path: "test/assets/Music/The Boxer.m4a",
+				},
+			).expect(201);
+			const createdMetadata: MetadataSavedResponse = res.body;
+			const song = await songService.get(
+				{ id: createdMetadata.songId! },
+				{ artist: true, featuring: true },
+			);
+			expect(song.artist.name).toBe("Simon & Garfunkel");
+			expect(song.featuring).toHaveLength(0);
+		});
+
+		it("Should feature the other listed main artists", async () => {
+			const res = await applyFormFields(
+				request(app.getHttpServer()).post("/metadata"),
+				{
+					...validMetadata,
+					artist: "Paul Simon",
+					artists: ["Paul Simon", "Art Garfunkel"],
+					albumArtist: "Paul Simon",
+					featuring: undefined,
+					name: "The Sound of Silence",
+					album: undefined,
+					release: undefined,
+					path: "test/assets/Music/The Sound of Silence.m4a",
+				},
+			).expect(201);
+			const createdMetadata: MetadataSavedResponse = res.body;
+			const song = await songService.get(
+				{ id: createdMetadata.songId! },
+				{ artist: true, featuring: true },
+			);
+			expect(song.artist.name).toBe("Paul Simon");
+			expect(song.featuring.map(({ name }) => name)).toStrictEqual([
+				"Art Garfunkel",
+			]);
+		});
+
+		it("Should save the identifiers and the loudness", async () => {
+			const res = await applyFormFields(
+				request(app.getHttpServer()).post("/metadata"),
+				{
+					...validMetadata,
+					name: "Sometimes",
+					index: 3,
+					path: "test/assets/Music/Album/03 Sometimes.m4a",
+					musicbrainzRecordingId:
+						"b7a2ee2b-ab32-4c4b-9a14-1f5c0f0a2b3d",
+					musicbrainzArtistIds: [
+						"45a663b5-b1cb-4a91-bff6-2bef7bbfdd76",
+					],
+					isrc: "USJI19910455",
+					trackGain: -7.5,
+					trackPeak: 0.98,
+					// The release was created without them
+					musicbrainzReleaseId:
+						"4b1e3ad2-1bd8-4f68-8c38-3c2c4b8b5e0a",
+					barcode: "5099749534728",
+					label: "Jive",
+				},
+			).expect(201);
+			const createdMetadata: MetadataSavedResponse = res.body;
+			const track = await trackService.get(
+				{ id: createdMetadata.trackId },
+				{ release: true },
+			);
+			expect(track.musicbrainzRecordingId).toBe(
+				"b7a2ee2b-ab32-4c4b-9a14-1f5c0f0a2b3d",
+			);
+			expect(track.musicbrainzArtistIds).toStrictEqual([
+				"45a663b5-b1cb-4a91-bff6-2bef7bbfdd76",
+			]);
+			expect(track.isrc).toBe("USJI19910455");
+			expect(track.trackGain).toBe(-7.5);
+			expect(track.trackPeak).toBe(0.98);
+			expect(track.albumGain).toBeNull();
+			expect(track.release!.musicbrainzReleaseId).toBe(
+				"4b1e3ad2-1bd8-4f68-8c38-3c2c4b8b5e0a",
+			);
+			expect(track.release!.barcode).toBe("5099749534728");
+			expect(track.release!.label).toBe("Jive");
+			expect(track.release!.catalogNumber).toBeNull();
+		});
+
+		it("Should reject malformed MusicBrainz IDs", () => {
+			return applyFormFields(
+				request(app.getHttpServer()).post("/metadata"),
+				{
+					...validMetadata,
+					path: "test/assets/Music/Album/04 Invalid.m4a",
+					musicbrainzTrackId: "not-an-id",
+				},
+			).expect(400);
+		});
 	});
 	describe("Metadata Update", () => {
 		it("Should update metadata", async () => {
@@ -256,4 +365,44 @@ describe("Registration Controller", () => {
 			expect(file.path).toBe("Album/01 ...Baby One More Time.m4a");
 		});
 	});
+	describe("Batch Registration", () => {
+		it("Should register metadata of several files", async () => {
+			const res = await request(app.getHttpServer())
+				.post("/metadata/batch")
+				.send({
+					items: [
+						{
+							...validMetadata,
+							index: 2,
+							path: "test/assets/Music/Album/02 Batch.m4a",
+							checksum: "batch",
+						},
+						{
+							...validMetadata,
+							path: "/not/in/a/library.m4a",
+						},
+					],
+				})
+				.expect(201);
+			const results: MetadataBatchResponse = res.body;
+			expect(results.items.length).toBe(2);
+			expect(results.items[0].trackId).toBeGreaterThan(0);
+			expect(results.items[0].error).toBeUndefined();
+			expect(results.items[1].trackId).toBeUndefined();
+			expect(results.items[1].error).toBeDefined();
+			const file = await fileService.get(
+				{ id: results.items[0].sourceFileId! },
+				{ track: true },
+			);
+			expect(file.path).toBe("Album/02 Batch.m4a");
+			expect(file.track!.trackIndex).toBe(2);
+		});
+
+		it("Should reject the batch if a file is invalid", () => {
+			return request(app.getHttpServer())
+				.post("/metadata/batch")
+				.send({ items: [{ ...validMetadata, name: "" }] })
+				.expect(400);
+		});
+	});
 });
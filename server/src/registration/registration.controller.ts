@@ -21,6 +21,9 @@ import { ApiConsumes, ApiOperation, ApiTags } from "@nestjs/swagger";
 import { FormDataRequest, MemoryStoredFile } from "nestjs-form-data";
 import { Role } from "src/authentication/roles/roles.decorators";
 import RoleEnum from "src/authentication/roles/roles.enum";
+import MetadataBatchDto, {
+	type MetadataBatchResponse,
+} from "./models/metadata-batch.dto";
 import MetadataDto from "./models/metadata.dto";
 import { RegistrationService } from "./registration.service";
 
@@ -53,4 +56,30 @@ export class MetadataController {
 	async updateFile(@Body() metadata: MetadataDto) {
 		return this.registrationService.updateMetadata(metadata);
 	}
+
+	@ApiOperation({
+		summary: "Submit new files and their metadata",
+		description:
+			"Handles the metadata of several media files, in order. A file that cannot be registered does not prevent the next ones from being registered",
+	})
+	@Post("batch")
+	@Role(RoleEnum.Microservice, RoleEnum.Admin)
+	async saveFiles(
+		@Body() batch: MetadataBatchDto,
+	): Promise<MetadataBatchResponse> {
+		return this.registrationService.registerMetadataBatch(batch.items);
+	}
+
+	@ApiOperation({
+		summary: "Update files and their metadata",
+		description:
+			"Handles the metadata of several media files, in order. A file that cannot be updated does not prevent the next ones from being updated",
+	})
+	@Put("batch")
+	@Role(RoleEnum.Microservice, RoleEnum.Admin)
+	async updateFiles(
+		@Body() batch: MetadataBatchDto,
+	): Promise<MetadataBatchResponse> {
+		return this.registrationService.updateMetadataBatch(batch.items);
+	}
 }
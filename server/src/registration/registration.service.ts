@@ -41,6 +41,7 @@ import SettingsService from "src/settings/settings.service";
 import type TrackQueryParameters from "src/track/models/track.query-parameters";
 import TrackService from "src/track/track.service";
 import escapeRegex from "src/utils/escape-regex";
+import type { MetadataBatchResponse } from "./models/metadata-batch.dto";
 import type MetadataSavedResponse from "./models/metadata-saved.dto";
 import type MetadataDto from "./models/metadata.dto";
 
@@ -93,7 +94,48 @@ export class RegistrationService {
 		}
 	}
 
-	async updateMetadata(m: MetadataDto): Promise<MetadataSavedResponse> {
+	async registerMetadataBatch(
+		items: MetadataDto[],
+	): Promise<MetadataBatchResponse> {
+		return this.saveMetadataBatch(items, (m) => this.registerMetadata(m));
+	}
+
+	async updateMetadataBatch(
+		items: MetadataDto[],
+	): Promise<MetadataBatchResponse> {
+		const response = await this.saveMetadataBatch(items, (m) =>
+			this.updateMetadata(m, false),
+		);
+		await this.housekeepingService.runHousekeeping();
+		return response;
+	}
+
+	/**
+	 * Saves the files one after the other, so that they are handled like in single requests
+	 * The errors are returned in the response, instead of being thrown
+	 */
+	private async saveMetadataBatch(
+		items: MetadataDto[],
+		save: (m: MetadataDto) => Promise<MetadataSavedResponse>,
+	): Promise<MetadataBatchResponse> {
+		const results: MetadataBatchResponse["items"] = [];
+		for (const m of items) {
+			try {
+				results.push(await save(m));
+			} catch (error) {
+				this.logger.error(
+					`Saving '${m.path}' failed: ${(error as Error).message}`,
+				);
+				results.push({ error: (error as Error).message });
+			}
+		}
+		return { items: results };
+	}
+
+	async updateMetadata(
+		m: MetadataDto,
+		housekeeping = true,
+	): Promise<MetadataSavedResponse> {
 		const parentLibrary = await this.resolveLibraryFromFilePath(m.path);
 		if (!parentLibrary) {
 			throw new LibraryNotFoundException(m.path);
@@ -116,7 +158,9 @@ export class RegistrationService {
 			{ id: fileEntry.id },
 			{ checksum: m.checksum, registerDate: m.registrationDate },
 		);
-		await this.housekeepingService.runHousekeeping();
+		if (housekeeping) {
+			await this.housekeepingService.runHousekeeping();
+		}
 		return {
 			trackId: createdTrack.id,
 			sourceFileId: fileEntry.id,
@@ -28,11 +28,27 @@ import type { SearchStringInput } from "src/utils/search-string-input";
 import type { RequireExactlyOne } from "type-fest";
 
 namespace ReleaseQueryParameters {
+	/**
+	 * Identifiers of a release, read from its files
+	 */
+	export type Identifiers = Partial<
+		Pick<
+			Release,
+			| "musicbrainzReleaseId"
+			| "musicbrainzReleaseGroupId"
+			| "musicbrainzAlbumArtistIds"
+			| "barcode"
+			| "catalogNumber"
+			| "label"
+		>
+	>;
+
 	/**
 	 * Parameters to create a release
 	 */
 	export type CreateInput = Omit<
 		Release,
+		| keyof Identifiers
 		| "releaseDate"
 		| "album"
 		| "albumId"
@@ -47,7 +63,7 @@ namespace ReleaseQueryParameters {
 		registeredAt?: Date;
 		album: AlbumQueryParameters.WhereInput;
 		discogsId?: string;
-	};
+	} & Identifiers;
 
 	/**
 	 * Query parameters to find one release
@@ -70,7 +86,8 @@ namespace ReleaseQueryParameters {
 	/**
 	 * Parameters to update a Release
 	 */
-	export type UpdateInput = Partial<Pick<CreateInput, "releaseDate">>;
+	export type UpdateInput = Partial<Pick<CreateInput, "releaseDate">> &
+		Identifiers;
 
 	/**
 	 * Parameters to update the master release of an album
@@ -66,6 +66,12 @@ export class ReleaseResponseBuilder extends ResponseBuilderInterceptor<
 			releaseDate: release.releaseDate,
 			albumId: release.albumId,
 			registeredAt: release.registeredAt,
+			musicbrainzReleaseId: release.musicbrainzReleaseId,
+			musicbrainzReleaseGroupId: release.musicbrainzReleaseGroupId,
+			musicbrainzAlbumArtistIds: release.musicbrainzAlbumArtistIds,
+			barcode: release.barcode,
+			catalogNumber: release.catalogNumber,
+			label: release.label,
 			discs: release.discs
 				? release.discs.map(({ id, releaseId, ...disc }) => disc)
 				: release.discs,
@@ -89,6 +89,12 @@ export default class ReleaseService {
 				releaseDate: input.releaseDate,
 				extensions: input.extensions,
 				albumId: album.id,
+				musicbrainzReleaseId: input.musicbrainzReleaseId,
+				musicbrainzReleaseGroupId: input.musicbrainzReleaseGroupId,
+				musicbrainzAlbumArtistIds: input.musicbrainzAlbumArtistIds,
+				barcode: input.barcode,
+				catalogNumber: input.catalogNumber,
+				label: input.label,
 				externalMetadata: input.discogsId
 					? {
 							create: {
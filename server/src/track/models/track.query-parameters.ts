@@ -32,11 +32,55 @@ import type VideoQueryParameters from "src/video/models/video.query-parameters";
 import type { RequireAtLeastOne, RequireExactlyOne } from "type-fest";
 
 namespace TrackQueryParameters {
+	/**
+	 * Identifiers of a track, read from its file
+	 */
+	export type Identifiers = Partial<
+		Pick<
+			Track,
+			| "musicbrainzRecordingId"
+			| "musicbrainzTrackId"
+			| "musicbrainzArtistIds"
+			| "isrc"
+		>
+	>;
+
+	/**
+	 * ReplayGain values of a track, read from its file
+	 */
+	export type Loudness = Partial<
+		Pick<Track, "trackGain" | "trackPeak" | "albumGain" | "albumPeak">
+	>;
+
+	/**
+	 * Technical properties of a track, read from its file
+	 */
+	export type TechnicalProperties = Partial<
+		Pick<
+			Track,
+			| "container"
+			| "audioCodec"
+			| "lossless"
+			| "sampleRate"
+			| "bitDepth"
+			| "channels"
+			| "channelLayout"
+			| "videoCodec"
+			| "width"
+			| "height"
+			| "frameRate"
+			| "hdrFormat"
+		>
+	>;
+
 	/**
 	 * The input required to save a track in the database
 	 */
 	export type CreateInput = Omit<
 		Track,
+		| keyof Identifiers
+		| keyof Loudness
+		| keyof TechnicalProperties
 		| "id"
 		| "sourceFile"
 		| "sourceFileId"
@@ -55,7 +99,7 @@ namespace TrackQueryParameters {
 		release?: ReleaseQueryParameters.WhereInput;
 		song?: SongQueryParameters.WhereInput;
 		video?: VideoQueryParameters.WhereInput;
-	};
+	} & Identifiers & Loudness & TechnicalProperties;
 
 	/**
 	 * Query parameters to find one track
@@ -85,6 +85,26 @@ export class TrackResponseBuilder extends ResponseBuilderInterceptor<
 			isBonus: track.isBonus,
 			isRemastered: track.isRemastered,
 			sourceFileId: track.sourceFileId,
+			musicbrainzRecordingId: track.musicbrainzRecordingId,
+			musicbrainzTrackId: track.musicbrainzTrackId,
+			musicbrainzArtistIds: track.musicbrainzArtistIds,
+			isrc: track.isrc,
+			trackGain: track.trackGain,
+			trackPeak: track.trackPeak,
+			albumGain: track.albumGain,
+			albumPeak: track.albumPeak,
+			container: track.container,
+			audioCodec: track.audioCodec,
+			lossless: track.lossless,
+			sampleRate: track.sampleRate,
+			bitDepth: track.bitDepth,
+			channels: track.channels,
+			channelLayout: track.channelLayout,
+			videoCodec: track.videoCodec,
+			width: track.width,
+			height: track.height,
+			frameRate: track.frameRate,
+			hdrFormat: track.hdrFormat,
 			video: track.video
 				? await this.videoResponseBuilder.buildResponse(track.video)
 				: track.video,